@@ -11,13 +11,18 @@ import (
 
 	"github.com/alexedwards/flow"
 	"github.com/kartpop/cruncan/backend/one/config"
+	"github.com/kartpop/cruncan/backend/one/database/idempotency"
 	"github.com/kartpop/cruncan/backend/one/database/onerequest"
+	"github.com/kartpop/cruncan/backend/one/database/outbox"
 	oneHttp "github.com/kartpop/cruncan/backend/one/http"
+	"github.com/kartpop/cruncan/backend/one/relay"
+	"github.com/kartpop/cruncan/backend/pkg/auth"
 	cfgUtil "github.com/kartpop/cruncan/backend/pkg/config"
 	gormUtil "github.com/kartpop/cruncan/backend/pkg/database/gorm"
 	"github.com/kartpop/cruncan/backend/pkg/id"
 	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
 	"github.com/kartpop/cruncan/backend/pkg/otel"
+	"github.com/kartpop/cruncan/backend/pkg/queue"
 	"github.com/kartpop/cruncan/backend/pkg/util"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"gorm.io/gorm"
@@ -27,11 +32,21 @@ var tracerName = "github.com/kartpop/cruncan/backend/one/cmd/api-tracer"
 var meterName = "github.com/kartpop/cruncan/backend/one/cmd/api-meter"
 
 type Application struct {
+	ctx         context.Context
 	name        string
 	cfg         *config.Model
 	oneHandler  *oneHttp.Handler
+	outboxRelay *relay.Relay
 	kafkaClient *kafkaUtil.Client
 	gormClient  *gorm.DB
+	queueGorm   *gorm.DB
+	// authenticator is nil if authentication is disabled
+	authenticator *auth.Authenticator
+	// idempotencyKeys and outboxMessages purge the expired idempotency keys and the sent outbox messages while
+	// the app runs
+	idempotencyKeys *idempotency.Repository
+	outboxMessages  *outbox.RepositoryImpl
+	stopPurging     context.CancelFunc
 }
 
 func NewApplication(ctx context.Context, name string, cfg *config.Model) *Application {
@@ -45,46 +60,135 @@ func NewApplication(ctx context.Context, name string, cfg *config.Model) *Applic
 		util.Fatal("failed to create id service: %v", err)
 	}
 
-	kafkaClient, err := kafkaUtil.NewClient(cfg.Kafka.Common)
-	if err != nil {
-		util.Fatal("failed to create kafka client: %v", err)
+	var kafkaClient *kafkaUtil.Client
+	var queueGorm *gorm.DB
+	var broker queue.Broker
+	switch cfg.Queue.Backend {
+	case "", queue.BackendKafka:
+		kafkaClient, err = kafkaUtil.NewClient(ctx, cfg.Kafka.Common)
+		if err != nil {
+			util.Fatal("failed to create kafka client: %v", err)
+		}
+		if cfg.Kafka.ProvisionTopics {
+			provisionTopics(ctx, kafkaClient, cfg.Kafka.OneRequestTopic)
+		}
+		broker = queue.NewKafkaBroker(kafkaClient)
+	case queue.BackendPostgres:
+		// the queue table is created by the migrations of one, next to the outbox
+		queueDB := gormClient
+		if cfg.Queue.Database != nil {
+			queueGorm, err = gormUtil.NewGormClient(cfg.Queue.Database)
+			if err != nil {
+				util.Fatal("queue database not available on startup: %v", err)
+			}
+			queueDB = queueGorm
+		}
+		broker = queue.NewPostgresBroker(queueDB, cfg.Queue)
+	case queue.BackendMemory:
+		// two runs in another process and would never see the messages
+		util.Fatal("queue backend %q only works within one process, use %q or %q", cfg.Queue.Backend, queue.BackendKafka, queue.BackendPostgres)
+	default:
+		util.Fatal("unknown queue backend %q", cfg.Queue.Backend)
 	}
-	oneRequestProducer := kafkaClient.NewProducer(cfg.Kafka.OneRequestTopic.Name)
+	outboxMessages := outbox.NewRepository(gormClient)
+	outboxRepo := outboxMessages.WithTracing()
+	outboxRelay := relay.NewRelay(ctx, outboxRepo, map[string]relay.Producer{
+		cfg.Kafka.OneRequestTopic.Name: broker.Publisher(cfg.Kafka.OneRequestTopic.Name),
+	}, time.Duration(cfg.Outbox.PollInterval)*time.Millisecond, cfg.Outbox.BatchSize)
+
 	oneRequestRepo := onerequest.NewRepository(gormClient).WithTracing()
-	oneHandler := oneHttp.NewHandler(ctx, oneRequestRepo, idService, oneRequestProducer)
+	oneHandler := oneHttp.NewHandler(ctx, oneRequestRepo, idService, cfg.Kafka.OneRequestTopic.Name, cfg.Idempotency.Window)
+
+	var authenticator *auth.Authenticator
+	if cfg.Auth.Enabled {
+		authenticator, err = auth.NewAuthenticator(ctx, cfg.Auth, auth.WithUnauthorizedHandler(oneHttp.Unauthorized))
+		if err != nil {
+			util.Fatal("failed to create authenticator: %v", err)
+		}
+	} else {
+		slog.WarnContext(ctx, "authentication is disabled, /one accepts requests for any user")
+	}
 
 	return &Application{
-		name:        name,
-		cfg:         cfg,
-		oneHandler:  oneHandler,
-		kafkaClient: kafkaClient,
-		gormClient:  gormClient,
+		ctx:             ctx,
+		name:            name,
+		cfg:             cfg,
+		oneHandler:      oneHandler,
+		authenticator:   authenticator,
+		outboxRelay:     outboxRelay,
+		kafkaClient:     kafkaClient,
+		gormClient:      gormClient,
+		queueGorm:       queueGorm,
+		idempotencyKeys: idempotency.NewRepository(gormClient),
+		outboxMessages:  outboxMessages,
 	}
 }
 
 func (app *Application) Run() []util.TerminatorFunc {
+	app.outboxRelay.Start(app.ctx)
+	purgeCtx, cancel := context.WithCancel(app.ctx)
+	app.stopPurging = cancel
+	go app.idempotencyKeys.PurgeEvery(purgeCtx, app.cfg.Idempotency.PurgeInterval)
+	go app.outboxMessages.PurgeEvery(purgeCtx, app.cfg.Outbox.PurgeInterval, app.cfg.Outbox.Retention)
+
 	return []util.TerminatorFunc{
 		func(ctx context.Context) error {
-			app.kafkaClient.Close()
-			return nil
-		},
-		func(ctx context.Context) error {
-			gormDB, err := app.gormClient.DB()
-			if err != nil {
-				return err
+			// the terminators run concurrently, so shut down in one of them: stop relaying before the
+			// broker is closed underneath it, and stop purging before the databases are closed
+			err := app.outboxRelay.Stop(ctx)
+			if app.kafkaClient != nil {
+				err = errors.Join(err, app.kafkaClient.Close(ctx))
 			}
-			return gormDB.Close()
+			app.stopPurging()
+			return errors.Join(err, closeGorm(app.queueGorm), closeGorm(app.gormClient))
 		},
 	}
 }
 
+// closeGorm closes the connections of the gorm client, if there is one
+func closeGorm(gormClient *gorm.DB) error {
+	if gormClient == nil {
+		return nil
+	}
+	gormDB, err := gormClient.DB()
+	if err != nil {
+		return err
+	}
+	return gormDB.Close()
+}
+
 func (app *Application) routes() http.Handler {
 	mux := flow.New()
+	if app.authenticator != nil {
+		mux.Use(app.authenticator.Middleware)
+	}
 	mux.HandleFunc("/one", app.oneHandler.Post, http.MethodPost)
+	mux.HandleFunc("/one", app.oneHandler.List, http.MethodGet)
+	mux.HandleFunc("/one/:id", app.oneHandler.Get, http.MethodGet)
 
 	return mux
 }
 
+// provisionTopics ensures the topics exist before the application uses them. Drift that cannot be
+// fixed automatically is logged but does not stop the application.
+func provisionTopics(ctx context.Context, kafkaClient *kafkaUtil.Client, topics ...kafkaUtil.Topic) {
+	report, err := kafkaClient.NewAdmin().EnsureTopics(ctx, topics...)
+	if err != nil {
+		util.Fatal("failed to provision kafka topics: %v", err)
+	}
+	for _, topic := range report.Created {
+		slog.InfoContext(ctx, fmt.Sprintf("created kafka topic %s", topic))
+	}
+	for _, drift := range report.Drift {
+		msg := fmt.Sprintf("kafka topic %s %s is %s, configured %s", drift.Topic, drift.Setting, drift.Actual, drift.Expected)
+		if drift.Fixed {
+			slog.InfoContext(ctx, msg+", updated")
+		} else {
+			slog.WarnContext(ctx, msg)
+		}
+	}
+}
+
 func main() {
 	ctx, cancel := otel.Setup(tracerName, meterName)
 	defer cancel()
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"time"
+
+	"github.com/kartpop/cruncan/backend/pkg/auth"
 	gormUtil "github.com/kartpop/cruncan/backend/pkg/database/gorm"
 	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	"github.com/kartpop/cruncan/backend/pkg/queue"
 )
 
 type Model struct {
@@ -13,6 +17,13 @@ type Model struct {
 	Server   ServerConfig     `mapstructure:"SERVER"`
 	Database *gormUtil.Config `mapstructure:"DATABASE"`
 	Kafka    KafkaConfig      `mapstructure:"KAFKA_CONFIG"`
+	Outbox   OutboxConfig     `mapstructure:"OUTBOX"`
+	// Idempotency configures the Idempotency-Key header of POST /one
+	Idempotency IdempotencyConfig `mapstructure:"IDEMPOTENCY"`
+	// Queue selects the broker the outbox is relayed to, the postgres backend defaults to Database
+	Queue queue.Config `mapstructure:"QUEUE"`
+	// Auth requires a bearer JWT on the /one endpoints, whose principal has to match the user_id of a request
+	Auth auth.Config `mapstructure:"AUTH"`
 }
 
 type ServerConfig struct {
@@ -25,4 +36,22 @@ type ServerConfig struct {
 type KafkaConfig struct {
 	Common          *kafkaUtil.Config `mapstructure:"COMMON"`
 	OneRequestTopic kafkaUtil.Topic   `mapstructure:"ONE_REQUEST_TOPIC"`
+	// ProvisionTopics creates the topics and grows their partitions on startup
+	ProvisionTopics bool `mapstructure:"PROVISION_TOPICS"`
+}
+
+type OutboxConfig struct {
+	PollInterval int `mapstructure:"POLL_INTERVAL_MS"`
+	BatchSize    int `mapstructure:"BATCH_SIZE"`
+	// Retention is how long sent messages are kept, defaults to 24h
+	Retention time.Duration `mapstructure:"RETENTION"`
+	// PurgeInterval is how often the messages sent longer than Retention ago are deleted, defaults to 1h
+	PurgeInterval time.Duration `mapstructure:"PURGE_INTERVAL"`
+}
+
+type IdempotencyConfig struct {
+	// Window is how long the response of an idempotency key is replayed, defaults to 24h
+	Window time.Duration `mapstructure:"WINDOW"`
+	// PurgeInterval is how often the keys whose window ended are deleted, defaults to 1h
+	PurgeInterval time.Duration `mapstructure:"PURGE_INTERVAL"`
 }
@@ -0,0 +1,26 @@
+package idempotency
+
+import "time"
+
+// HeaderKey is the request header that carries the idempotency key of a client
+const HeaderKey = "Idempotency-Key"
+
+// Key is an idempotency key with the fingerprint of the request that first used it and the response to replay.
+// It is stored in the same transaction as the request it belongs to.
+type Key struct {
+	// UserID scopes the key to the user of the request, so a user cannot probe for or replay the keys of others
+	UserID string `gorm:"type:text;primary_key;" json:"user_id"`
+	Key    string `gorm:"type:text;primary_key;" json:"key"`
+	// Fingerprint identifies the request body, the key must not be reused with another body
+	Fingerprint string    `gorm:"type:text not null" json:"fingerprint"`
+	ReqID       string    `gorm:"type:text not null" json:"req_id"`
+	Status      int       `gorm:"type:integer not null" json:"status"`
+	Response    []byte    `gorm:"type:bytea not null" json:"response"`
+	CreatedAt   time.Time `gorm:"type:timestamptz not null" json:"created_at"`
+	// ExpiresAt ends the window in which the response is replayed, afterwards the key can be used again
+	ExpiresAt time.Time `gorm:"type:timestamptz not null" json:"expires_at"`
+}
+
+func (Key) TableName() string {
+	return "idempotency_key"
+}
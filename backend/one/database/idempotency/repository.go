@@ -0,0 +1,54 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultPurgeInterval is how often expired keys are deleted by default, see Repository.PurgeEvery
+const DefaultPurgeInterval = time.Hour
+
+// Repository deletes expired keys. Keys are created along with their request, see
+// onerequest.Repository.CreateIdempotent, and an expired key is taken over by the next request that uses it.
+// Keys that are not used again are only removed by a purge.
+type Repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// Purge deletes the expired keys and returns how many were deleted
+func (r *Repository) Purge(ctx context.Context) (int64, error) {
+	res := r.db.WithContext(ctx).Where("expires_at <= now()").Delete(&Key{})
+	return res.RowsAffected, res.Error
+}
+
+// PurgeEvery purges the expired keys every interval until the context is done, a failed purge is retried on
+// the next tick. An interval that is not positive defaults to DefaultPurgeInterval.
+func (r *Repository) PurgeEvery(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultPurgeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			purged, err := r.Purge(ctx)
+			if err != nil {
+				slog.WarnContext(ctx, fmt.Sprintf("failed to purge expired idempotency keys: %v", err))
+				continue
+			}
+			slog.DebugContext(ctx, fmt.Sprintf("purged %d expired idempotency keys", purged))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
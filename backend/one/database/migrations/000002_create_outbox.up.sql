@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id text NOT NULL,
+    aggregate_id text NOT NULL,
+    topic text NOT NULL,
+    payload bytea NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    sent_at timestamp with time zone,
+    trace_context jsonb,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL;
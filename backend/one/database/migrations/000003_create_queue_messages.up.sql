@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS queue_messages (
+    id bigserial NOT NULL,
+    topic text NOT NULL,
+    key bytea,
+    value bytea,
+    headers jsonb NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    available_at timestamp with time zone NOT NULL DEFAULT now(),
+    attempts integer NOT NULL DEFAULT 0,
+    last_error text,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS queue_messages_due_idx ON queue_messages (topic, available_at);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS request_user_id_req_id_idx;
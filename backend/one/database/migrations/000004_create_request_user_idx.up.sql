@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS request_user_id_req_id_idx ON request (user_id, req_id);
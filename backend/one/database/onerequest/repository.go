@@ -3,11 +3,17 @@ package onerequest
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"time"
 
+	"github.com/kartpop/cruncan/backend/one/database/idempotency"
+	"github.com/kartpop/cruncan/backend/one/database/outbox"
 	"gorm.io/gorm"
 )
 
+// ErrNotFound is returned by Get when no request has the ID
+var ErrNotFound = errors.New("one request not found")
+
 type OneRequest struct {
 	ReqID     string          `gorm:"type:text;primary_key;" json:"req_id"`
 	UserID    string          `gorm:"type:text" json:"user_id"`
@@ -20,9 +26,31 @@ func (OneRequest) TableName() string {
 	return "request"
 }
 
+// ListFilter selects the requests of a user for List. Zero values leave a filter out.
+type ListFilter struct {
+	UserID string
+	// Before is the ID of the last request of the previous page, List returns the requests with smaller IDs
+	Before string
+	// CreatedAfter and CreatedBefore bound the creation time, inclusive and exclusive
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Limit         int
+}
+
 type Repository interface {
 	Create(ctx context.Context, req *OneRequest) error
+	// CreateWithOutbox stores the request and its outbox message in a single transaction
+	CreateWithOutbox(ctx context.Context, req *OneRequest, msg *outbox.Message) error
+	// CreateIdempotent stores the request and its outbox message like CreateWithOutbox, together with the
+	// idempotency key, which expires after the window. Keys are scoped by their user. If the user stored the key
+	// before and it has not expired, nothing is stored and the stored key is returned instead. A concurrent
+	// request with the same user and key waits until the first one committed or rolled back.
+	CreateIdempotent(ctx context.Context, req *OneRequest, msg *outbox.Message, key *idempotency.Key, window time.Duration) (*idempotency.Key, error)
+	// Get returns the request with the ID, or ErrNotFound
 	Get(ctx context.Context, reqId string) (*OneRequest, error)
+	// List returns up to filter.Limit requests of a user, newest first. Snowflake IDs sort by creation time, so
+	// the requests are ordered and paged by ID.
+	List(ctx context.Context, filter ListFilter) ([]*OneRequest, error)
 }
 
 type RepositoryImpl struct {
@@ -43,8 +71,72 @@ func (r *RepositoryImpl) Create(ctx context.Context, req *OneRequest) error {
 	return r.db.WithContext(ctx).Create(req).Error
 }
 
+func (r *RepositoryImpl) CreateWithOutbox(ctx context.Context, req *OneRequest, msg *outbox.Message) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(req).Error; err != nil {
+			return err
+		}
+		return tx.Create(msg).Error
+	})
+}
+
+func (r *RepositoryImpl) CreateIdempotent(ctx context.Context, req *OneRequest, msg *outbox.Message, key *idempotency.Key, window time.Duration) (*idempotency.Key, error) {
+	var stored *idempotency.Key
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// the insert waits on the primary key for a concurrent transaction with the same user and key, and an
+		// expired key is taken over. Expiry uses the database clock only, so the clocks of the replicas do not
+		// matter.
+		res := tx.Exec(`INSERT INTO idempotency_key (user_id, key, fingerprint, req_id, status, response, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, now(), now() + ?::double precision * interval '1 second')
+			ON CONFLICT (user_id, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, req_id = EXCLUDED.req_id,
+				status = EXCLUDED.status, response = EXCLUDED.response, created_at = EXCLUDED.created_at,
+				expires_at = EXCLUDED.expires_at
+			WHERE idempotency_key.expires_at <= now()`,
+			key.UserID, key.Key, key.Fingerprint, key.ReqID, key.Status, key.Response, window.Seconds())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			stored = &idempotency.Key{}
+			return tx.Where("user_id = ? AND key = ?", key.UserID, key.Key).Take(stored).Error
+		}
+
+		if err := tx.Create(req).Error; err != nil {
+			return err
+		}
+		return tx.Create(msg).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
 func (r *RepositoryImpl) Get(ctx context.Context, reqId string) (*OneRequest, error) {
 	var oneReq OneRequest
 	err := r.db.WithContext(ctx).Where("req_id = ?", reqId).First(&oneReq).Error
-	return &oneReq, err
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &oneReq, nil
+}
+
+func (r *RepositoryImpl) List(ctx context.Context, filter ListFilter) ([]*OneRequest, error) {
+	query := r.db.WithContext(ctx).Where("user_id = ?", filter.UserID)
+	if filter.Before != "" {
+		query = query.Where("req_id < ?", filter.Before)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		query = query.Where("created_at >= ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", filter.CreatedBefore)
+	}
+
+	var oneReqs []*OneRequest
+	err := query.Order("req_id DESC").Limit(filter.Limit).Find(&oneReqs).Error
+	return oneReqs, err
 }
@@ -3,9 +3,13 @@ package onerequest
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/kartpop/cruncan/backend/one/database/idempotency"
+	"github.com/kartpop/cruncan/backend/one/database/outbox"
 	"github.com/kartpop/cruncan/backend/pkg/otel"
 	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type TracingRepository struct {
@@ -33,6 +37,37 @@ func (r *TracingRepository) Create(ctx context.Context, req *OneRequest) error {
 	return err
 }
 
+func (r *TracingRepository) CreateWithOutbox(ctx context.Context, req *OneRequest, msg *outbox.Message) error {
+	tracer, _ := otelContext.Tracer(ctx)
+	ctx, span := tracer.Start(ctx, "oneRequest.CreateWithOutbox")
+	defer span.End()
+
+	err := r.repo.CreateWithOutbox(ctx, req, msg)
+	if err != nil {
+		otel.SetSpanErrorWithMessage(span, err, fmt.Sprintf("failed to create one request with outbox message: %v", err))
+	} else {
+		otel.SetSpanOk(span)
+	}
+
+	return err
+}
+
+func (r *TracingRepository) CreateIdempotent(ctx context.Context, req *OneRequest, msg *outbox.Message, key *idempotency.Key, window time.Duration) (*idempotency.Key, error) {
+	tracer, _ := otelContext.Tracer(ctx)
+	ctx, span := tracer.Start(ctx, "oneRequest.CreateIdempotent")
+	defer span.End()
+
+	stored, err := r.repo.CreateIdempotent(ctx, req, msg, key, window)
+	if err != nil {
+		otel.SetSpanErrorWithMessage(span, err, fmt.Sprintf("failed to create one request with idempotency key: %v", err))
+	} else {
+		span.SetAttributes(attribute.Bool("idempotency.replayed", stored != nil))
+		otel.SetSpanOk(span)
+	}
+
+	return stored, err
+}
+
 func (r *TracingRepository) Get(ctx context.Context, id string) (*OneRequest, error) {
 	tracer, _ := otelContext.Tracer(ctx)
 	ctx, span := tracer.Start(ctx, "oneRequest.Get")
@@ -47,3 +82,18 @@ func (r *TracingRepository) Get(ctx context.Context, id string) (*OneRequest, er
 
 	return oneReq, err
 }
+
+func (r *TracingRepository) List(ctx context.Context, filter ListFilter) ([]*OneRequest, error) {
+	tracer, _ := otelContext.Tracer(ctx)
+	ctx, span := tracer.Start(ctx, "oneRequest.List")
+	defer span.End()
+
+	oneReqs, err := r.repo.List(ctx, filter)
+	if err != nil {
+		otel.SetSpanErrorWithMessage(span, err, fmt.Sprintf("failed to list one requests: %v", err))
+	} else {
+		otel.SetSpanOk(span)
+	}
+
+	return oneReqs, err
+}
@@ -0,0 +1,163 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultRetention is how long sent messages are kept by default, see RepositoryImpl.PurgeEvery
+	DefaultRetention = 24 * time.Hour
+	// DefaultPurgeInterval is how often sent messages are purged by default, see RepositoryImpl.PurgeEvery
+	DefaultPurgeInterval = time.Hour
+)
+
+// Message is a kafka message waiting to be relayed. It is written in the same transaction as the
+// aggregate it belongs to, so the message exists if and only if the aggregate was stored.
+type Message struct {
+	ID          string     `gorm:"type:text;primary_key;" json:"id"`
+	AggregateID string     `gorm:"type:text" json:"aggregate_id"`
+	Topic       string     `gorm:"type:text" json:"topic"`
+	Payload     []byte     `gorm:"type:bytea not null" json:"payload"`
+	CreatedAt   time.Time  `gorm:"type:timestamptz not null" json:"created_at"`
+	SentAt      *time.Time `gorm:"type:timestamptz" json:"sent_at"`
+	// TraceContext is the W3C trace context and baggage of the request that stored the message, see
+	// InjectTraceContext
+	TraceContext json.RawMessage `gorm:"type:jsonb" json:"trace_context"`
+}
+
+func (Message) TableName() string {
+	return "outbox"
+}
+
+// InjectTraceContext stores the trace context and baggage of the context on the message, so it is published as
+// part of the trace that stored it rather than the trace of the relay
+func (m *Message) InjectTraceContext(ctx context.Context) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+	// a map of strings always marshals
+	m.TraceContext, _ = json.Marshal(carrier)
+}
+
+// ExtractTraceContext returns a copy of the context with the trace context and baggage stored on the message, the
+// context is returned as is if the message has none
+func (m *Message) ExtractTraceContext(ctx context.Context) context.Context {
+	if len(m.TraceContext) == 0 {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{}
+	if err := json.Unmarshal(m.TraceContext, &carrier); err != nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// PublishFunc publishes a batch of outbox messages in order. It returns how many messages, from the first,
+// were published, and the error of the first message that was not.
+type PublishFunc func(ctx context.Context, msgs []*Message) (int, error)
+
+type Repository interface {
+	// ProcessPending locks up to limit pending messages, oldest first, and calls publish with all of them.
+	// The messages before the first one that failed are marked as sent, the rest stay pending so that the
+	// order of messages is kept. Returns the number of messages marked as sent.
+	ProcessPending(ctx context.Context, limit int, publish PublishFunc) (int, error)
+}
+
+type RepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *RepositoryImpl {
+	return &RepositoryImpl{
+		db: db,
+	}
+}
+
+func (r *RepositoryImpl) WithTracing() *TracingRepository {
+	return NewTracingRepository(r)
+}
+
+func (r *RepositoryImpl) ProcessPending(ctx context.Context, limit int, publish PublishFunc) (int, error) {
+	var sent []string
+	var publishErr error
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var msgs []*Message
+		// SKIP LOCKED lets multiple replicas relay concurrently without publishing the same row twice
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sent_at IS NULL").
+			Order("id").
+			Limit(limit).
+			Find(&msgs).Error
+		if err != nil {
+			return err
+		}
+
+		if len(msgs) == 0 {
+			return nil
+		}
+		var published int
+		published, publishErr = publish(ctx, msgs)
+		for _, msg := range msgs[:published] {
+			sent = append(sent, msg.ID)
+		}
+
+		if len(sent) == 0 {
+			return nil
+		}
+		return tx.Model(&Message{}).Where("id IN ?", sent).Update("sent_at", time.Now().UTC()).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(sent), publishErr
+}
+
+// Purge deletes the messages that were sent at least retention ago and returns how many were deleted. Pending
+// messages are never deleted.
+func (r *RepositoryImpl) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Where("sent_at IS NOT NULL AND sent_at <= ?", time.Now().UTC().Add(-retention)).
+		Delete(&Message{})
+	return res.RowsAffected, res.Error
+}
+
+// PurgeEvery purges the messages sent at least retention ago every interval until the context is done, a failed
+// purge is retried on the next tick. An interval or retention that is not positive defaults to
+// DefaultPurgeInterval or DefaultRetention.
+func (r *RepositoryImpl) PurgeEvery(ctx context.Context, interval, retention time.Duration) {
+	if interval <= 0 {
+		interval = DefaultPurgeInterval
+	}
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			purged, err := r.Purge(ctx, retention)
+			if err != nil {
+				slog.WarnContext(ctx, fmt.Sprintf("failed to purge sent outbox messages: %v", err))
+				continue
+			}
+			slog.DebugContext(ctx, fmt.Sprintf("purged %d sent outbox messages", purged))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kartpop/cruncan/backend/pkg/otel"
+	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+)
+
+type TracingRepository struct {
+	repo Repository
+}
+
+func NewTracingRepository(repo Repository) *TracingRepository {
+	return &TracingRepository{
+		repo: repo,
+	}
+}
+
+func (r *TracingRepository) ProcessPending(ctx context.Context, limit int, publish PublishFunc) (int, error) {
+	tracer, _ := otelContext.Tracer(ctx)
+	ctx, span := tracer.Start(ctx, "outbox.ProcessPending")
+	defer span.End()
+
+	sent, err := r.repo.ProcessPending(ctx, limit, publish)
+	if err != nil {
+		otel.SetSpanErrorWithMessage(span, err, fmt.Sprintf("failed to process pending outbox messages: %v", err))
+	} else {
+		otel.SetSpanOk(span)
+	}
+
+	return sent, err
+}
@@ -2,17 +2,30 @@ package http
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"github.com/alexedwards/flow"
+	"github.com/kartpop/cruncan/backend/one/database/idempotency"
 	onerequest "github.com/kartpop/cruncan/backend/one/database/onerequest"
+	"github.com/kartpop/cruncan/backend/one/database/outbox"
+	"github.com/kartpop/cruncan/backend/pkg/auth"
 	"github.com/kartpop/cruncan/backend/pkg/id"
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
 	"github.com/kartpop/cruncan/backend/pkg/model"
 	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
 	"github.com/kartpop/cruncan/backend/pkg/util"
+	"github.com/kartpop/cruncan/backend/pkg/validation"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
@@ -25,37 +38,102 @@ const (
 	SuccessPostMeterName = "http.handlers.Post.success"
 	// HandledPostMeterName is the name of the handled post meter
 	HandledPostMeterName = "http.handlers.Post.handled"
+	// ReplayedPostMeterName is the name of the meter of posts answered with the response stored for their
+	// idempotency key
+	ReplayedPostMeterName = "http.handlers.Post.replayed"
+	// FailedGetMeterName is the name of the failed get meter
+	FailedGetMeterName = "http.handlers.Get.failed"
+	// SuccessGetMeterName is the name of the success get meter
+	SuccessGetMeterName = "http.handlers.Get.success"
+	// HandledGetMeterName is the name of the handled get meter
+	HandledGetMeterName = "http.handlers.Get.handled"
+	// FailedListMeterName is the name of the failed list meter
+	FailedListMeterName = "http.handlers.List.failed"
+	// SuccessListMeterName is the name of the success list meter
+	SuccessListMeterName = "http.handlers.List.success"
+	// HandledListMeterName is the name of the handled list meter
+	HandledListMeterName = "http.handlers.List.handled"
 
-	ErrFailedToReadRequestBody       = "failed to read request body"
-	ErrFailedToParseOneRequest       = "failed to parse OneRequest json"
-	ErrFailedToSendKafkaMessage      = "failed to send message to kafka"
-	ErrFailedToSaveRequestToDatabase = "failed to save request to database"
-	ErrFailedToMarshalResponse       = "failed to marshal response"
+	// HeaderIdempotentReplayed is set on a response that was replayed for an idempotency key
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+	// DefaultIdempotencyWindow is how long the response of an idempotency key is replayed by default
+	DefaultIdempotencyWindow = 24 * time.Hour
+	// MaxIdempotencyKeyLength bounds the length of an idempotency key
+	MaxIdempotencyKeyLength = 255
+	// MaxRequestBodySize bounds the size of the body of POST /one in bytes, it leaves room for the largest
+	// request that passes validation
+	MaxRequestBodySize = 1 << 20
+
+	// DefaultListLimit is the page size of List without a limit
+	DefaultListLimit = 20
+	// MaxListLimit bounds the page size of List
+	MaxListLimit = 100
+
+	ErrFailedToReadRequestBody          = "failed to read request body"
+	ErrRequestBodyTooLarge              = "request body too large"
+	ErrFailedToParseOneRequest          = "failed to parse OneRequest json"
+	ErrInvalidOneRequest                = "invalid OneRequest"
+	ErrFailedToEncodeOneRequest         = "failed to encode OneRequest message"
+	ErrFailedToSaveRequestToDatabase    = "failed to save request to database"
+	ErrFailedToMarshalResponse          = "failed to marshal response"
+	ErrInvalidRequestID                 = "invalid request id"
+	ErrRequestNotFound                  = "request not found"
+	ErrFailedToGetRequestFromDatabase   = "failed to get request from database"
+	ErrInvalidQueryParameter            = "invalid query parameter"
+	ErrFailedToListRequestsFromDatabase = "failed to list requests from database"
+	ErrInvalidIdempotencyKey            = "invalid idempotency key"
+	ErrIdempotencyKeyReused             = "idempotency key was used with another request"
+	ErrUnauthenticated                  = "missing or invalid bearer token"
+	ErrForbiddenUser                    = "user_id does not match the authenticated principal"
 )
 
 type Response struct {
 	ReqID string `json:"request_id"`
 }
 
-type Producer interface {
-	SendMessage(ctx context.Context, message []byte) error
-	Close()
+// ListResponse is a page of the requests of a user. NextCursor fetches the next page, it is empty on the last.
+type ListResponse struct {
+	Requests   []GetResponse `json:"requests"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// GetResponse is a stored request, Req is the submitted body
+type GetResponse struct {
+	ReqID     string          `json:"request_id"`
+	UserID    string          `json:"user_id"`
+	Req       json.RawMessage `json:"request"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
 }
 
 type Handler struct {
-	repo             onerequest.Repository
-	idService        id.Service
-	logger           *slog.Logger
-	kafkaProd        Producer
-	tracer           trace.Tracer
-	successPostMeter metric.Int64Counter
-	failedPostMeter  metric.Int64Counter
-	handledPostMeter metric.Int64Counter
+	repo              onerequest.Repository
+	idService         id.Service
+	logger            *slog.Logger
+	topic             string
+	idempotencyWindow time.Duration
+	codec             kafkaUtil.Codec[model.OneRequest]
+	tracer            trace.Tracer
+	successPostMeter  metric.Int64Counter
+	failedPostMeter   metric.Int64Counter
+	handledPostMeter  metric.Int64Counter
+	replayedPostMeter metric.Int64Counter
+	successGetMeter   metric.Int64Counter
+	failedGetMeter    metric.Int64Counter
+	handledGetMeter   metric.Int64Counter
+	successListMeter  metric.Int64Counter
+	failedListMeter   metric.Int64Counter
+	handledListMeter  metric.Int64Counter
 }
 
-func NewHandler(ctx context.Context, repo onerequest.Repository, idService id.Service, kafkaProd Producer) *Handler {
+// NewHandler creates a new handler. Accepted requests are published to topic through the outbox. Posts with an
+// Idempotency-Key header are answered with the stored response if the key was used within the idempotency window.
+func NewHandler(ctx context.Context, repo onerequest.Repository, idService id.Service, topic string, idempotencyWindow time.Duration) *Handler {
 	tracer, _ := otelContext.Tracer(ctx)
 	meter, _ := otelContext.Meter(ctx)
+	if idempotencyWindow <= 0 {
+		idempotencyWindow = DefaultIdempotencyWindow
+	}
 
 	validInt64Counter := func(name string) metric.Int64Counter {
 		c, err := meter.Int64Counter(name)
@@ -66,14 +144,23 @@ func NewHandler(ctx context.Context, repo onerequest.Repository, idService id.Se
 	}
 
 	return &Handler{
-		repo:             repo,
-		idService:        idService,
-		logger:           slog.Default(),
-		kafkaProd:        kafkaProd,
-		tracer:           tracer,
-		successPostMeter: validInt64Counter(SuccessPostMeterName),
-		failedPostMeter:  validInt64Counter(FailedPostMeterName),
-		handledPostMeter: validInt64Counter(HandledPostMeterName),
+		repo:              repo,
+		idService:         idService,
+		logger:            slog.Default(),
+		topic:             topic,
+		idempotencyWindow: idempotencyWindow,
+		codec:             kafkaUtil.JSONCodec[model.OneRequest]{},
+		tracer:            tracer,
+		successPostMeter:  validInt64Counter(SuccessPostMeterName),
+		failedPostMeter:   validInt64Counter(FailedPostMeterName),
+		handledPostMeter:  validInt64Counter(HandledPostMeterName),
+		replayedPostMeter: validInt64Counter(ReplayedPostMeterName),
+		successGetMeter:   validInt64Counter(SuccessGetMeterName),
+		failedGetMeter:    validInt64Counter(FailedGetMeterName),
+		handledGetMeter:   validInt64Counter(HandledGetMeterName),
+		successListMeter:  validInt64Counter(SuccessListMeterName),
+		failedListMeter:   validInt64Counter(FailedListMeterName),
+		handledListMeter:  validInt64Counter(HandledListMeterName),
 	}
 }
 
@@ -84,69 +171,317 @@ func (h *Handler) Post(w http.ResponseWriter, r *http.Request) {
 	ctx, span := h.tracer.Start(ctx, "http.handlers.Post", trace.WithSpanKind(trace.SpanKindServer))
 	defer span.End()
 
+	key := r.Header.Get(idempotency.HeaderKey)
+	if len(key) > MaxIdempotencyKeyLength {
+		errMsg := fmt.Sprintf("%s: longer than %d characters", ErrInvalidIdempotencyKey, MaxIdempotencyKeyLength)
+		writeProblem(w, Problem{Status: http.StatusBadRequest, Code: CodeInvalidIdempotencyKey, Detail: errMsg})
+		h.logAndMonitorError(ctx, errMsg, span, errors.New(ErrInvalidIdempotencyKey), h.failedPostMeter)
+		return
+	}
+
 	defer r.Body.Close()
-	body, err := io.ReadAll(r.Body)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxRequestBodySize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		errMsg := fmt.Sprintf("%s: more than %d bytes", ErrRequestBodyTooLarge, maxBytesErr.Limit)
+		writeProblem(w, Problem{Status: http.StatusRequestEntityTooLarge, Code: CodeRequestTooLarge, Detail: errMsg})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedPostMeter)
+		return
+	}
 	if err != nil {
 		errMsg := fmt.Sprintf("%s: %v", ErrFailedToReadRequestBody, err)
-		http.Error(w, errMsg, http.StatusInternalServerError)
-		h.logAndMonitorError(ctx, errMsg, span, err)
+		writeProblem(w, Problem{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: ErrFailedToReadRequestBody})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedPostMeter)
 		return
 	}
 
 	var req model.OneRequest
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		errMsg := fmt.Sprintf("%s: %s, error: %v", ErrFailedToParseOneRequest, body, err)
-		http.Error(w, errMsg, http.StatusBadRequest)
-		h.logAndMonitorError(ctx, errMsg, span, err)
+		errMsg := fmt.Sprintf("%s, error: %v", ErrFailedToParseOneRequest, err)
+		writeProblem(w, Problem{Status: http.StatusBadRequest, Code: CodeInvalidJSON, Detail: errMsg})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedPostMeter)
 		return
 	}
 
-	// TODO: kafka message should be sent after the request is saved to the database; should also include
-	// the request ID in the message
-	err = h.kafkaProd.SendMessage(ctx, body)
+	if violations := validation.Validate(req); len(violations) > 0 {
+		errMsg := fmt.Sprintf("%s: %d violations, first %s", ErrInvalidOneRequest, len(violations), violations[0])
+		writeProblem(w, Problem{Status: http.StatusBadRequest, Code: CodeValidationFailed, Detail: ErrInvalidOneRequest, Violations: violations})
+		h.logAndMonitorError(ctx, errMsg, span, errors.New(ErrInvalidOneRequest), h.failedPostMeter)
+		return
+	}
+
+	if !isPrincipal(ctx, req.UserID) {
+		errMsg := fmt.Sprintf("%s: %s", ErrForbiddenUser, req.UserID)
+		writeProblem(w, Problem{Status: http.StatusForbidden, Code: CodeForbidden, Detail: ErrForbiddenUser})
+		h.logAndMonitorError(ctx, errMsg, span, errors.New(ErrForbiddenUser), h.failedPostMeter)
+		return
+	}
+
+	// the message is the decoded request, which is the contract with the consumers of the topic
+	payload, err := h.codec.Encode(req)
 	if err != nil {
-		errMsg := fmt.Sprintf("%s, error: %v", ErrFailedToSendKafkaMessage, err)
-		http.Error(w, errMsg, http.StatusInternalServerError)
-		h.logAndMonitorError(ctx, errMsg, span, err)
+		errMsg := fmt.Sprintf("%s, error: %v", ErrFailedToEncodeOneRequest, err)
+		writeProblem(w, Problem{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: ErrFailedToEncodeOneRequest})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedPostMeter)
 		return
 	}
 
 	reqID := h.idService.GenerateID()
+	res := Response{
+		ReqID: reqID,
+	}
+	resBody, err := json.Marshal(res)
+	if err != nil {
+		errMsg := fmt.Sprintf("%s, error: %v", ErrFailedToMarshalResponse, err)
+		writeProblem(w, Problem{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: ErrFailedToMarshalResponse})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedPostMeter)
+		return
+	}
 
-	err = h.repo.Create(ctx, &onerequest.OneRequest{
+	// the outbox message is stored in the same transaction as the request and published by the relay
+	oneReq := &onerequest.OneRequest{
 		ReqID:  reqID,
 		UserID: req.UserID,
 		Req:    body,
-	})
+	}
+	msg := &outbox.Message{
+		ID:          h.idService.GenerateID(),
+		AggregateID: reqID,
+		Topic:       h.topic,
+		Payload:     payload,
+	}
+	msg.InjectTraceContext(ctx)
+	var stored *idempotency.Key
+	if key == "" {
+		err = h.repo.CreateWithOutbox(ctx, oneReq, msg)
+	} else {
+		stored, err = h.repo.CreateIdempotent(ctx, oneReq, msg, &idempotency.Key{
+			UserID:      req.UserID,
+			Key:         key,
+			Fingerprint: fingerprint(payload),
+			ReqID:       reqID,
+			Status:      http.StatusCreated,
+			Response:    resBody,
+		}, h.idempotencyWindow)
+	}
 	if err != nil {
 		errMsg := fmt.Sprintf("%s, error: %v", ErrFailedToSaveRequestToDatabase, err)
-		http.Error(w, errMsg, http.StatusInternalServerError)
-		h.logAndMonitorError(ctx, errMsg, span, err)
+		writeProblem(w, Problem{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: ErrFailedToSaveRequestToDatabase})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedPostMeter)
 		return
 	}
 
-	res := Response{
-		ReqID: reqID,
+	if stored != nil {
+		if stored.Fingerprint != fingerprint(payload) {
+			errMsg := fmt.Sprintf("%s: %s", ErrIdempotencyKeyReused, key)
+			writeProblem(w, Problem{Status: http.StatusUnprocessableEntity, Code: CodeIdempotencyKeyReused, Detail: errMsg})
+			h.logAndMonitorError(ctx, errMsg, span, errors.New(ErrIdempotencyKeyReused), h.failedPostMeter)
+			return
+		}
+
+		// a retry of a stored request gets the original response, nothing is stored or published again
+		h.replayedPostMeter.Add(ctx, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(HeaderIdempotentReplayed, "true")
+		w.WriteHeader(stored.Status)
+		w.Write(stored.Response)
+		span.SetStatus(codes.Ok, "request replayed")
+		return
+	}
+
+	h.successPostMeter.Add(ctx, 1)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(resBody)
+	span.SetStatus(codes.Ok, "request processed successfully")
+}
+
+// fingerprint identifies a request by its encoded payload rather than the raw body, so a retry that only
+// differs in whitespace or the order of its fields is the same request, and a key is not reused with another
+func fingerprint(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get is a handler for GET /one/:id
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	h.handledGetMeter.Add(ctx, 1)
+	ctx, span := h.tracer.Start(ctx, "http.handlers.Get", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	reqID := flow.Param(ctx, "id")
+	if !id.IsValid(reqID) {
+		errMsg := fmt.Sprintf("%s: %q", ErrInvalidRequestID, reqID)
+		writeProblem(w, Problem{Status: http.StatusBadRequest, Code: CodeInvalidRequestID, Detail: errMsg})
+		h.logAndMonitorError(ctx, errMsg, span, errors.New(ErrInvalidRequestID), h.failedGetMeter)
+		return
+	}
+
+	oneReq, err := h.repo.Get(ctx, reqID)
+	if err == nil && !isPrincipal(ctx, oneReq.UserID) {
+		// the request of another user is not found, so its id does not tell whether it exists
+		err = fmt.Errorf("%w: %s", onerequest.ErrNotFound, ErrForbiddenUser)
+	}
+	if errors.Is(err, onerequest.ErrNotFound) {
+		errMsg := fmt.Sprintf("%s: %s", ErrRequestNotFound, reqID)
+		writeProblem(w, Problem{Status: http.StatusNotFound, Code: CodeRequestNotFound, Detail: errMsg})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedGetMeter)
+		return
+	}
+	if err != nil {
+		errMsg := fmt.Sprintf("%s, error: %v", ErrFailedToGetRequestFromDatabase, err)
+		writeProblem(w, Problem{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: ErrFailedToGetRequestFromDatabase})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedGetMeter)
+		return
+	}
+
+	resBody, err := json.Marshal(newGetResponse(oneReq))
+	if err != nil {
+		errMsg := fmt.Sprintf("%s, error: %v", ErrFailedToMarshalResponse, err)
+		writeProblem(w, Problem{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: ErrFailedToMarshalResponse})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedGetMeter)
+		return
+	}
+
+	h.successGetMeter.Add(ctx, 1)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBody)
+	span.SetStatus(codes.Ok, "request found")
+}
+
+// List is a handler for GET /one?user_id=...&cursor=...&limit=...&created_after=...&created_before=..., it
+// returns the requests of a user newest first
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	h.handledListMeter.Add(ctx, 1)
+	ctx, span := h.tracer.Start(ctx, "http.handlers.List", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	filter, err := parseListFilter(r.URL.Query())
+	if err != nil {
+		errMsg := fmt.Sprintf("%s: %v", ErrInvalidQueryParameter, err)
+		writeProblem(w, Problem{Status: http.StatusBadRequest, Code: CodeInvalidQueryParameter, Detail: errMsg})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedListMeter)
+		return
+	}
+
+	if !isPrincipal(ctx, filter.UserID) {
+		errMsg := fmt.Sprintf("%s: %s", ErrForbiddenUser, filter.UserID)
+		writeProblem(w, Problem{Status: http.StatusForbidden, Code: CodeForbidden, Detail: ErrForbiddenUser})
+		h.logAndMonitorError(ctx, errMsg, span, errors.New(ErrForbiddenUser), h.failedListMeter)
+		return
+	}
+
+	// one more than the page tells whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	oneReqs, err := h.repo.List(ctx, filter)
+	if err != nil {
+		errMsg := fmt.Sprintf("%s, error: %v", ErrFailedToListRequestsFromDatabase, err)
+		writeProblem(w, Problem{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: ErrFailedToListRequestsFromDatabase})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedListMeter)
+		return
+	}
+
+	res := ListResponse{Requests: make([]GetResponse, 0, min(len(oneReqs), limit))}
+	if len(oneReqs) > limit {
+		oneReqs = oneReqs[:limit]
+		res.NextCursor = encodeCursor(oneReqs[limit-1].ReqID)
+	}
+	for _, oneReq := range oneReqs {
+		res.Requests = append(res.Requests, newGetResponse(oneReq))
 	}
 	resBody, err := json.Marshal(res)
 	if err != nil {
 		errMsg := fmt.Sprintf("%s, error: %v", ErrFailedToMarshalResponse, err)
-		http.Error(w, errMsg, http.StatusInternalServerError)
-		h.logAndMonitorError(ctx, errMsg, span, err)
+		writeProblem(w, Problem{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: ErrFailedToMarshalResponse})
+		h.logAndMonitorError(ctx, errMsg, span, err, h.failedListMeter)
 		return
 	}
 
-	h.successPostMeter.Add(ctx, 1)
+	h.successListMeter.Add(ctx, 1)
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusOK)
 	w.Write(resBody)
-	span.SetStatus(codes.Ok, "request processed successfully")
+	span.SetStatus(codes.Ok, "requests listed")
+}
+
+// parseListFilter parses the query of List, the limit defaults to DefaultListLimit and is capped at MaxListLimit
+func parseListFilter(query url.Values) (onerequest.ListFilter, error) {
+	filter := onerequest.ListFilter{UserID: query.Get("user_id"), Limit: DefaultListLimit}
+	if filter.UserID == "" {
+		return filter, errors.New("user_id is required")
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return filter, fmt.Errorf("limit %q is not a positive number", limit)
+		}
+		filter.Limit = min(n, MaxListLimit)
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		reqID, err := decodeCursor(cursor)
+		if err != nil {
+			return filter, err
+		}
+		filter.Before = reqID
+	}
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{name: "created_after", t: &filter.CreatedAfter},
+		{name: "created_before", t: &filter.CreatedBefore},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s %q is not an RFC 3339 time", param.name, value)
+		}
+		*param.t = t
+	}
+	return filter, nil
+}
+
+// encodeCursor returns the opaque cursor of the page after the request
+func encodeCursor(reqID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(reqID))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	reqID, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !id.IsValid(string(reqID)) {
+		return "", fmt.Errorf("cursor %q is invalid", cursor)
+	}
+	return string(reqID), nil
+}
+
+// isPrincipal reports whether the user is the authenticated principal of the request. Every user is when the
+// request is not authenticated, i.e. when authentication is disabled, see auth.Authenticator.Middleware.
+func isPrincipal(ctx context.Context, userID string) bool {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	return !ok || principal.ID == userID
+}
+
+func newGetResponse(oneReq *onerequest.OneRequest) GetResponse {
+	return GetResponse{
+		ReqID:     oneReq.ReqID,
+		UserID:    oneReq.UserID,
+		Req:       oneReq.Req,
+		CreatedAt: oneReq.CreatedAt,
+		UpdatedAt: oneReq.UpdatedAt,
+	}
 }
 
-func (h *Handler) logAndMonitorError(ctx context.Context, errMsg string, span trace.Span, err error) {
+func (h *Handler) logAndMonitorError(ctx context.Context, errMsg string, span trace.Span, err error, failedMeter metric.Int64Counter) {
 	h.logger.ErrorContext(ctx, errMsg)
-	h.failedPostMeter.Add(ctx, 1)
+	failedMeter.Add(ctx, 1)
 	span.SetStatus(codes.Error, errMsg)
 	span.RecordError(err)
 }
@@ -3,14 +3,24 @@ package http
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/alexedwards/flow"
+	"github.com/kartpop/cruncan/backend/one/database/idempotency"
 	"github.com/kartpop/cruncan/backend/one/database/onerequest"
+	"github.com/kartpop/cruncan/backend/one/database/outbox"
+	"github.com/kartpop/cruncan/backend/pkg/auth"
 	"github.com/kartpop/cruncan/backend/pkg/id"
+	"github.com/kartpop/cruncan/backend/pkg/validation"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -20,48 +30,76 @@ func TestHttpHandler(t *testing.T) {
 		jsonBody               string
 		mockRepo               onerequest.Repository
 		mockIdService          id.Service
-		mockProducer           Producer
 		expectedStatusCode     int
 		expectedResponseSubstr string
+		expectedProblem        *Problem
 	}
 
 	scenarios := []scenario{
 		{
 			name:                   "success",
-			jsonBody:               `{"user_id": "test_user"}`,
+			jsonBody:               `{"user_id": "test_user", "prompt": "test prompt"}`,
 			mockRepo:               &mockRepo{},
 			mockIdService:          &mockIdService{},
-			mockProducer:           &mockProducer{},
 			expectedStatusCode:     201,
 			expectedResponseSubstr: `{"request_id":"123"}`,
 		},
 		{
 			name:                   "error storing in db",
-			jsonBody:               `{"user_id": "test_user"}`,
+			jsonBody:               `{"user_id": "test_user", "prompt": "test prompt"}`,
 			mockRepo:               &mockRepo{isError: true},
 			mockIdService:          &mockIdService{},
-			mockProducer:           &mockProducer{},
 			expectedStatusCode:     500,
 			expectedResponseSubstr: ErrFailedToSaveRequestToDatabase,
-		},
-		{
-			name:                   "error sending message over producer",
-			jsonBody:               `{"user_id": "test_user"}`,
-			mockRepo:               &mockRepo{},
-			mockIdService:          &mockIdService{},
-			mockProducer:           &mockProducer{isError: true},
-			expectedStatusCode:     500,
-			expectedResponseSubstr: ErrFailedToSendKafkaMessage,
+			expectedProblem: &Problem{
+				Type:   "about:blank",
+				Title:  "Internal Server Error",
+				Status: 500,
+				Detail: ErrFailedToSaveRequestToDatabase,
+				Code:   CodeInternal,
+			},
 		},
 		{
 			name:                   "bad json",
 			jsonBody:               `{"user_id": "test_user"`,
 			mockRepo:               &mockRepo{},
 			mockIdService:          &mockIdService{},
-			mockProducer:           &mockProducer{},
 			expectedStatusCode:     400,
 			expectedResponseSubstr: ErrFailedToParseOneRequest,
 		},
+		{
+			name:               "body too large",
+			jsonBody:           `{"user_id": "test_user", "prompt": "` + strings.Repeat("p", MaxRequestBodySize) + `"}`,
+			mockRepo:           &mockRepo{},
+			mockIdService:      &mockIdService{},
+			expectedStatusCode: 413,
+			expectedProblem: &Problem{
+				Type:   "about:blank",
+				Title:  "Request Entity Too Large",
+				Status: 413,
+				Detail: fmt.Sprintf("%s: more than %d bytes", ErrRequestBodyTooLarge, MaxRequestBodySize),
+				Code:   CodeRequestTooLarge,
+			},
+		},
+		{
+			name:               "invalid request",
+			jsonBody:           `{"user_id": "test_user", "data": [{"key": "k1"}, {"key": ""}, {"key": "k1"}]}`,
+			mockRepo:           &mockRepo{},
+			mockIdService:      &mockIdService{},
+			expectedStatusCode: 400,
+			expectedProblem: &Problem{
+				Type:   "about:blank",
+				Title:  "Bad Request",
+				Status: 400,
+				Detail: ErrInvalidOneRequest,
+				Code:   CodeValidationFailed,
+				Violations: []validation.Violation{
+					{Field: "prompt", Code: validation.CodeRequired, Message: "is required"},
+					{Field: "data", Code: validation.CodeDuplicate, Message: "elements 0 and 2 have the same key k1"},
+					{Field: "data[1].key", Code: validation.CodeRequired, Message: "is required"},
+				},
+			},
+		},
 	}
 
 	for _, s := range scenarios {
@@ -70,7 +108,7 @@ func TestHttpHandler(t *testing.T) {
 			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 			defer server.Close()
 
-			httpHandler := NewHandler(context.Background(), s.mockRepo, s.mockIdService, s.mockProducer)
+			httpHandler := NewHandler(context.Background(), s.mockRepo, s.mockIdService, "one-request-test", time.Hour)
 
 			// Act
 			req, err := http.NewRequest("POST", "/one", bytes.NewBufferString(s.jsonBody))
@@ -88,14 +126,428 @@ func TestHttpHandler(t *testing.T) {
 			if !strings.Contains(recoderBody, s.expectedResponseSubstr) {
 				t.Errorf("expected response to contain %q, got %q", s.expectedResponseSubstr, recoderBody)
 			}
+			if recorder.Code >= 400 {
+				// errors are problem details and do not echo the request
+				assert.Equal(t, ContentTypeProblem, recorder.Header().Get("Content-Type"))
+				assert.NotContains(t, recoderBody, "test_user")
+			}
+			if s.expectedProblem != nil {
+				var problem Problem
+				assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+				assert.Equal(t, *s.expectedProblem, problem)
+			}
+		})
+	}
+
+}
+
+func TestHttpHandlerGet(t *testing.T) {
+	stored := &onerequest.OneRequest{
+		ReqID:     "00001234567890123456",
+		UserID:    "test_user",
+		CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		UpdatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Req:       []byte(`{"user_id": "test_user"}`),
+	}
+
+	scenarios := []struct {
+		name                   string
+		reqID                  string
+		mockRepo               onerequest.Repository
+		expectedStatusCode     int
+		expectedResponseSubstr string
+	}{
+		{
+			name:                   "success",
+			reqID:                  stored.ReqID,
+			mockRepo:               &mockRepo{stored: stored},
+			expectedStatusCode:     200,
+			expectedResponseSubstr: `{"request_id":"00001234567890123456","user_id":"test_user","request":{"user_id":"test_user"},"created_at":"2024-05-01T12:00:00Z","updated_at":"2024-05-01T12:00:00Z"}`,
+		},
+		{
+			name:                   "not found",
+			reqID:                  "00001234567890123457",
+			mockRepo:               &mockRepo{stored: stored},
+			expectedStatusCode:     404,
+			expectedResponseSubstr: ErrRequestNotFound,
+		},
+		{
+			name:                   "invalid id",
+			reqID:                  "abc",
+			mockRepo:               &mockRepo{stored: stored},
+			expectedStatusCode:     400,
+			expectedResponseSubstr: ErrInvalidRequestID,
+		},
+		{
+			name:                   "error fetching from db",
+			reqID:                  stored.ReqID,
+			mockRepo:               &mockRepo{isError: true},
+			expectedStatusCode:     500,
+			expectedResponseSubstr: ErrFailedToGetRequestFromDatabase,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			httpHandler := NewHandler(context.Background(), s.mockRepo, &mockIdService{}, "one-request-test", time.Hour)
+			mux := flow.New()
+			mux.HandleFunc("/one/:id", httpHandler.Get, http.MethodGet)
+
+			req := httptest.NewRequest(http.MethodGet, "/one/"+s.reqID, nil)
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, req)
+
+			assert.Equal(t, s.expectedStatusCode, recorder.Code)
+			assert.Contains(t, recorder.Body.String(), s.expectedResponseSubstr)
+			if s.expectedStatusCode != http.StatusOK {
+				assert.Equal(t, ContentTypeProblem, recorder.Header().Get("Content-Type"))
+			}
 		})
 	}
+}
+
+func TestHttpHandlerList(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var listed []*onerequest.OneRequest
+	// newest first, like the repository
+	for _, reqID := range []string{"00000000000000000003", "00000000000000000002", "00000000000000000001"} {
+		listed = append(listed, &onerequest.OneRequest{ReqID: reqID, UserID: "test_user", CreatedAt: created, UpdatedAt: created, Req: []byte(`{}`)})
+	}
+	cursor := base64.RawURLEncoding.EncodeToString([]byte("00000000000000000002"))
 
+	scenarios := []struct {
+		name               string
+		query              string
+		mockRepo           *mockRepo
+		expectedStatusCode int
+		expectedIDs        []string
+		expectedCursor     string
+		expectedFilter     onerequest.ListFilter
+		expectedBodySubstr string
+	}{
+		{
+			name:               "first page",
+			query:              "user_id=test_user&limit=2",
+			mockRepo:           &mockRepo{listed: listed},
+			expectedStatusCode: 200,
+			expectedIDs:        []string{"00000000000000000003", "00000000000000000002"},
+			expectedCursor:     cursor,
+			expectedFilter:     onerequest.ListFilter{UserID: "test_user", Limit: 3},
+		},
+		{
+			name:               "last page",
+			query:              "user_id=test_user&limit=2&cursor=" + cursor,
+			mockRepo:           &mockRepo{listed: listed},
+			expectedStatusCode: 200,
+			expectedIDs:        []string{"00000000000000000001"},
+			expectedFilter:     onerequest.ListFilter{UserID: "test_user", Before: "00000000000000000002", Limit: 3},
+		},
+		{
+			name:               "default limit and time filters",
+			query:              "user_id=test_user&created_after=2024-05-01T00:00:00Z&created_before=2024-05-02T00:00:00Z",
+			mockRepo:           &mockRepo{listed: listed},
+			expectedStatusCode: 200,
+			expectedIDs:        []string{"00000000000000000003", "00000000000000000002", "00000000000000000001"},
+			expectedFilter: onerequest.ListFilter{
+				UserID:        "test_user",
+				CreatedAfter:  time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+				CreatedBefore: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+				Limit:         DefaultListLimit + 1,
+			},
+		},
+		{
+			name:               "limit is capped",
+			query:              "user_id=other_user&limit=1000",
+			mockRepo:           &mockRepo{listed: listed},
+			expectedStatusCode: 200,
+			expectedIDs:        []string{},
+			expectedFilter:     onerequest.ListFilter{UserID: "other_user", Limit: MaxListLimit + 1},
+		},
+		{
+			name:               "missing user id",
+			query:              "limit=2",
+			mockRepo:           &mockRepo{},
+			expectedStatusCode: 400,
+			expectedBodySubstr: ErrInvalidQueryParameter,
+		},
+		{
+			name:               "invalid cursor",
+			query:              "user_id=test_user&cursor=abc",
+			mockRepo:           &mockRepo{},
+			expectedStatusCode: 400,
+			expectedBodySubstr: ErrInvalidQueryParameter,
+		},
+		{
+			name:               "invalid limit",
+			query:              "user_id=test_user&limit=0",
+			mockRepo:           &mockRepo{},
+			expectedStatusCode: 400,
+			expectedBodySubstr: ErrInvalidQueryParameter,
+		},
+		{
+			name:               "invalid time",
+			query:              "user_id=test_user&created_after=yesterday",
+			mockRepo:           &mockRepo{},
+			expectedStatusCode: 400,
+			expectedBodySubstr: ErrInvalidQueryParameter,
+		},
+		{
+			name:               "error listing from db",
+			query:              "user_id=test_user",
+			mockRepo:           &mockRepo{isError: true},
+			expectedStatusCode: 500,
+			expectedBodySubstr: ErrFailedToListRequestsFromDatabase,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			httpHandler := NewHandler(context.Background(), s.mockRepo, &mockIdService{}, "one-request-test", time.Hour)
+
+			req := httptest.NewRequest(http.MethodGet, "/one?"+s.query, nil)
+			recorder := httptest.NewRecorder()
+			http.HandlerFunc(httpHandler.List).ServeHTTP(recorder, req)
+
+			assert.Equal(t, s.expectedStatusCode, recorder.Code)
+			if s.expectedStatusCode != http.StatusOK {
+				assert.Contains(t, recorder.Body.String(), s.expectedBodySubstr)
+				assert.Equal(t, ContentTypeProblem, recorder.Header().Get("Content-Type"))
+				return
+			}
+			var res ListResponse
+			assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+			ids := []string{}
+			for _, r := range res.Requests {
+				ids = append(ids, r.ReqID)
+			}
+			assert.Equal(t, s.expectedIDs, ids)
+			assert.Equal(t, s.expectedCursor, res.NextCursor)
+			assert.Equal(t, s.expectedFilter, s.mockRepo.listFilter)
+		})
+	}
+}
+
+func TestHttpHandlerIdempotency(t *testing.T) {
+	repo := &mockRepo{}
+	httpHandler := NewHandler(context.Background(), repo, &sequenceIdService{}, "one-request-test", time.Hour)
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/one", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(httpHandler.Post).ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	scenarios := []struct {
+		name                   string
+		key                    string
+		body                   string
+		expectedStatusCode     int
+		expectedResponseSubstr string
+		expectedReplayed       bool
+		expectedCreated        int
+	}{
+		{
+			name:                   "first use of a key",
+			key:                    "key-1",
+			body:                   `{"user_id": "test_user", "prompt": "test prompt"}`,
+			expectedStatusCode:     201,
+			expectedResponseSubstr: `{"request_id":"1"}`,
+			expectedCreated:        1,
+		},
+		{
+			name:                   "retry with the same key and body",
+			key:                    "key-1",
+			body:                   `{"user_id": "test_user", "prompt": "test prompt"}`,
+			expectedStatusCode:     201,
+			expectedResponseSubstr: `{"request_id":"1"}`,
+			expectedReplayed:       true,
+			expectedCreated:        1,
+		},
+		{
+			name:                   "retry with the same key and a reformatted body",
+			key:                    "key-1",
+			body:                   `{"prompt":"test prompt","user_id":"test_user"}`,
+			expectedStatusCode:     201,
+			expectedResponseSubstr: `{"request_id":"1"}`,
+			expectedReplayed:       true,
+			expectedCreated:        1,
+		},
+		{
+			name:                   "same key with another body",
+			key:                    "key-1",
+			body:                   `{"user_id": "test_user", "prompt": "another prompt"}`,
+			expectedStatusCode:     422,
+			expectedResponseSubstr: ErrIdempotencyKeyReused,
+			expectedCreated:        1,
+		},
+		{
+			name:                   "same key of another user",
+			key:                    "key-1",
+			body:                   `{"user_id": "other_user", "prompt": "test prompt"}`,
+			expectedStatusCode:     201,
+			expectedResponseSubstr: `"request_id":`,
+			expectedCreated:        2,
+		},
+		{
+			name:                   "another key",
+			key:                    "key-2",
+			body:                   `{"user_id": "test_user", "prompt": "test prompt"}`,
+			expectedStatusCode:     201,
+			expectedResponseSubstr: `"request_id":`,
+			expectedCreated:        3,
+		},
+		{
+			name:                   "without a key",
+			body:                   `{"user_id": "test_user", "prompt": "test prompt"}`,
+			expectedStatusCode:     201,
+			expectedResponseSubstr: `"request_id":`,
+			expectedCreated:        4,
+		},
+		{
+			name:                   "key too long",
+			key:                    strings.Repeat("k", MaxIdempotencyKeyLength+1),
+			body:                   `{"user_id": "test_user", "prompt": "test prompt"}`,
+			expectedStatusCode:     400,
+			expectedResponseSubstr: ErrInvalidIdempotencyKey,
+			expectedCreated:        4,
+		},
+	}
+
+	// the scenarios run in order, each one sees the keys stored before
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			recorder := post(s.key, s.body)
+
+			assert.Equal(t, s.expectedStatusCode, recorder.Code)
+			assert.Contains(t, recorder.Body.String(), s.expectedResponseSubstr)
+			assert.Equal(t, s.expectedReplayed, recorder.Header().Get(HeaderIdempotentReplayed) == "true")
+			assert.Equal(t, s.expectedCreated, repo.created)
+		})
+	}
+}
+
+func TestHttpHandlerPrincipal(t *testing.T) {
+	stored := &onerequest.OneRequest{
+		ReqID:  "00001234567890123456",
+		UserID: "test_user",
+		Req:    []byte(`{"user_id": "test_user"}`),
+	}
+	httpHandler := NewHandler(context.Background(), &mockRepo{stored: stored}, &mockIdService{}, "one-request-test", time.Hour)
+	mux := flow.New()
+	mux.HandleFunc("/one", httpHandler.Post, http.MethodPost)
+	mux.HandleFunc("/one", httpHandler.List, http.MethodGet)
+	mux.HandleFunc("/one/:id", httpHandler.Get, http.MethodGet)
+
+	scenarios := []struct {
+		name               string
+		method             string
+		target             string
+		body               string
+		principal          *auth.Principal
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{
+			name:               "post as the user",
+			method:             http.MethodPost,
+			target:             "/one",
+			body:               `{"user_id": "test_user", "prompt": "test prompt"}`,
+			principal:          &auth.Principal{ID: "test_user"},
+			expectedStatusCode: 201,
+		},
+		{
+			name:               "post for another user",
+			method:             http.MethodPost,
+			target:             "/one",
+			body:               `{"user_id": "other_user", "prompt": "test prompt"}`,
+			principal:          &auth.Principal{ID: "test_user"},
+			expectedStatusCode: 403,
+			expectedCode:       CodeForbidden,
+		},
+		{
+			name:               "post without authentication",
+			method:             http.MethodPost,
+			target:             "/one",
+			body:               `{"user_id": "other_user", "prompt": "test prompt"}`,
+			expectedStatusCode: 201,
+		},
+		{
+			name:               "get as the user",
+			method:             http.MethodGet,
+			target:             "/one/" + stored.ReqID,
+			principal:          &auth.Principal{ID: "test_user"},
+			expectedStatusCode: 200,
+		},
+		{
+			name:               "get the request of another user",
+			method:             http.MethodGet,
+			target:             "/one/" + stored.ReqID,
+			principal:          &auth.Principal{ID: "other_user"},
+			expectedStatusCode: 404,
+			expectedCode:       CodeRequestNotFound,
+		},
+		{
+			name:               "list as the user",
+			method:             http.MethodGet,
+			target:             "/one?user_id=test_user",
+			principal:          &auth.Principal{ID: "test_user"},
+			expectedStatusCode: 200,
+		},
+		{
+			name:               "list the requests of another user",
+			method:             http.MethodGet,
+			target:             "/one?user_id=test_user",
+			principal:          &auth.Principal{ID: "other_user"},
+			expectedStatusCode: 403,
+			expectedCode:       CodeForbidden,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			req := httptest.NewRequest(s.method, s.target, strings.NewReader(s.body))
+			if s.principal != nil {
+				req = req.WithContext(auth.ContextWithPrincipal(req.Context(), s.principal))
+			}
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, req)
+
+			assert.Equal(t, s.expectedStatusCode, recorder.Code)
+			if s.expectedCode != "" {
+				var problem Problem
+				assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+				assert.Equal(t, s.expectedCode, problem.Code)
+			}
+		})
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	Unauthorized(recorder, httptest.NewRequest(http.MethodPost, "/one", nil), auth.ErrMissingToken)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, ContentTypeProblem, recorder.Header().Get("Content-Type"))
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+	assert.Equal(t, CodeUnauthenticated, problem.Code)
 }
 
 // mock repo for testing
 type mockRepo struct {
 	isError bool
+	// stored is the request Get finds
+	stored *onerequest.OneRequest
+	// listed are the requests List pages through, newest first, and listFilter the filter of the last call
+	listed     []*onerequest.OneRequest
+	listFilter onerequest.ListFilter
+	// keys are the stored idempotency keys by user and key, and created counts the stored requests
+	keys    map[string]*idempotency.Key
+	created int
 }
 
 func (m *mockRepo) Create(ctx context.Context, req *onerequest.OneRequest) error {
@@ -105,11 +557,47 @@ func (m *mockRepo) Create(ctx context.Context, req *onerequest.OneRequest) error
 	return nil
 }
 
+func (m *mockRepo) CreateWithOutbox(ctx context.Context, req *onerequest.OneRequest, msg *outbox.Message) error {
+	if m.isError {
+		return errors.New("error storing in db")
+	}
+	m.created++
+	return nil
+}
+
+func (m *mockRepo) CreateIdempotent(ctx context.Context, req *onerequest.OneRequest, msg *outbox.Message, key *idempotency.Key, window time.Duration) (*idempotency.Key, error) {
+	if m.isError {
+		return nil, errors.New("error storing in db")
+	}
+	if stored, ok := m.keys[key.UserID+"/"+key.Key]; ok {
+		return stored, nil
+	}
+	if m.keys == nil {
+		m.keys = make(map[string]*idempotency.Key)
+	}
+	m.keys[key.UserID+"/"+key.Key] = key
+	m.created++
+	return nil, nil
+}
+
 func (m *mockRepo) Get(ctx context.Context, reqId string) (*onerequest.OneRequest, error) {
 	if m.isError {
 		return nil, errors.New("error fetching from db")
 	}
-	return &onerequest.OneRequest{}, nil
+	if m.stored == nil || m.stored.ReqID != reqId {
+		return nil, onerequest.ErrNotFound
+	}
+	return m.stored, nil
+}
+
+// sequenceIdService generates increasing ids for testing
+type sequenceIdService struct {
+	next int
+}
+
+func (m *sequenceIdService) GenerateID() string {
+	m.next++
+	return strconv.Itoa(m.next)
 }
 
 // mock idService for testing
@@ -120,17 +608,16 @@ func (m *mockIdService) GenerateID() string {
 	return "123"
 }
 
-// mock producer for testing
-type mockProducer struct {
-	isError bool
-}
-
-func (m *mockProducer) SendMessage(ctx context.Context, message []byte) error {
+func (m *mockRepo) List(ctx context.Context, filter onerequest.ListFilter) ([]*onerequest.OneRequest, error) {
+	m.listFilter = filter
 	if m.isError {
-		return errors.New("error sending message over producer")
+		return nil, errors.New("error listing from db")
 	}
-	return nil
-}
-
-func (m *mockProducer) Close() {
+	var oneReqs []*onerequest.OneRequest
+	for _, oneReq := range m.listed {
+		if oneReq.UserID == filter.UserID && (filter.Before == "" || oneReq.ReqID < filter.Before) && len(oneReqs) < filter.Limit {
+			oneReqs = append(oneReqs, oneReq)
+		}
+	}
+	return oneReqs, nil
 }
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kartpop/cruncan/backend/pkg/validation"
+)
+
+// ContentTypeProblem is the content type of the error responses, see RFC 7807
+const ContentTypeProblem = "application/problem+json"
+
+// Codes of the problems, they are stable and meant to be matched by clients rather than the detail
+const (
+	CodeInvalidIdempotencyKey = "invalid_idempotency_key"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeRequestTooLarge       = "request_too_large"
+	CodeInvalidJSON           = "invalid_json"
+	CodeValidationFailed      = "validation_failed"
+	CodeInvalidRequestID      = "invalid_request_id"
+	CodeRequestNotFound       = "request_not_found"
+	CodeInvalidQueryParameter = "invalid_query_parameter"
+	CodeUnauthenticated       = "unauthenticated"
+	CodeForbidden             = "forbidden"
+	CodeInternal              = "internal_error"
+)
+
+// Problem is the body of an error response, see RFC 7807. Its type is about:blank, so the title is the status
+// text and the code tells the problems apart.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Code is a stable identifier of the problem
+	Code string `json:"code"`
+	// Violations are the fields of the request that failed validation
+	Violations []validation.Violation `json:"violations,omitempty"`
+}
+
+// Unauthorized answers a request that failed authentication, see auth.WithUnauthorizedHandler. The reason is
+// logged by the middleware and not told to the client.
+func Unauthorized(w http.ResponseWriter, _ *http.Request, _ error) {
+	writeProblem(w, Problem{Status: http.StatusUnauthorized, Code: CodeUnauthenticated, Detail: ErrUnauthenticated})
+}
+
+// writeProblem writes the problem with its status as the response
+func writeProblem(w http.ResponseWriter, problem Problem) {
+	problem.Type = "about:blank"
+	problem.Title = http.StatusText(problem.Status)
+	body, err := json.Marshal(problem)
+	if err != nil {
+		// a problem only holds strings and numbers
+		http.Error(w, problem.Detail, problem.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeProblem)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	w.Write(body)
+}
@@ -0,0 +1,189 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kartpop/cruncan/backend/one/database/outbox"
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	"github.com/kartpop/cruncan/backend/pkg/otel"
+	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Producer publishes the outbox messages of a topic, e.g. a queue.Publisher
+type Producer interface {
+	Send(ctx context.Context, messages ...kafkaUtil.Message) kafkaUtil.SendResults
+}
+
+// Relay publishes pending outbox messages to kafka and marks them as sent. A message is only marked
+// after kafka acknowledged it, so delivery is at-least-once.
+type Relay struct {
+	repo      outbox.Repository
+	producers map[string]Producer
+	logger    *slog.Logger
+	tracer    trace.Tracer
+	interval  time.Duration
+	batchSize int
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewRelay creates a new outbox relay. producers maps a topic name to the producer for that topic.
+func NewRelay(ctx context.Context, repo outbox.Repository, producers map[string]Producer, interval time.Duration, batchSize int) *Relay {
+	tracer, _ := otelContext.Tracer(ctx)
+
+	return &Relay{
+		repo:      repo,
+		producers: producers,
+		logger:    slog.Default(),
+		tracer:    tracer,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// Start polls the outbox every interval until Stop is called or the context is cancelled.
+// The poll loop is inside a goroutine, so it will not block the caller.
+func (r *Relay) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		r.logger.WarnContext(ctx, "trying to start an outbox relay that is already running")
+		return
+	}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+				r.drain(ctx)
+			}
+		}
+	}(r.stop, r.done)
+}
+
+// Stop stops the poll loop and waits for the batch in flight to finish or the context to be done
+func (r *Relay) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.mu.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("outbox relay did not stop in time: %w", ctx.Err())
+	}
+}
+
+// drain relays batches until the outbox has no more pending messages or relaying fails
+func (r *Relay) drain(ctx context.Context) {
+	for {
+		sent, err := r.RelayPending(ctx)
+		if err != nil {
+			r.logger.ErrorContext(ctx, fmt.Sprintf("failed to relay outbox messages: %v", err))
+			return
+		}
+		if sent < r.batchSize {
+			return
+		}
+	}
+}
+
+// RelayPending publishes one batch of pending outbox messages and returns the number of messages sent
+func (r *Relay) RelayPending(ctx context.Context) (int, error) {
+	return r.repo.ProcessPending(ctx, r.batchSize, r.publish)
+}
+
+// publish sends the messages with one Send per topic and returns how many of them, from the first, were sent.
+// A message without a producer ends the batch before anything is sent. The messages after the first one that
+// failed stay pending even if they were sent, and are published again.
+func (r *Relay) publish(ctx context.Context, msgs []*outbox.Message) (int, error) {
+	var err error
+	for i, msg := range msgs {
+		if _, ok := r.producers[msg.Topic]; !ok {
+			err = fmt.Errorf("no producer for topic %q", msg.Topic)
+			msgs = msgs[:i]
+			break
+		}
+	}
+
+	spans := make([]trace.Span, len(msgs))
+	errs := make([]error, len(msgs))
+	topics := make(map[string][]int)
+	messages := make([]kafkaUtil.Message, len(msgs))
+	for i, msg := range msgs {
+		var msgCtx context.Context
+		msgCtx, spans[i] = r.startPublishSpan(ctx, msg)
+		// keyed by the aggregate so that the messages of an aggregate keep their order, the outbox ID lets
+		// consumers skip a message that is published again after the relay failed to mark it sent
+		messages[i] = kafkaUtil.Message{
+			Key:     []byte(msg.AggregateID),
+			Value:   msg.Payload,
+			Headers: []kafkaUtil.Header{{Key: kafkaUtil.HeaderMessageID, Value: []byte(msg.ID)}},
+		}
+		// the batch is sent with one context, the headers keep the trace of each message
+		messages[i].InjectTraceContext(msgCtx)
+		topics[msg.Topic] = append(topics[msg.Topic], i)
+	}
+
+	for topic, indices := range topics {
+		batch := make([]kafkaUtil.Message, len(indices))
+		for j, i := range indices {
+			batch[j] = messages[i]
+		}
+		for j, result := range r.producers[topic].Send(ctx, batch...) {
+			errs[indices[j]] = result.Err
+		}
+	}
+
+	sent := len(msgs)
+	for i, span := range spans {
+		otel.SetAutoSpanStatus(span, errs[i])
+		span.End()
+		if errs[i] != nil && sent == len(msgs) {
+			sent, err = i, errs[i]
+		}
+	}
+	return sent, err
+}
+
+// startPublishSpan starts the span of publishing a message. A message that stored the trace context of its
+// request continues that trace, which links back to the relay's batch.
+func (r *Relay) startPublishSpan(ctx context.Context, msg *outbox.Message) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{trace.WithSpanKind(trace.SpanKindProducer)}
+	if len(msg.TraceContext) > 0 {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: trace.SpanContextFromContext(ctx)}))
+		ctx = msg.ExtractTraceContext(ctx)
+	}
+	ctx, span := r.tracer.Start(ctx, "relay.publish", opts...)
+	span.SetAttributes(
+		attribute.String("outbox.id", msg.ID),
+		attribute.String("outbox.aggregate_id", msg.AggregateID),
+		attribute.String("outbox.topic", msg.Topic),
+	)
+	return ctx, span
+}
@@ -0,0 +1,248 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kartpop/cruncan/backend/one/database/outbox"
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRelayPending(t *testing.T) {
+	type scenario struct {
+		name             string
+		pending          []*outbox.Message
+		producers        map[string]Producer
+		batchSize        int
+		expectedSent     int
+		expectedErr      bool
+		expectedMessages []string
+		expectedPending  []string
+	}
+
+	scenarios := []scenario{
+		{
+			name:             "publishes all pending messages in order",
+			pending:          []*outbox.Message{newMessage("1", "topic-a"), newMessage("2", "topic-a")},
+			producers:        map[string]Producer{"topic-a": &mockProducer{}},
+			batchSize:        10,
+			expectedSent:     2,
+			expectedMessages: []string{"payload-1", "payload-2"},
+		},
+		{
+			name:             "publishes at most one batch",
+			pending:          []*outbox.Message{newMessage("1", "topic-a"), newMessage("2", "topic-a")},
+			producers:        map[string]Producer{"topic-a": &mockProducer{}},
+			batchSize:        1,
+			expectedSent:     1,
+			expectedMessages: []string{"payload-1"},
+			expectedPending:  []string{"2"},
+		},
+		{
+			name:            "keeps messages pending when kafka fails",
+			pending:         []*outbox.Message{newMessage("1", "topic-a"), newMessage("2", "topic-a")},
+			producers:       map[string]Producer{"topic-a": &mockProducer{isError: true}},
+			batchSize:       10,
+			expectedErr:     true,
+			expectedPending: []string{"1", "2"},
+		},
+		{
+			name:             "keeps the messages from the first failed one pending",
+			pending:          []*outbox.Message{newMessage("1", "topic-a"), newMessage("2", "topic-a"), newMessage("3", "topic-a")},
+			producers:        map[string]Producer{"topic-a": &mockProducer{failAt: "payload-2"}},
+			batchSize:        10,
+			expectedSent:     1,
+			expectedErr:      true,
+			expectedMessages: []string{"payload-1"},
+			expectedPending:  []string{"2", "3"},
+		},
+		{
+			name:             "stops at the first message without a producer",
+			pending:          []*outbox.Message{newMessage("1", "topic-a"), newMessage("2", "topic-b"), newMessage("3", "topic-a")},
+			producers:        map[string]Producer{"topic-a": &mockProducer{}},
+			batchSize:        10,
+			expectedSent:     1,
+			expectedErr:      true,
+			expectedMessages: []string{"payload-1"},
+			expectedPending:  []string{"2", "3"},
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			// Arrange
+			repo := &mockRepo{messages: s.pending}
+			relay := NewRelay(context.Background(), repo, s.producers, time.Second, s.batchSize)
+
+			// Act
+			sent, err := relay.RelayPending(context.Background())
+
+			// Assert
+			assert.Equal(t, s.expectedSent, sent)
+			if s.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			var published []string
+			for _, p := range s.producers {
+				published = append(published, p.(*mockProducer).messages...)
+			}
+			assert.ElementsMatch(t, s.expectedMessages, published)
+			assert.Equal(t, s.expectedPending, repo.pendingIDs())
+		})
+	}
+}
+
+func TestRelayStartStop(t *testing.T) {
+	repo := &mockRepo{messages: []*outbox.Message{newMessage("1", "topic-a")}}
+	producer := &mockProducer{}
+	relay := NewRelay(context.Background(), repo, map[string]Producer{"topic-a": producer}, 10*time.Millisecond, 10)
+
+	relay.Start(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return len(repo.pendingIDs()) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, relay.Stop(ctx))
+	assert.Equal(t, []string{"payload-1"}, producer.messages)
+	assert.Equal(t, []string{"req-1"}, producer.keys)
+	assert.Equal(t, []string{"1"}, producer.ids)
+}
+
+func TestRelaySendsABatchAtOnce(t *testing.T) {
+	repo := &mockRepo{messages: []*outbox.Message{newMessage("1", "topic-a"), newMessage("2", "topic-b"), newMessage("3", "topic-a")}}
+	a, b := &mockProducer{}, &mockProducer{}
+	r := NewRelay(context.Background(), repo, map[string]Producer{"topic-a": a, "topic-b": b}, time.Second, 10)
+
+	sent, err := r.RelayPending(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, sent)
+	assert.Equal(t, 1, a.sends)
+	assert.Equal(t, []string{"payload-1", "payload-3"}, a.messages)
+	assert.Equal(t, 1, b.sends)
+	assert.Equal(t, []string{"payload-2"}, b.messages)
+}
+
+func TestRelayContinuesTheTraceOfTheMessage(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	request := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	relay := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{7, 8, 9},
+		SpanID:     trace.SpanID{1, 1, 1},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	traced := newMessage("1", "topic-a")
+	traced.InjectTraceContext(trace.ContextWithSpanContext(context.Background(), request))
+	untraced := newMessage("2", "topic-a")
+	assert.NotEmpty(t, traced.TraceContext)
+
+	repo := &mockRepo{messages: []*outbox.Message{traced, untraced}}
+	producer := &mockProducer{}
+	r := NewRelay(context.Background(), repo, map[string]Producer{"topic-a": producer}, time.Second, 10)
+
+	// the relay polls in its own trace
+	sent, err := r.RelayPending(trace.ContextWithSpanContext(context.Background(), relay))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []trace.TraceID{request.TraceID(), relay.TraceID()}, producer.traceIDs)
+}
+
+func newMessage(id, topic string) *outbox.Message {
+	return &outbox.Message{
+		ID:          id,
+		AggregateID: "req-" + id,
+		Topic:       topic,
+		Payload:     []byte("payload-" + id),
+	}
+}
+
+// mock repo for testing, the mutex stands in for the row locks of the real repository
+type mockRepo struct {
+	mu       sync.Mutex
+	messages []*outbox.Message
+}
+
+func (m *mockRepo) ProcessPending(ctx context.Context, limit int, publish outbox.PublishFunc) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pending []*outbox.Message
+	for _, msg := range m.messages {
+		if msg.SentAt == nil && len(pending) < limit {
+			pending = append(pending, msg)
+		}
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	sent, err := publish(ctx, pending)
+	now := time.Now()
+	for _, msg := range pending[:sent] {
+		msg.SentAt = &now
+	}
+	return sent, err
+}
+
+func (m *mockRepo) pendingIDs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []string
+	for _, msg := range m.messages {
+		if msg.SentAt == nil {
+			ids = append(ids, msg.ID)
+		}
+	}
+	return ids
+}
+
+// mock producer for testing
+type mockProducer struct {
+	isError bool
+	// failAt fails the messages with the payload, and the messages after it in the batch
+	failAt   string
+	sends    int
+	keys     []string
+	ids      []string
+	messages []string
+	// traceIDs are the trace ids of the contexts messages were sent with
+	traceIDs []trace.TraceID
+}
+
+func (m *mockProducer) Send(ctx context.Context, messages ...kafkaUtil.Message) kafkaUtil.SendResults {
+	m.sends++
+	results := make(kafkaUtil.SendResults, len(messages))
+	failed := m.isError
+	for i, msg := range messages {
+		results[i].Message = msg
+		if failed = failed || string(msg.Value) == m.failAt; failed {
+			results[i].Err = errors.New("error sending message over producer")
+			continue
+		}
+		m.keys = append(m.keys, string(msg.Key))
+		id, _ := msg.Header(kafkaUtil.HeaderMessageID)
+		m.ids = append(m.ids, string(id))
+		m.messages = append(m.messages, string(msg.Value))
+		m.traceIDs = append(m.traceIDs, trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(ctx, kafkaUtil.NewMessageCarrier(&msg))).TraceID())
+	}
+	return results
+}
@@ -5,11 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log/slog"
 	"net/http"
 	"os"
 	"testing"
-	"time"
 
 	"io"
 
@@ -18,21 +16,24 @@ import (
 	"github.com/kartpop/cruncan/backend/one/tests/utils"
 	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
 	"github.com/kartpop/cruncan/backend/pkg/model"
-	"gorm.io/gorm"
 )
 
 type testFixtureKey struct{}
 
+var codec = kafkaUtil.JSONCodec[model.OneRequest]{}
+
 type testFixture struct {
-	reqId                string
-	status               int
-	kafkaClient          *kafkaUtil.Client
-	oneRequestKafkaMssgs map[string]model.OneRequest
-	gormClient           *gorm.DB
-	oneRequestRepo       onerequest.Repository
+	reqId          string
+	status         int
+	oneRequestRepo onerequest.Repository
 }
 
+// service runs in-process for the whole suite, see utils.StartService
+var service *utils.Service
+
 func TestFeatures(t *testing.T) {
+	service = utils.StartService(t)
+
 	suite := godog.TestSuite{
 		TestSuiteInitializer: InitializeSuite,
 		ScenarioInitializer:  InitializeScenario,
@@ -52,37 +53,16 @@ func InitializeSuite(ctx *godog.TestSuiteContext) {
 	var key = testFixtureKey{}
 
 	ctx.ScenarioContext().Before(func(ctx context.Context, sc *godog.Scenario) (context.Context, error) {
-		kafkaClient := utils.InitKafkaClient()
-		oneRequestTestConsumer := kafkaClient.NewConsumer(utils.EnvConfig.Kafka.OneRequestTopic.Name)
-
-		gormClient := utils.InitGorm()
-		oneRequestRepo := onerequest.NewRepository(gormClient)
-
 		ctx = context.WithValue(ctx, key, &testFixture{
-			kafkaClient:          kafkaClient,
-			oneRequestKafkaMssgs: make(map[string]model.OneRequest),
-			gormClient:           gormClient,
-			oneRequestRepo:       oneRequestRepo,
+			oneRequestRepo: service.OneRequestRepo,
 		})
 
-		oneRequestTestConsumer.Start(ctx, &TestKafkaHandler{})
-
 		return ctx, nil
 	})
 
 	ctx.ScenarioContext().After(func(ctx context.Context, sc *godog.Scenario, err error) (context.Context, error) {
-		ctxKeyValue := ctx.Value(key)
-		ctxData := ctxKeyValue.(*testFixture)
-
-		ctxData.kafkaClient.Close()
-
-		ctxData.gormClient.WithContext(ctx).Where("1 = 1").Delete(&onerequest.OneRequest{})
-		db, er := ctxData.gormClient.DB()
-		if er != nil {
-			return ctx, er
-		}
-
-		return ctx, db.Close()
+		service.Reset(ctx)
+		return ctx, nil
 	})
 }
 
@@ -101,7 +81,7 @@ func aPostRequestIsSent(ctx context.Context, filePath string) (context.Context,
 		return ctx, fmt.Errorf("could not read file %s: %v", filePath, err)
 	}
 
-	url := "http://" + utils.EnvConfig.Server.Addr + "/one"
+	url := service.URL + "/one"
 	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(reqBody))
 	if err != nil {
 		return ctx, err
@@ -139,24 +119,11 @@ func theRequestIsPublishedToKafka(ctx context.Context, filePath string) (context
 		return ctx, fmt.Errorf("failed to unmarshal one request, error: %v", err)
 	}
 
-	// polling to periodically check if onerequest test kafka handler has processed the message
-	timeout := time.After(15 * time.Second)
-	tick := time.Tick(500 * time.Millisecond)
-
-	for {
-		select {
-		case <-timeout:
-			return ctx, fmt.Errorf("test timed out")
-		case <-tick:
-			err := verifyMessage(ctx, oneReq)
-			if err != nil {
-				slog.Default().Error(err.Error())
-			}
-			if err == nil {
-				return ctx, nil
-			}
-		}
-	}
+	_, err = service.OneRequests.AwaitMatch(ctx, func(message kafkaUtil.Message) bool {
+		published, err := codec.Decode(message.Value)
+		return err == nil && published.UserID == oneReq.UserID && published.Prompt == oneReq.Prompt
+	})
+	return ctx, err
 }
 
 func theRequestIsSavedToDatabase(ctx context.Context, filePath string) (context.Context, error) {
@@ -193,38 +160,3 @@ func responseCodeIs(ctx context.Context, expectedStatusCode int) (context.Contex
 
 	return ctx, nil
 }
-
-func verifyMessage(ctx context.Context, oneReq model.OneRequest) error {
-	ctxData := ctx.Value(testFixtureKey{}).(*testFixture)
-
-	if _, ok := ctxData.oneRequestKafkaMssgs[oneReq.UserID]; !ok {
-		return fmt.Errorf("one request not found in kafka messages")
-	}
-
-	// verify sample data
-	if ctxData.oneRequestKafkaMssgs[oneReq.UserID].UserID != oneReq.UserID {
-		return fmt.Errorf("user id mismatch")
-	}
-	if ctxData.oneRequestKafkaMssgs[oneReq.UserID].Prompt != oneReq.Prompt {
-		return fmt.Errorf("prompt mismatch")
-	}
-
-	return nil
-}
-
-type TestKafkaHandler struct {
-}
-
-func (t *TestKafkaHandler) Handle(ctx context.Context, msg []byte, topic string) error {
-	ctxData := ctx.Value(testFixtureKey{}).(*testFixture)
-
-	var oneReq model.OneRequest
-	if err := json.Unmarshal(msg, &oneReq); err != nil {
-		slog.Default().Error(fmt.Sprintf("failed to unmarshal one request, error: %v", err))
-		return err
-	}
-
-	ctxData.oneRequestKafkaMssgs[oneReq.UserID] = oneReq
-
-	return nil
-}
@@ -0,0 +1,181 @@
+// Package memory keeps the tables of one in memory, so the component tests can run without a database. A Store is
+// both the onerequest and the outbox repository, since requests and their outbox messages are stored together.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kartpop/cruncan/backend/one/database/idempotency"
+	"github.com/kartpop/cruncan/backend/one/database/onerequest"
+	"github.com/kartpop/cruncan/backend/one/database/outbox"
+)
+
+var (
+	_ onerequest.Repository = (*Store)(nil)
+	_ outbox.Repository     = (*Store)(nil)
+)
+
+// Store is an in-memory onerequest.Repository and outbox.Repository. Its mutex stands in for the transactions
+// and row locks of the database, so each call sees and leaves a consistent state.
+type Store struct {
+	mu       sync.Mutex
+	requests map[string]onerequest.OneRequest
+	messages map[string]outbox.Message
+	keys     map[keyID]idempotency.Key
+}
+
+// keyID is the primary key of an idempotency key
+type keyID struct {
+	userID, key string
+}
+
+func NewStore() *Store {
+	s := &Store{}
+	s.Reset()
+	return s
+}
+
+// Reset deletes everything stored, e.g. between test scenarios
+func (s *Store) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = map[string]onerequest.OneRequest{}
+	s.messages = map[string]outbox.Message{}
+	s.keys = map[keyID]idempotency.Key{}
+}
+
+func (s *Store) Create(ctx context.Context, req *onerequest.OneRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createRequest(req)
+}
+
+func (s *Store) CreateWithOutbox(ctx context.Context, req *onerequest.OneRequest, msg *outbox.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createWithOutbox(req, msg)
+}
+
+func (s *Store) CreateIdempotent(ctx context.Context, req *onerequest.OneRequest, msg *outbox.Message, key *idempotency.Key, window time.Duration) (*idempotency.Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	id := keyID{userID: key.UserID, key: key.Key}
+	if stored, ok := s.keys[id]; ok && stored.ExpiresAt.After(now) {
+		return &stored, nil
+	}
+	if err := s.createWithOutbox(req, msg); err != nil {
+		return nil, err
+	}
+	stored := *key
+	stored.CreatedAt, stored.ExpiresAt = now, now.Add(window)
+	s.keys[id] = stored
+	return nil, nil
+}
+
+func (s *Store) Get(ctx context.Context, reqId string) (*onerequest.OneRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok := s.requests[reqId]
+	if !ok {
+		return nil, onerequest.ErrNotFound
+	}
+	return &req, nil
+}
+
+func (s *Store) List(ctx context.Context, filter onerequest.ListFilter) ([]*onerequest.OneRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reqs []*onerequest.OneRequest
+	for _, req := range s.requests {
+		switch {
+		case req.UserID != filter.UserID,
+			filter.Before != "" && req.ReqID >= filter.Before,
+			!filter.CreatedAfter.IsZero() && req.CreatedAt.Before(filter.CreatedAfter),
+			!filter.CreatedBefore.IsZero() && !req.CreatedAt.Before(filter.CreatedBefore):
+			continue
+		}
+		req := req
+		reqs = append(reqs, &req)
+	}
+	slices.SortFunc(reqs, func(a, b *onerequest.OneRequest) int {
+		return strings.Compare(b.ReqID, a.ReqID)
+	})
+	if filter.Limit > 0 && len(reqs) > filter.Limit {
+		reqs = reqs[:filter.Limit]
+	}
+	return reqs, nil
+}
+
+func (s *Store) ProcessPending(ctx context.Context, limit int, publish outbox.PublishFunc) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending []outbox.Message
+	for _, msg := range s.messages {
+		if msg.SentAt == nil {
+			pending = append(pending, msg)
+		}
+	}
+	slices.SortFunc(pending, func(a, b outbox.Message) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+	msgs := make([]*outbox.Message, len(pending))
+	for i := range pending {
+		msgs[i] = &pending[i]
+	}
+
+	sent, err := publish(ctx, msgs)
+	now := time.Now().UTC()
+	for _, msg := range msgs[:sent] {
+		msg.SentAt = &now
+		s.messages[msg.ID] = *msg
+	}
+	return sent, err
+}
+
+// createWithOutbox stores the request and its message, or neither of them, like the transaction of the database
+func (s *Store) createWithOutbox(req *onerequest.OneRequest, msg *outbox.Message) error {
+	if _, ok := s.messages[msg.ID]; ok {
+		return fmt.Errorf("duplicate outbox message id %s", msg.ID)
+	}
+	if err := s.createRequest(req); err != nil {
+		return err
+	}
+	stored := *msg
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = time.Now().UTC()
+	}
+	s.messages[msg.ID] = stored
+	return nil
+}
+
+func (s *Store) createRequest(req *onerequest.OneRequest) error {
+	if _, ok := s.requests[req.ReqID]; ok {
+		return fmt.Errorf("duplicate request id %s", req.ReqID)
+	}
+	now := time.Now().UTC()
+	if req.CreatedAt.IsZero() {
+		req.CreatedAt = now
+	}
+	if req.UpdatedAt.IsZero() {
+		req.UpdatedAt = now
+	}
+	s.requests[req.ReqID] = *req
+	return nil
+}
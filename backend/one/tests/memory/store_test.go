@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kartpop/cruncan/backend/one/database/idempotency"
+	"github.com/kartpop/cruncan/backend/one/database/onerequest"
+	"github.com/kartpop/cruncan/backend/one/database/outbox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreList(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, id := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, store.Create(ctx, &onerequest.OneRequest{ReqID: id, UserID: "user", CreatedAt: start.Add(time.Duration(i) * time.Hour)}))
+	}
+	require.NoError(t, store.Create(ctx, &onerequest.OneRequest{ReqID: "e", UserID: "other"}))
+	assert.Error(t, store.Create(ctx, &onerequest.OneRequest{ReqID: "a", UserID: "user"}))
+
+	tests := []struct {
+		name   string
+		filter onerequest.ListFilter
+		want   []string
+	}{
+		{name: "newest first", filter: onerequest.ListFilter{UserID: "user"}, want: []string{"d", "c", "b", "a"}},
+		{name: "before and limit", filter: onerequest.ListFilter{UserID: "user", Before: "d", Limit: 2}, want: []string{"c", "b"}},
+		{
+			name:   "created range",
+			filter: onerequest.ListFilter{UserID: "user", CreatedAfter: start.Add(time.Hour), CreatedBefore: start.Add(3 * time.Hour)},
+			want:   []string{"c", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, err := store.List(ctx, tt.filter)
+			require.NoError(t, err)
+			var ids []string
+			for _, req := range reqs {
+				ids = append(ids, req.ReqID)
+			}
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+}
+
+func TestStoreCreateIdempotent(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+
+	stored, err := store.CreateIdempotent(ctx, &onerequest.OneRequest{ReqID: "a"}, &outbox.Message{ID: "a"}, &idempotency.Key{Key: "key", ReqID: "a", Status: 202}, time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	stored, err = store.CreateIdempotent(ctx, &onerequest.OneRequest{ReqID: "b"}, &outbox.Message{ID: "b"}, &idempotency.Key{Key: "key", ReqID: "b"}, time.Hour)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, "a", stored.ReqID)
+	_, err = store.Get(ctx, "b")
+	assert.ErrorIs(t, err, onerequest.ErrNotFound)
+
+	// keys are scoped by their user
+	stored, err = store.CreateIdempotent(ctx, &onerequest.OneRequest{ReqID: "b"}, &outbox.Message{ID: "b"}, &idempotency.Key{UserID: "other", Key: "key", ReqID: "b"}, time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	// an expired key is taken over by the next request
+	stored, err = store.CreateIdempotent(ctx, &onerequest.OneRequest{ReqID: "c"}, &outbox.Message{ID: "c"}, &idempotency.Key{Key: "other", ReqID: "c"}, -time.Second)
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+	stored, err = store.CreateIdempotent(ctx, &onerequest.OneRequest{ReqID: "d"}, &outbox.Message{ID: "d"}, &idempotency.Key{Key: "other", ReqID: "d"}, time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+}
+
+func TestStoreProcessPending(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+	for _, id := range []string{"c", "a", "b"} {
+		require.NoError(t, store.CreateWithOutbox(ctx, &onerequest.OneRequest{ReqID: id}, &outbox.Message{ID: id}))
+	}
+
+	var published []string
+	sent, err := store.ProcessPending(ctx, 10, func(ctx context.Context, msgs []*outbox.Message) (int, error) {
+		// b fails, c was published after it but stays pending
+		assert.Equal(t, []string{"a", "b", "c"}, ids(msgs))
+		published = append(published, msgs[0].ID)
+		return 1, errors.New("unavailable")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, sent)
+
+	sent, err = store.ProcessPending(ctx, 10, func(ctx context.Context, msgs []*outbox.Message) (int, error) {
+		published = append(published, ids(msgs)...)
+		return len(msgs), nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []string{"a", "b", "c"}, published)
+}
+
+func ids(msgs []*outbox.Message) []string {
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	return ids
+}
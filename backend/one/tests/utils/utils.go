@@ -1,24 +1,112 @@
 package utils
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
 
+	"github.com/alexedwards/flow"
 	"github.com/kartpop/cruncan/backend/one/config"
+	"github.com/kartpop/cruncan/backend/one/database/idempotency"
+	"github.com/kartpop/cruncan/backend/one/database/onerequest"
+	"github.com/kartpop/cruncan/backend/one/database/outbox"
+	oneHttp "github.com/kartpop/cruncan/backend/one/http"
+	"github.com/kartpop/cruncan/backend/one/relay"
+	"github.com/kartpop/cruncan/backend/one/tests/memory"
 	cfgUtil "github.com/kartpop/cruncan/backend/pkg/config"
 	gormUtil "github.com/kartpop/cruncan/backend/pkg/database/gorm"
-	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	"github.com/kartpop/cruncan/backend/pkg/id"
+	"github.com/kartpop/cruncan/backend/pkg/kafka/kafkatest"
 	"gorm.io/gorm"
 )
 
 var EnvConfig = cfgUtil.LoadConfigOrPanic[config.Model]("../../config", "config")
 
-func InitKafkaClient() *kafkaUtil.Client {
-	kafkaClient, err := kafkaUtil.NewClient(EnvConfig.Kafka.Common)
+// DatabaseEnv selects the database the service runs against in tests. By default it is the database of the
+// config, so the tests exercise the SQL of the repositories; set it to "memory" to run them without a database.
+const DatabaseEnv = "ONE_TEST_DATABASE"
+
+// Service runs the HTTP handler and the outbox relay of the service in-process, against a fake kafka cluster
+// and the database selected by DatabaseEnv
+type Service struct {
+	URL   string
+	Kafka *kafkatest.Cluster
+	// OneRequests records the messages the relay publishes to the one request topic
+	OneRequests *kafkatest.Recorder
+	// OneRequestRepo reads the requests that the service stored
+	OneRequestRepo onerequest.Repository
+	// Reset deletes the requests, outbox messages and idempotency keys that the service stored
+	Reset func(ctx context.Context)
+}
+
+// StartService starts the service, it is stopped when the test ends
+func StartService(t testing.TB) *Service {
+	cfg := EnvConfig
+	cluster := kafkatest.NewCluster(t, cfg.Kafka.OneRequestTopic)
+	oneRequests := cluster.Record(t, cfg.Kafka.OneRequestTopic.Name)
+
+	oneRequestRepo, outboxRepo, reset := initDatabase(t)
+	idService, err := id.NewServiceFromIP(cfg.PodIP)
 	if err != nil {
-		panic(fmt.Sprintf("failed to create kafka client: %v", err))
+		t.Fatalf("failed to create id service: %v", err)
+	}
+
+	ctx := context.Background()
+	kafkaClient := cluster.NewClient(t, cfg.Kafka.Common)
+	outboxRelay := relay.NewRelay(ctx, outboxRepo, map[string]relay.Producer{
+		cfg.Kafka.OneRequestTopic.Name: kafkaClient.NewProducer(cfg.Kafka.OneRequestTopic.Name),
+	}, time.Duration(cfg.Outbox.PollInterval)*time.Millisecond, cfg.Outbox.BatchSize)
+	outboxRelay.Start(ctx)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), kafkatest.Timeout)
+		defer cancel()
+		_ = outboxRelay.Stop(ctx)
+	})
+
+	oneHandler := oneHttp.NewHandler(ctx, oneRequestRepo, idService, cfg.Kafka.OneRequestTopic.Name, cfg.Idempotency.Window)
+	mux := flow.New()
+	mux.HandleFunc("/one", oneHandler.Post, http.MethodPost)
+	mux.HandleFunc("/one", oneHandler.List, http.MethodGet)
+	mux.HandleFunc("/one/:id", oneHandler.Get, http.MethodGet)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return &Service{
+		URL:            server.URL,
+		Kafka:          cluster,
+		OneRequests:    oneRequests,
+		OneRequestRepo: oneRequestRepo,
+		Reset:          reset,
 	}
+}
 
-	return kafkaClient
+// initDatabase creates the repositories of the service on the database selected by DatabaseEnv
+func initDatabase(t testing.TB) (onerequest.Repository, outbox.Repository, func(ctx context.Context)) {
+	switch db := os.Getenv(DatabaseEnv); db {
+	case "memory":
+		store := memory.NewStore()
+		return store, store, func(context.Context) { store.Reset() }
+	case "", "postgres":
+		gormClient := InitGorm()
+		t.Cleanup(func() {
+			if db, err := gormClient.DB(); err == nil {
+				_ = db.Close()
+			}
+		})
+		reset := func(ctx context.Context) {
+			gormClient.WithContext(ctx).Where("1 = 1").Delete(&onerequest.OneRequest{})
+			gormClient.WithContext(ctx).Where("1 = 1").Delete(&outbox.Message{})
+			gormClient.WithContext(ctx).Where("1 = 1").Delete(&idempotency.Key{})
+		}
+		return onerequest.NewRepository(gormClient), outbox.NewRepository(gormClient), reset
+	default:
+		t.Fatalf("unknown %s %q, expected memory or postgres", DatabaseEnv, db)
+		return nil, nil, nil
+	}
 }
 
 func InitGorm() *gorm.DB {
@@ -0,0 +1,204 @@
+// Package auth authenticates HTTP requests by the bearer JWT of their Authorization header. The tokens are
+// verified with the keys of the issuer's JSON Web Key Set, see Config, and the authenticated Principal is put
+// into the request context for handlers to authorize against, see PrincipalFromContext.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrMissingToken is the error of a request without a bearer token
+	ErrMissingToken = errors.New("missing bearer token")
+	// ErrInvalidToken is the error of a request whose token fails verification
+	ErrInvalidToken = errors.New("invalid bearer token")
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// ID is the value of the principal claim, see Config.PrincipalClaim
+	ID string
+	// Claims are all the claims of the token
+	Claims map[string]any
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of the context that carries the principal
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal of an authenticated request, see Authenticator.Middleware
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// Authenticator authenticates requests by their bearer JWT
+type Authenticator struct {
+	cfg          Config
+	keys         *keySet
+	parser       *jwt.Parser
+	httpClient   *http.Client
+	unauthorized func(http.ResponseWriter, *http.Request, error)
+}
+
+type Option func(*Authenticator)
+
+// WithUnauthorizedHandler replaces the plain text 401 response of requests that fail authentication, e.g. to
+// answer in the error format of the service. The error wraps ErrMissingToken or ErrInvalidToken.
+func WithUnauthorizedHandler(unauthorized func(http.ResponseWriter, *http.Request, error)) Option {
+	return func(a *Authenticator) {
+		a.unauthorized = unauthorized
+	}
+}
+
+// WithHTTPClient sets the client that fetches the key set, defaults to a client with a 10s timeout
+func WithHTTPClient(client *http.Client) Option {
+	return func(a *Authenticator) {
+		a.httpClient = client
+	}
+}
+
+// NewAuthenticator creates an authenticator that refreshes the key set in the background until the context is
+// done. A key set that cannot be fetched on startup is logged and fetched again by the first token.
+func NewAuthenticator(ctx context.Context, cfg Config, opts ...Option) (*Authenticator, error) {
+	cfg = cfg.withDefaults()
+	if cfg.JwksURL == "" {
+		return nil, errors.New("jwks url is required")
+	}
+	for _, alg := range cfg.Algorithms {
+		// symmetric algorithms have no public key to verify with, and none verifies nothing
+		switch jwt.GetSigningMethod(alg).(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		default:
+			return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+		}
+	}
+
+	a := &Authenticator{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		unauthorized: func(w http.ResponseWriter, _ *http.Request, _ error) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(cfg.Algorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if len(cfg.Audience) > 0 {
+		parserOpts = append(parserOpts, jwt.WithAudience(cfg.Audience...))
+	}
+	a.parser = jwt.NewParser(parserOpts...)
+
+	a.keys = newKeySet(cfg.JwksURL, a.httpClient, cfg.RefreshInterval, cfg.MinRefreshInterval)
+	if err := a.keys.refresh(ctx); err != nil {
+		slog.WarnContext(ctx, err.Error())
+	}
+	go a.keys.run(ctx)
+
+	return a, nil
+}
+
+// Middleware passes requests with a valid token on with their principal in the context, see
+// PrincipalFromContext, and answers the others with 401 Unauthorized
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.Authenticate(r)
+		if err != nil {
+			slog.WarnContext(r.Context(), fmt.Sprintf("authentication failed for %s %s: %v", r.Method, r.URL.Path, err))
+			challenge := "Bearer"
+			if !errors.Is(err, ErrMissingToken) {
+				challenge = `Bearer error="invalid_token"`
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			a.unauthorized(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), principal)))
+	})
+}
+
+// Authenticate verifies the bearer token of the request and returns its principal
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, tokenString, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || tokenString == "" {
+		return nil, ErrMissingToken
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid")
+		}
+		key, err := a.keys.key(r.Context(), kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != "" && key.alg != token.Method.Alg() {
+			return nil, fmt.Errorf("key %q is for %s, not %s", kid, key.alg, token.Method.Alg())
+		}
+		return key.key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	for _, rule := range a.cfg.Claims {
+		if err := rule.check(claims); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+		}
+	}
+	id, _ := claims[a.cfg.PrincipalClaim].(string)
+	if id == "" {
+		return nil, fmt.Errorf("%w: claim %s is missing", ErrInvalidToken, a.cfg.PrincipalClaim)
+	}
+
+	return &Principal{ID: id, Claims: claims}, nil
+}
+
+func (r ClaimRule) check(claims jwt.MapClaims) error {
+	claim, ok := claims[r.Name]
+	if !ok {
+		return fmt.Errorf("claim %s is missing", r.Name)
+	}
+	if len(r.Values) == 0 {
+		return nil
+	}
+
+	switch value := claim.(type) {
+	case string:
+		if slices.Contains(r.Values, value) {
+			return nil
+		}
+	case []any:
+		for _, v := range value {
+			if s, ok := v.(string); ok && slices.Contains(r.Values, s) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("claim %s has no accepted value", r.Name)
+}
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jwksServer is a local issuer that serves the public keys of its signing keys
+type jwksServer struct {
+	*httptest.Server
+	fetches atomic.Int32
+
+	mu   sync.Mutex
+	keys map[string]crypto.Signer
+}
+
+func newJwksServer(t *testing.T) *jwksServer {
+	s := &jwksServer{keys: map[string]crypto.Signer{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		set := struct {
+			Keys []jwk `json:"keys"`
+		}{}
+		for kid, key := range s.keys {
+			set.Keys = append(set.Keys, toJWK(kid, key.Public()))
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) addKey(kid string, key crypto.Signer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = key
+}
+
+func toJWK(kid string, key crypto.PublicKey) jwk {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return jwk{Kty: "RSA", Kid: kid, Use: "sig", N: enc(key.N.Bytes()), E: enc(big.NewInt(int64(key.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return jwk{Kty: "EC", Kid: kid, Crv: key.Curve.Params().Name, X: enc(key.X.FillBytes(make([]byte, size))), Y: enc(key.Y.FillBytes(make([]byte, size)))}
+	case ed25519.PublicKey:
+		return jwk{Kty: "OKP", Kid: kid, Crv: "Ed25519", X: enc(key)}
+	}
+	panic("unsupported key")
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key crypto.Signer, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub":       "usr-1",
+		"iss":       "https://issuer.test",
+		"aud":       []string{"one"},
+		"client_id": "cruncan",
+		"iat":       now.Unix(),
+		"exp":       now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	server := newJwksServer(t)
+	server.addKey("rsa", rsaKey)
+	server.addKey("ec", ecKey)
+	server.addKey("ed", edKey)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	authenticator, err := NewAuthenticator(ctx, Config{
+		JwksURL:  server.URL,
+		Issuer:   "https://issuer.test",
+		Audience: []string{"one", "two"},
+		Claims:   []ClaimRule{{Name: "client_id", Values: []string{"cruncan"}}},
+	})
+	require.NoError(t, err)
+
+	with := func(modify func(jwt.MapClaims)) jwt.MapClaims {
+		claims := validClaims()
+		modify(claims)
+		return claims
+	}
+
+	scenarios := []struct {
+		name          string
+		authorization string
+		expectedErr   error
+	}{
+		{
+			name:          "RS256",
+			authorization: "Bearer " + sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims()),
+		},
+		{
+			name:          "ES256",
+			authorization: "Bearer " + sign(t, jwt.SigningMethodES256, "ec", ecKey, validClaims()),
+		},
+		{
+			name:          "EdDSA",
+			authorization: "Bearer " + sign(t, jwt.SigningMethodEdDSA, "ed", edKey, validClaims()),
+		},
+		{
+			name:          "missing token",
+			authorization: "",
+			expectedErr:   ErrMissingToken,
+		},
+		{
+			name:          "not a bearer token",
+			authorization: "Basic dXNlcjpwYXNz",
+			expectedErr:   ErrMissingToken,
+		},
+		{
+			name:          "expired",
+			authorization: "Bearer " + sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })),
+			expectedErr:   jwt.ErrTokenExpired,
+		},
+		{
+			name:          "without expiry",
+			authorization: "Bearer " + sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { delete(c, "exp") })),
+			expectedErr:   jwt.ErrTokenRequiredClaimMissing,
+		},
+		{
+			name:          "other issuer",
+			authorization: "Bearer " + sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["iss"] = "https://evil.test" })),
+			expectedErr:   jwt.ErrTokenInvalidIssuer,
+		},
+		{
+			name:          "other audience",
+			authorization: "Bearer " + sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["aud"] = "three" })),
+			expectedErr:   jwt.ErrTokenInvalidAudience,
+		},
+		{
+			name:          "claim rule not met",
+			authorization: "Bearer " + sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["client_id"] = "other" })),
+			expectedErr:   ErrInvalidToken,
+		},
+		{
+			name:          "without principal claim",
+			authorization: "Bearer " + sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { delete(c, "sub") })),
+			expectedErr:   ErrInvalidToken,
+		},
+		{
+			name:          "signed by another key",
+			authorization: "Bearer " + sign(t, jwt.SigningMethodRS256, "rsa", otherKey, validClaims()),
+			expectedErr:   jwt.ErrTokenSignatureInvalid,
+		},
+		{
+			name:          "algorithm not accepted",
+			authorization: "Bearer " + sign(t, jwt.SigningMethodRS512, "rsa", rsaKey, validClaims()),
+			expectedErr:   jwt.ErrTokenSignatureInvalid,
+		},
+		{
+			name: "unsigned",
+			authorization: "Bearer " + func() string {
+				s, _ := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+				return s
+			}(),
+			expectedErr: jwt.ErrTokenSignatureInvalid,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			var principal *Principal
+			handler := authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal, _ = PrincipalFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/one", nil)
+			if s.authorization != "" {
+				req.Header.Set("Authorization", s.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if s.expectedErr == nil {
+				assert.Equal(t, http.StatusOK, recorder.Code)
+				require.NotNil(t, principal)
+				assert.Equal(t, "usr-1", principal.ID)
+				assert.Equal(t, "cruncan", principal.Claims["client_id"])
+				return
+			}
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+			assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), "Bearer")
+			assert.Nil(t, principal)
+			_, err := authenticator.Authenticate(req)
+			assert.ErrorIs(t, err, s.expectedErr)
+		})
+	}
+}
+
+func TestAuthenticatorKeyRotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := newJwksServer(t)
+	server.addKey("old", oldKey)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var unauthorized error
+	authenticator, err := NewAuthenticator(ctx, Config{JwksURL: server.URL, MinRefreshInterval: time.Hour},
+		WithUnauthorizedHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			unauthorized = err
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, server.fetches.Load())
+
+	authenticate := func(kid string, key crypto.Signer) int {
+		req := httptest.NewRequest(http.MethodGet, "/one", nil)
+		req.Header.Set("Authorization", "Bearer "+sign(t, jwt.SigningMethodES256, kid, key, validClaims()))
+		recorder := httptest.NewRecorder()
+		authenticator.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, authenticate("old", oldKey))
+	assert.EqualValues(t, 1, server.fetches.Load(), "known keys are not fetched again")
+
+	// the issuer rotates its key, the first token signed with it refreshes the key set
+	server.addKey("new", newKey)
+	assert.Equal(t, http.StatusOK, authenticate("new", newKey))
+	assert.EqualValues(t, 2, server.fetches.Load())
+
+	// unknown keys do not refresh the key set again within MinRefreshInterval
+	for range 5 {
+		assert.Equal(t, http.StatusUnauthorized, authenticate("unknown", newKey))
+	}
+	assert.EqualValues(t, 2, server.fetches.Load())
+	assert.ErrorIs(t, unauthorized, ErrInvalidToken)
+}
+
+func TestAuthenticatorBackgroundRefresh(t *testing.T) {
+	server := newJwksServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := NewAuthenticator(ctx, Config{JwksURL: server.URL, RefreshInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return server.fetches.Load() >= 3 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	fetches := server.fetches.Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, fetches, server.fetches.Load(), "refreshing stops with the context")
+}
+
+func TestNewAuthenticatorConfig(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := NewAuthenticator(ctx, Config{})
+	assert.Error(t, err)
+	_, err = NewAuthenticator(ctx, Config{JwksURL: "http://127.0.0.1:0", Algorithms: []string{"HS256"}})
+	assert.Error(t, err)
+	_, err = NewAuthenticator(ctx, Config{JwksURL: "http://127.0.0.1:0", Algorithms: []string{"none"}})
+	assert.Error(t, err)
+
+	// an issuer that is down on startup does not stop the service
+	_, err = NewAuthenticator(ctx, Config{JwksURL: "http://127.0.0.1:0"})
+	assert.NoError(t, err)
+}
+
+func TestPrincipalFromContext(t *testing.T) {
+	_, ok := PrincipalFromContext(context.Background())
+	assert.False(t, ok)
+
+	principal, ok := PrincipalFromContext(ContextWithPrincipal(context.Background(), &Principal{ID: "usr-1"}))
+	assert.True(t, ok)
+	assert.Equal(t, "usr-1", principal.ID)
+}
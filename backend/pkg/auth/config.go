@@ -0,0 +1,51 @@
+package auth
+
+import "time"
+
+// Config configures an Authenticator
+type Config struct {
+	// Enabled turns authentication on, services do not install the middleware without it
+	Enabled bool `mapstructure:"ENABLED"`
+	// JwksURL is the url of the JSON Web Key Set of the issuer
+	JwksURL string `mapstructure:"JWKS_URL"`
+	// Issuer is the required iss claim, it is not checked if empty
+	Issuer string `mapstructure:"ISSUER"`
+	// Audience lists the accepted aud claims, a token needs one of them. It is not checked if empty.
+	Audience []string `mapstructure:"AUDIENCE"`
+	// Algorithms lists the accepted signing algorithms, defaults to RS256, ES256 and EdDSA
+	Algorithms []string `mapstructure:"ALGORITHMS"`
+	// Leeway is the clock skew allowed when checking exp, nbf and iat
+	Leeway time.Duration `mapstructure:"LEEWAY"`
+	// PrincipalClaim is the claim that identifies the principal, defaults to sub
+	PrincipalClaim string `mapstructure:"PRINCIPAL_CLAIM"`
+	// Claims are further rules the claims of a token must satisfy, e.g. on client_id
+	Claims []ClaimRule `mapstructure:"CLAIMS"`
+	// RefreshInterval is how often the key set is refreshed in the background, defaults to 15m
+	RefreshInterval time.Duration `mapstructure:"REFRESH_INTERVAL"`
+	// MinRefreshInterval bounds how often tokens signed by an unknown key refresh the key set, defaults to 1m
+	MinRefreshInterval time.Duration `mapstructure:"MIN_REFRESH_INTERVAL"`
+}
+
+// ClaimRule requires a claim of a token
+type ClaimRule struct {
+	Name string `mapstructure:"NAME"`
+	// Values are the accepted values of the claim, a claim that is a list needs to hold one of them. Any value is
+	// accepted if empty.
+	Values []string `mapstructure:"VALUES"`
+}
+
+func (c Config) withDefaults() Config {
+	if len(c.Algorithms) == 0 {
+		c.Algorithms = []string{"RS256", "ES256", "EdDSA"}
+	}
+	if c.PrincipalClaim == "" {
+		c.PrincipalClaim = "sub"
+	}
+	if c.RefreshInterval <= 0 {
+		c.RefreshInterval = 15 * time.Minute
+	}
+	if c.MinRefreshInterval <= 0 {
+		c.MinRefreshInterval = time.Minute
+	}
+	return c
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// jwk is a public key of a JSON Web Key Set, see RFC 7517 and RFC 8037
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// N and E are the modulus and exponent of an RSA key
+	N string `json:"n"`
+	E string `json:"e"`
+	// Crv, X and Y are the curve and coordinates of an EC key, an OKP key has no Y
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a key of the set with the algorithm it is restricted to, if any
+type publicKey struct {
+	key any
+	alg string
+}
+
+// keySet holds the signing keys of a JSON Web Key Set by key id. It is refreshed in the background and when a
+// token is signed by a key it does not hold, e.g. after the issuer rotated its keys, but at most every
+// minInterval, so tokens with made up key ids do not hammer the issuer.
+type keySet struct {
+	url         string
+	client      *http.Client
+	interval    time.Duration
+	minInterval time.Duration
+
+	keys atomic.Pointer[map[string]publicKey]
+
+	// mu serialises the refreshes, concurrent misses wait for a single refresh
+	mu sync.Mutex
+	// missRefreshed is when a miss last refreshed the set, whether or not that succeeded
+	missRefreshed time.Time
+}
+
+func newKeySet(url string, client *http.Client, interval, minInterval time.Duration) *keySet {
+	s := &keySet{url: url, client: client, interval: interval, minInterval: minInterval}
+	s.keys.Store(&map[string]publicKey{})
+	return s
+}
+
+// key returns the key with the id, refreshing the set if it does not hold it
+func (s *keySet) key(ctx context.Context, kid string) (publicKey, error) {
+	if key, ok := (*s.keys.Load())[kid]; ok {
+		return key, nil
+	}
+
+	s.mu.Lock()
+	if time.Since(s.missRefreshed) >= s.minInterval {
+		s.missRefreshed = time.Now()
+		if err := s.refreshLocked(ctx); err != nil {
+			s.mu.Unlock()
+			return publicKey{}, err
+		}
+	}
+	s.mu.Unlock()
+
+	if key, ok := (*s.keys.Load())[kid]; ok {
+		return key, nil
+	}
+	return publicKey{}, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (s *keySet) refresh(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshLocked(ctx)
+}
+
+func (s *keySet) refreshLocked(ctx context.Context) error {
+	keys, err := s.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to refresh jwks from %s: %w", s.url, err)
+	}
+	s.keys.Store(&keys)
+	return nil
+}
+
+// run refreshes the set every interval until the context is done, a failed refresh keeps the keys it had
+func (s *keySet) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.refresh(ctx); err != nil {
+				slog.WarnContext(ctx, err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *keySet) fetch(ctx context.Context) (map[string]publicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode key set: %w", err)
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		// keys meant for encryption are no use to verify signatures
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			slog.WarnContext(ctx, fmt.Sprintf("skipping key %q of jwks %s: %v", k.Kid, s.url, err))
+			continue
+		}
+		keys[k.Kid] = publicKey{key: key, alg: k.Alg}
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var check ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, check = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, check = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, check = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("invalid coordinates")
+		}
+		// the uncompressed point is only accepted if it is on the curve
+		if _, err := check.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("invalid point: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,186 @@
+// Command kafkactl runs kafka maintenance tasks against the cluster of a service config.
+//
+//	kafkactl provision -config one/config/config.yaml -dryRun
+//	kafkactl offsets -config two/config/config.yaml
+//	kafkactl reset -config two/config/config.yaml -topic one-request-local -to 2024-05-01T10:00:00Z -dryRun
+//	kafkactl replay -config two/config/config.yaml -from one-request-local.dlq -to one-request-local -start 2024-05-01T10:00:00Z
+//	kafkactl dump -config two/config/config.yaml -topic one-request-local.dlq > dlq.ndjson
+//
+// The kafka settings are read from KAFKA_CONFIG, where COMMON is the client config and every other
+// entry with a NAME is a topic. Commands that change topics, offsets or records support -dryRun, which
+// prints the changes without making them.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	"github.com/spf13/viper"
+)
+
+const usage = `usage: kafkactl <command> [flags]
+
+commands:
+  provision  create the configured topics, grow their partitions and update their topic configs
+  offsets    list the committed offsets and lag of a consumer group
+  reset      reset the offsets of a stopped consumer group to a time or to offsets per partition
+  replay     copy the records of a time range from one topic to another, e.g. from a dead letter topic
+  dump       write the records of a time range as NDJSON, with their headers
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "provision":
+		err = provision(os.Args[2:])
+	case "offsets":
+		err = offsets(os.Args[2:])
+	case "reset":
+		err = reset(os.Args[2:])
+	case "replay":
+		err = replay(os.Args[2:])
+	case "dump":
+		err = dump(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kafkactl %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// clientFlags are the flags of every command to connect to the cluster of the service config
+type clientFlags struct {
+	configPath   *string
+	kafkaServers *string
+	timeout      *time.Duration
+}
+
+func newClientFlags(flags *flag.FlagSet, timeout time.Duration) clientFlags {
+	return clientFlags{
+		configPath:   flags.String("config", "config/config.yaml", "service config file"),
+		kafkaServers: flags.String("kafkaServers", "", "Kafka bootstrap servers, overrides the config"),
+		timeout:      flags.Duration("timeout", timeout, "timeout for the command"),
+	}
+}
+
+// connection is a client for the cluster of the service config that does not join the group of the service
+type connection struct {
+	client *kafkaUtil.Client
+	// group is the consumer group of the service
+	group  string
+	topics []kafkaUtil.Topic
+}
+
+// connect loads the service config and connects to its cluster, the returned context is done after the
+// timeout of the flags
+func (f clientFlags) connect() (context.Context, context.CancelFunc, *connection, error) {
+	common, topics, err := loadKafkaConfig(*f.configPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if *f.kafkaServers != "" {
+		common.BootstrapServers = strings.Split(*f.kafkaServers, ",")
+	}
+	group := common.GroupId
+	common.GroupId = ""
+
+	ctx, cancel := context.WithTimeout(context.Background(), *f.timeout)
+	client, err := kafkaUtil.NewClient(ctx, common)
+	if err != nil {
+		cancel()
+		return nil, nil, nil, fmt.Errorf("failed to create kafka client: %w", err)
+	}
+	return ctx, cancel, &connection{client: client, group: group, topics: topics}, nil
+}
+
+func provision(args []string) error {
+	flags := flag.NewFlagSet("provision", flag.ExitOnError)
+	clientFlags := newClientFlags(flags, time.Minute)
+	allowDrift := flags.Bool("allowDrift", false, "succeed even if some drift could not be fixed")
+	dryRun := flags.Bool("dryRun", false, "only print the topics that would be created and the drift that would be updated")
+	flags.Parse(args)
+
+	ctx, cancel, conn, err := clientFlags.connect()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer conn.client.Close(ctx)
+	if len(conn.topics) == 0 {
+		return fmt.Errorf("config %s has no topics in KAFKA_CONFIG", *clientFlags.configPath)
+	}
+
+	admin := conn.client.NewAdmin()
+	ensure := admin.EnsureTopics
+	if *dryRun {
+		ensure = admin.PlanTopics
+	}
+	report, err := ensure(ctx, conn.topics...)
+	if err != nil {
+		// report what was done before the failure
+		if len(report.Created) > 0 || len(report.Drift) > 0 {
+			fmt.Print(report)
+		}
+		return err
+	}
+	fmt.Print(report)
+	if unresolved := report.Unresolved(); len(unresolved) > 0 && !*allowDrift {
+		return fmt.Errorf("%d settings drifted and could not be fixed", len(unresolved))
+	}
+	return nil
+}
+
+// loadKafkaConfig reads the client config and the topics from the KAFKA_CONFIG section of the config file
+func loadKafkaConfig(path string) (*kafkaUtil.Config, []kafkaUtil.Topic, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	kafkaConfig := v.Sub("KAFKA_CONFIG")
+	if kafkaConfig == nil {
+		return nil, nil, fmt.Errorf("config %s has no KAFKA_CONFIG", path)
+	}
+
+	var common kafkaUtil.Config
+	if err := kafkaConfig.UnmarshalKey("COMMON", &common); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode KAFKA_CONFIG.COMMON: %w", err)
+	}
+
+	// viper lower cases the keys
+	settings := kafkaConfig.AllSettings()
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var topics []kafkaUtil.Topic
+	for _, key := range keys {
+		entry, ok := settings[key].(map[string]any)
+		if !ok || entry["name"] == nil {
+			continue
+		}
+		var topic kafkaUtil.Topic
+		if err := kafkaConfig.UnmarshalKey(key, &topic); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode KAFKA_CONFIG.%s: %w", strings.ToUpper(key), err)
+		}
+		topics = append(topics, topic)
+	}
+	return &common, topics, nil
+}
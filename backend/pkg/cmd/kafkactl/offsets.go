@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func offsets(args []string) error {
+	flags := flag.NewFlagSet("offsets", flag.ExitOnError)
+	clientFlags := newClientFlags(flags, time.Minute)
+	group := flags.String("group", "", "consumer group, defaults to the GROUP_ID of the config")
+	flags.Parse(args)
+
+	ctx, cancel, conn, err := clientFlags.connect()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer conn.client.Close(ctx)
+	if *group == "" {
+		*group = conn.group
+	}
+
+	lag, err := conn.client.NewAdmin().GroupLag(ctx, *group)
+	if err != nil {
+		return err
+	}
+	fmt.Print(lag)
+	return nil
+}
+
+func reset(args []string) error {
+	flags := flag.NewFlagSet("reset", flag.ExitOnError)
+	clientFlags := newClientFlags(flags, time.Minute)
+	group := flags.String("group", "", "consumer group, defaults to the GROUP_ID of the config")
+	topic := flags.String("topic", "", "topic to reset the offsets of")
+	to := flags.String("to", "", "earliest, latest or an RFC 3339 time to reset every partition to")
+	partitionOffsets := flags.String("offsets", "", "offsets per partition to reset to, e.g. 0=120,1=98")
+	dryRun := flags.Bool("dryRun", false, "only print the offsets that would be reset")
+	flags.Parse(args)
+
+	if *topic == "" {
+		return errors.New("-topic is required")
+	}
+	if (*to == "") == (*partitionOffsets == "") {
+		return errors.New("either -to or -offsets is required")
+	}
+
+	ctx, cancel, conn, err := clientFlags.connect()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer conn.client.Close(ctx)
+	if *group == "" {
+		*group = conn.group
+	}
+
+	admin := conn.client.NewAdmin()
+	var target map[int32]int64
+	switch *to {
+	case "":
+		target, err = parsePartitionOffsets(*partitionOffsets)
+	case "earliest":
+		target, err = admin.StartOffsets(ctx, *topic)
+	case "latest":
+		target, err = admin.EndOffsets(ctx, *topic)
+	default:
+		var t time.Time
+		t, err = parseTime("to", *to)
+		if err == nil {
+			target, err = admin.OffsetsAt(ctx, *topic, t)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	report, err := admin.ResetGroupOffsets(ctx, *group, *topic, target, *dryRun)
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+	return nil
+}
+
+// parsePartitionOffsets parses a comma separated list of partition=offset pairs
+func parsePartitionOffsets(value string) (map[int32]int64, error) {
+	offsets := make(map[int32]int64)
+	for _, pair := range strings.Split(value, ",") {
+		partition, offset, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid partition offset %q, expected partition=offset", pair)
+		}
+		p, err := strconv.ParseInt(partition, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid partition %q: %w", partition, err)
+		}
+		o, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset %q: %w", offset, err)
+		}
+		offsets[int32(p)] = o
+	}
+	return offsets, nil
+}
+
+// parsePartitions parses a comma separated list of partitions, empty for all partitions
+func parsePartitions(value string) ([]int32, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var partitions []int32
+	for _, partition := range strings.Split(value, ",") {
+		p, err := strconv.ParseInt(strings.TrimSpace(partition), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid partition %q: %w", partition, err)
+		}
+		partitions = append(partitions, int32(p))
+	}
+	return partitions, nil
+}
+
+// parseTime parses the RFC 3339 time of the flag, empty is the zero time
+func parseTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -%s: %w", name, err)
+	}
+	return t, nil
+}
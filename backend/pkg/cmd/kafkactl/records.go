@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+)
+
+// rangeFlags select the records of a topic, see kafkaUtil.Range
+type rangeFlags struct {
+	start      *string
+	end        *string
+	partitions *string
+}
+
+func newRangeFlags(flags *flag.FlagSet) rangeFlags {
+	return rangeFlags{
+		start:      flags.String("start", "", "RFC 3339 time of the first record, defaults to the start of the partitions"),
+		end:        flags.String("end", "", "RFC 3339 time up to which records are read, defaults to the end of the partitions"),
+		partitions: flags.String("partitions", "", "partitions to read, e.g. 0,2, defaults to all partitions"),
+	}
+}
+
+func (f rangeFlags) parse(topic string) (kafkaUtil.Range, error) {
+	start, err := parseTime("start", *f.start)
+	if err != nil {
+		return kafkaUtil.Range{}, err
+	}
+	end, err := parseTime("end", *f.end)
+	if err != nil {
+		return kafkaUtil.Range{}, err
+	}
+	partitions, err := parsePartitions(*f.partitions)
+	if err != nil {
+		return kafkaUtil.Range{}, err
+	}
+	return kafkaUtil.Range{Topic: topic, Start: start, End: end, Partitions: partitions}, nil
+}
+
+func replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	clientFlags := newClientFlags(flags, 10*time.Minute)
+	rangeFlags := newRangeFlags(flags)
+	from := flags.String("from", "", "topic to copy the records from, e.g. a dead letter topic")
+	to := flags.String("to", "", "topic to copy the records to")
+	dryRun := flags.Bool("dryRun", false, "only count the records that would be copied")
+	flags.Parse(args)
+
+	if *from == "" || *to == "" {
+		return errors.New("-from and -to are required")
+	}
+	r, err := rangeFlags.parse(*from)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, conn, err := clientFlags.connect()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer conn.client.Close(ctx)
+
+	report, err := conn.client.Replay(ctx, r, *to, *dryRun)
+	fmt.Print(report)
+	return err
+}
+
+func dump(args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	clientFlags := newClientFlags(flags, 10*time.Minute)
+	rangeFlags := newRangeFlags(flags)
+	topic := flags.String("topic", "", "topic to dump")
+	out := flags.String("out", "", "file to write to, defaults to stdout")
+	flags.Parse(args)
+
+	if *topic == "" {
+		return errors.New("-topic is required")
+	}
+	r, err := rangeFlags.parse(*topic)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	buffered := bufio.NewWriter(w)
+
+	ctx, cancel, conn, err := clientFlags.connect()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer conn.client.Close(ctx)
+
+	encoder := json.NewEncoder(buffered)
+	err = conn.client.Read(ctx, r, func(message kafkaUtil.Message) error {
+		return encoder.Encode(newDumpRecord(message))
+	})
+	return errors.Join(err, buffered.Flush())
+}
+
+// dumpRecord is a line of the dump. Keys and values are written as text if they are valid UTF-8, otherwise
+// base64 encoded in the _base64 fields.
+type dumpRecord struct {
+	Topic       string       `json:"topic"`
+	Partition   int32        `json:"partition"`
+	Offset      int64        `json:"offset"`
+	Timestamp   time.Time    `json:"timestamp"`
+	Key         string       `json:"key,omitempty"`
+	KeyBase64   string       `json:"key_base64,omitempty"`
+	Value       string       `json:"value,omitempty"`
+	ValueBase64 string       `json:"value_base64,omitempty"`
+	Headers     []dumpHeader `json:"headers,omitempty"`
+}
+
+type dumpHeader struct {
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	ValueBase64 string `json:"value_base64,omitempty"`
+}
+
+func newDumpRecord(message kafkaUtil.Message) dumpRecord {
+	record := dumpRecord{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Timestamp: message.Timestamp,
+	}
+	record.Key, record.KeyBase64 = encodeBytes(message.Key)
+	record.Value, record.ValueBase64 = encodeBytes(message.Value)
+	for _, h := range message.Headers {
+		header := dumpHeader{Key: h.Key}
+		header.Value, header.ValueBase64 = encodeBytes(h.Value)
+		record.Headers = append(record.Headers, header)
+	}
+	return record
+}
+
+// encodeBytes returns b as text if it is valid UTF-8, or else base64 encoded
+func encodeBytes(b []byte) (text, encoded string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(b)
+}
@@ -41,6 +41,19 @@ func (i *ServiceImpl) GenerateID() string {
 	return fmt.Sprintf("%020d", i.node.Generate())
 }
 
+// IsValid reports whether id has the format of a generated ID, 20 decimal digits
+func IsValid(id string) bool {
+	if len(id) != 20 {
+		return false
+	}
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func NewServiceFromIP(iPv4 string) (*ServiceImpl, error) {
 	nodeId, err := nodeIDFromIP(iPv4)
 	if err != nil {
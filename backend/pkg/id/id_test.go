@@ -18,6 +18,19 @@ func TestIDShouldBe20CharsLong(t *testing.T) {
 	assert.Len(t, nodeId, 20, "expected 20 chars but got %v", len(nodeId))
 }
 
+func TestIsValid(t *testing.T) {
+	nodeIdSvc, err := NewServiceFromIP("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, IsValid(nodeIdSvc.GenerateID()))
+	assert.True(t, IsValid("00001234567890123456"))
+	assert.False(t, IsValid("123"))
+	assert.False(t, IsValid("0000123456789012345x"))
+	assert.False(t, IsValid(""))
+}
+
 func TestNodeIDFromIPShouldReturnInt64OrError(t *testing.T) {
 
 	testCases := []struct {
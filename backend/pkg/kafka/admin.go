@@ -0,0 +1,258 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type Admin struct {
+	client *kadm.Client
+}
+
+// NewAdmin creates a new kafka admin
+func NewAdmin(client *kgo.Client) *Admin {
+	return &Admin{client: kadm.NewClient(client)}
+}
+
+// TopicDrift is a setting of a topic that differs between the config and the cluster
+type TopicDrift struct {
+	Topic string
+	// Setting is partitions, replicas or the name of a topic config
+	Setting  string
+	Expected string
+	Actual   string
+	// Fixed reports whether the cluster was changed to match the config. Partitions can only grow
+	// and the replication factor is never changed, these drifts need to be resolved by hand.
+	Fixed bool
+}
+
+// ProvisionReport lists what EnsureTopics changed and found, or what it would change on a dry run, see PlanTopics
+type ProvisionReport struct {
+	Created []string
+	Drift   []TopicDrift
+	DryRun  bool
+}
+
+// Unresolved returns the drifts that EnsureTopics could not fix
+func (r *ProvisionReport) Unresolved() []TopicDrift {
+	var unresolved []TopicDrift
+	for _, d := range r.Drift {
+		if !d.Fixed {
+			unresolved = append(unresolved, d)
+		}
+	}
+	return unresolved
+}
+
+func (r *ProvisionReport) String() string {
+	created, updated := "created", "updated"
+	if r.DryRun {
+		created, updated = "would create", "would update"
+	}
+	var sb strings.Builder
+	for _, topic := range r.Created {
+		fmt.Fprintf(&sb, "%s %s\n", created, topic)
+	}
+	for _, d := range r.Drift {
+		action := "drift"
+		if d.Fixed {
+			action = updated
+		}
+		fmt.Fprintf(&sb, "%s %s %s: %s -> %s\n", action, d.Topic, d.Setting, d.Actual, d.Expected)
+	}
+	if sb.Len() == 0 {
+		return "topics up to date\n"
+	}
+	return sb.String()
+}
+
+// EnsureTopics creates the topics along with their retry tier and dead letter topics, see Topic.TopicNames.
+// Existing topics that have fewer partitions than configured are grown and their topic configs are updated.
+// Settings that cannot be changed are reported as unresolved drift.
+func (a *Admin) EnsureTopics(ctx context.Context, topics ...Topic) (*ProvisionReport, error) {
+	return a.ensureTopics(ctx, topics, false)
+}
+
+// PlanTopics reports what EnsureTopics would create and update, without changing the cluster. Drift that
+// EnsureTopics would fix is reported as fixed.
+func (a *Admin) PlanTopics(ctx context.Context, topics ...Topic) (*ProvisionReport, error) {
+	return a.ensureTopics(ctx, topics, true)
+}
+
+func (a *Admin) ensureTopics(ctx context.Context, topics []Topic, dryRun bool) (*ProvisionReport, error) {
+	report := &ProvisionReport{DryRun: dryRun}
+	for _, topic := range topics {
+		if err := a.ensureTopic(ctx, topic, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func (a *Admin) ensureTopic(ctx context.Context, topic Topic, report *ProvisionReport) error {
+	names := topic.TopicNames()
+	details, err := a.client.ListTopics(ctx, names...)
+	if err != nil {
+		return fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	var missing, existing []string
+	for _, name := range names {
+		detail, ok := details[name]
+		switch {
+		case !ok || errors.Is(detail.Err, kerr.UnknownTopicOrPartition):
+			missing = append(missing, name)
+		case detail.Err != nil:
+			return fmt.Errorf("failed to describe topic %s: %w", name, detail.Err)
+		default:
+			existing = append(existing, name)
+		}
+	}
+
+	configs := topic.TopicConfigs()
+	if len(missing) > 0 && report.DryRun {
+		report.Created = append(report.Created, missing...)
+	} else if len(missing) > 0 {
+		if err := a.createTopics(ctx, topic, configs, missing, report); err != nil {
+			return err
+		}
+	}
+	for _, name := range existing {
+		if err := a.checkPartitions(ctx, topic, details[name], report); err != nil {
+			return err
+		}
+	}
+	if len(existing) > 0 && len(configs) > 0 {
+		if err := a.checkConfigs(ctx, configs, existing, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Admin) createTopics(ctx context.Context, topic Topic, configs map[string]*string, names []string, report *ProvisionReport) error {
+	partitions, replicas := int32(-1), int16(-1)
+	if topic.PartitionCount > 0 {
+		partitions = int32(topic.PartitionCount)
+	}
+	if topic.ReplicaCount > 0 {
+		replicas = int16(topic.ReplicaCount)
+	}
+
+	resps, err := a.client.CreateTopics(ctx, partitions, replicas, configs, names...)
+	if err != nil {
+		return fmt.Errorf("failed to create topics: %w", err)
+	}
+	for _, name := range names {
+		resp := resps[name]
+		// another instance may have created the topic in the meantime
+		if resp.Err != nil && !errors.Is(resp.Err, kerr.TopicAlreadyExists) {
+			return fmt.Errorf("failed to create topic %s: %w", name, resp.Err)
+		}
+		report.Created = append(report.Created, name)
+	}
+	return nil
+}
+
+func (a *Admin) checkPartitions(ctx context.Context, topic Topic, detail kadm.TopicDetail, report *ProvisionReport) error {
+	actual := len(detail.Partitions)
+	if topic.PartitionCount > 0 && actual != topic.PartitionCount {
+		drift := TopicDrift{
+			Topic:    detail.Topic,
+			Setting:  "partitions",
+			Expected: strconv.Itoa(topic.PartitionCount),
+			Actual:   strconv.Itoa(actual),
+		}
+		if actual < topic.PartitionCount && report.DryRun {
+			drift.Fixed = true
+		} else if actual < topic.PartitionCount {
+			resps, err := a.client.UpdatePartitions(ctx, topic.PartitionCount, detail.Topic)
+			if err == nil {
+				err = resps[detail.Topic].Err
+			}
+			if err != nil {
+				return fmt.Errorf("failed to grow partitions of topic %s: %w", detail.Topic, err)
+			}
+			drift.Fixed = true
+		}
+		report.Drift = append(report.Drift, drift)
+	}
+
+	if topic.ReplicaCount > 0 && actual > 0 {
+		if replicas := len(detail.Partitions[0].Replicas); replicas != topic.ReplicaCount {
+			report.Drift = append(report.Drift, TopicDrift{
+				Topic:    detail.Topic,
+				Setting:  "replicas",
+				Expected: strconv.Itoa(topic.ReplicaCount),
+				Actual:   strconv.Itoa(replicas),
+			})
+		}
+	}
+	return nil
+}
+
+func (a *Admin) checkConfigs(ctx context.Context, configs map[string]*string, names []string, report *ProvisionReport) error {
+	described, err := a.client.DescribeTopicConfigs(ctx, names...)
+	if err != nil {
+		return fmt.Errorf("failed to describe topic configs: %w", err)
+	}
+
+	keys := make([]string, 0, len(configs))
+	for key := range configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, name := range names {
+		rc, err := described.On(name, nil)
+		if err == nil {
+			err = rc.Err
+		}
+		if err != nil {
+			return fmt.Errorf("failed to describe configs of topic %s: %w", name, err)
+		}
+		actual := make(map[string]string, len(rc.Configs))
+		for _, c := range rc.Configs {
+			actual[c.Key] = c.MaybeValue()
+		}
+
+		var alter []kadm.AlterConfig
+		var drifts []TopicDrift
+		for _, key := range keys {
+			if actual[key] == *configs[key] {
+				continue
+			}
+			alter = append(alter, kadm.AlterConfig{Op: kadm.SetConfig, Name: key, Value: configs[key]})
+			drifts = append(drifts, TopicDrift{Topic: name, Setting: key, Expected: *configs[key], Actual: actual[key], Fixed: true})
+		}
+		if len(alter) == 0 {
+			continue
+		}
+		if report.DryRun {
+			report.Drift = append(report.Drift, drifts...)
+			continue
+		}
+
+		resps, err := a.client.AlterTopicConfigs(ctx, alter, name)
+		if err == nil {
+			var resp kadm.AlterConfigsResponse
+			resp, err = resps.On(name, nil)
+			if err == nil {
+				err = resp.Err
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update configs of topic %s: %w", name, err)
+		}
+		report.Drift = append(report.Drift, drifts...)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+)
+
+func TestEnsureTopics(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1))
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	client, err := NewClient(context.Background(), &Config{BootstrapServers: cluster.ListenAddrs()})
+	require.NoError(t, err)
+	defer client.Close(context.Background())
+	kadmClient := kadm.NewClient(client.producer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// existing topics that drifted from the config
+	_, err = kadmClient.CreateTopics(ctx, 1, 1, map[string]*string{"retention.ms": kadm.StringPtr("1000")}, "grow")
+	require.NoError(t, err)
+	_, err = kadmClient.CreateTopics(ctx, 4, 1, nil, "shrink")
+	require.NoError(t, err)
+
+	topics := []Topic{
+		{Name: "new", PartitionCount: 2, ReplicaCount: 1, RetryDelays: []time.Duration{time.Minute}, DeadLetter: true, CleanupPolicy: "delete"},
+		{Name: "grow", PartitionCount: 3, ReplicaCount: 1, RetentionMs: 60000},
+		{Name: "shrink", PartitionCount: 2, ReplicaCount: 1},
+	}
+
+	expectedDrift := []TopicDrift{
+		{Topic: "grow", Setting: "partitions", Expected: "3", Actual: "1", Fixed: true},
+		{Topic: "grow", Setting: "retention.ms", Expected: "60000", Actual: "1000", Fixed: true},
+		{Topic: "shrink", Setting: "partitions", Expected: "2", Actual: "4", Fixed: false},
+	}
+
+	// a plan reports the same changes without making them
+	admin := client.NewAdmin()
+	report, err := admin.PlanTopics(ctx, topics...)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []string{"new", "new.retry.1m", "new.dlq"}, report.Created)
+	assert.Equal(t, expectedDrift, report.Drift)
+	assert.Contains(t, report.String(), "would create new.dlq")
+	details, err := kadmClient.ListTopics(ctx, "new", "grow")
+	require.NoError(t, err)
+	assert.ErrorIs(t, details["new"].Err, kerr.UnknownTopicOrPartition)
+	assert.Len(t, details["grow"].Partitions, 1)
+
+	report, err = admin.EnsureTopics(ctx, topics...)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"new", "new.retry.1m", "new.dlq"}, report.Created)
+	assert.Equal(t, expectedDrift, report.Drift)
+	assert.Equal(t, []TopicDrift{report.Drift[2]}, report.Unresolved())
+
+	details, err = kadmClient.ListTopics(ctx, "new.dlq", "grow")
+	require.NoError(t, err)
+	assert.Len(t, details["new.dlq"].Partitions, 2)
+	assert.Len(t, details["grow"].Partitions, 3)
+
+	// a second run only reports what could not be fixed
+	report, err = admin.EnsureTopics(ctx, topics...)
+	require.NoError(t, err)
+	assert.Empty(t, report.Created)
+	assert.Equal(t, report.Unresolved(), report.Drift)
+	assert.Len(t, report.Drift, 1)
+}
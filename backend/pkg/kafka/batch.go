@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	otelUtil "github.com/kartpop/cruncan/backend/pkg/otel"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"golang.org/x/exp/slog"
+)
+
+// BatchHandler handles the messages of a batch together, e.g. to write them in a single statement
+type BatchHandler interface {
+	// HandleBatch is called with the messages of a batch, in offset order. It returns the error of each
+	// message in the order of the messages, or nil if every message was handled.
+	HandleBatch(context.Context, []Message) []error
+}
+
+// BatchHandlerFunc adapts a function to a BatchHandler
+type BatchHandlerFunc func(context.Context, []Message) []error
+
+func (f BatchHandlerFunc) HandleBatch(ctx context.Context, messages []Message) []error {
+	return f(ctx, messages)
+}
+
+// Batching configures how a Consumer started with StartBatch gathers records into batches
+type Batching struct {
+	// MaxRecords bounds the number of records of a batch, defaults to 100
+	MaxRecords int `mapstructure:"MAX_RECORDS"`
+	// MaxWait bounds how long a batch waits for more records after its first record, defaults to 100ms
+	MaxWait time.Duration `mapstructure:"MAX_WAIT"`
+}
+
+// WithBatching configures the batches of a consumer started with StartBatch
+func WithBatching(batching Batching) ConsumerOption {
+	return func(c *Consumer) {
+		c.batching = batching
+	}
+}
+
+func (b Batching) withDefaults() Batching {
+	if b.MaxRecords <= 0 {
+		b.MaxRecords = 100
+	}
+	if b.MaxWait <= 0 {
+		b.MaxWait = 100 * time.Millisecond
+	}
+	return b
+}
+
+// StartBatch starts the kafka consumer like Start, but calls the handler with batches of records, see
+// WithBatching. A batch holds records of a single partition, which are handled in order, one batch per partition
+// at a time and at most MaxWorkers batches at the same time, see WithConcurrency. Each record is committed,
+// retried, forwarded to a retry tier or dropped according to its own error, like the records of Start, and a
+// retried batch only holds the records that failed. The middlewares of the consumer, see WithMiddleware, apply
+// to single messages and are not called for batches.
+func (c *Consumer) StartBatch(ctx context.Context, handler BatchHandler) {
+	pollCtx, stopPolling := context.WithCancel(ctx)
+	handleCtx, stopHandling := context.WithCancel(ctx)
+	batching := c.batching.withDefaults()
+	concurrency := c.concurrency
+	if concurrency.MaxBuffered <= 0 {
+		// room for a full batch on every worker
+		concurrency.MaxBuffered = batching.MaxRecords * max(concurrency.MaxWorkers, 1)
+	}
+	concurrency = concurrency.withDefaults()
+	workers := make(chan struct{}, concurrency.MaxWorkers)
+	tracker := newOffsetTracker(func(record *kgo.Record) {
+		c.mark(handleCtx, record)
+	})
+	lanes := newBatchLanes(pollCtx, LanePerPartition, batching.MaxRecords, batching.MaxWait, func(records []*kgo.Record) {
+		c.handleBatch(pollCtx, handleCtx, handler, records, workers, tracker)
+	})
+
+	c.poll(ctx, pollCtx, stopPolling, stopHandling, concurrency, tracker, lanes)
+}
+
+// handleBatch calls the handler for the records of a partition, see handle, and marks each record done once it
+// was handled, forwarded to a retry tier or dropped
+func (c *Consumer) handleBatch(pollCtx, ctx context.Context, handler BatchHandler, records []*kgo.Record, workers chan struct{}, tracker *offsetTracker) {
+	if pollCtx.Err() != nil {
+		return
+	}
+
+	origin, retry := c.origin(records[0].Topic)
+	tier := 0
+	if retry != nil {
+		tier = retry.tier(records[0].Topic)
+		// the records of a partition are in offset order, so the last record is due last
+		if !sleepUntil(pollCtx, retry.due(records[len(records)-1], tier)) {
+			return
+		}
+	}
+
+	pending := records
+	for attempt := 1; ; attempt++ {
+		// the records of a batch share their partition, they are left for the member it was assigned to
+		if !tracker.owned(pending[0]) {
+			return
+		}
+
+		errs, started := c.attemptBatch(pollCtx, ctx, handler, pending, origin, workers)
+		if !started {
+			return
+		}
+
+		var failed []*kgo.Record
+		var lastErr error
+		for i, record := range pending {
+			err := errs[i]
+			switch {
+			case err == nil:
+				tracker.done(record)
+			case ctx.Err() != nil:
+				// the handler was cancelled, by Stop or the context of Start, and the record is consumed again
+			case retry != nil:
+				if c.forward(pollCtx, ctx, retry, record, tier, err, tracker) {
+					tracker.done(record)
+				}
+			case IsNonRetriable(err):
+				slog.ErrorContext(ctx, fmt.Sprintf("dropping record from %s after failed handling: %v", record.Topic, err))
+				tracker.done(record)
+			default:
+				failed = append(failed, record)
+				lastErr = err
+			}
+		}
+		if len(failed) == 0 || ctx.Err() != nil {
+			return
+		}
+
+		backoff := RetryBackoff(attempt)
+		slog.WarnContext(ctx, fmt.Sprintf("retrying %d records from %s in %s after failed handling: %v", len(failed), failed[0].Topic, backoff, lastErr))
+		if !sleepUntil(pollCtx, time.Now().Add(backoff)) {
+			return
+		}
+		pending = failed
+	}
+}
+
+// attemptBatch calls the handler inside a consumer span that links the producers' traces once a worker is free,
+// and reports whether the handler was called before pollCtx was done. It returns an error for each record.
+func (c *Consumer) attemptBatch(pollCtx, ctx context.Context, handler BatchHandler, records []*kgo.Record, origin string, workers chan struct{}) ([]error, bool) {
+	select {
+	case workers <- struct{}{}:
+		defer func() { <-workers }()
+	case <-pollCtx.Done():
+		return nil, false
+	}
+
+	ctx, span := startBatchSpan(ctx, records, c.group)
+	defer span.End()
+
+	messages := make([]Message, len(records))
+	for i, record := range records {
+		messages[i] = messageFromRecord(record, origin)
+	}
+	errs := handler.HandleBatch(ctx, messages)
+	switch {
+	case errs == nil:
+		errs = make([]error, len(records))
+	case len(errs) != len(records):
+		// the records are retried rather than dropped, since it is unknown which of them were handled
+		err := fmt.Errorf("batch handler returned %d results for %d messages", len(errs), len(records))
+		errs = make([]error, len(records))
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	otelUtil.SetAutoSpanStatus(span, errors.Join(errs...))
+	return errs, true
+}
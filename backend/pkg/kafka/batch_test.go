@@ -0,0 +1,132 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumerStartBatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, admin := newTestCluster(t, 1, "topic-a")
+	defer client.Close(ctx)
+
+	producer := client.NewProducer("topic-a")
+	for _, value := range []string{"first", "flaky", "malformed", "fourth", "last"} {
+		require.NoError(t, producer.Send(ctx, Message{Value: []byte(value)}).FirstErr())
+	}
+
+	var mu sync.Mutex
+	var batches [][]string
+	attempts := map[string]int{}
+	done := make(chan struct{})
+	handler := BatchHandlerFunc(func(ctx context.Context, messages []Message) []error {
+		mu.Lock()
+		defer mu.Unlock()
+		var batch []string
+		errs := make([]error, len(messages))
+		for i, msg := range messages {
+			value := string(msg.Value)
+			batch = append(batch, value)
+			attempts[value]++
+			switch {
+			case value == "flaky" && attempts[value] < 2:
+				errs[i] = errors.New("unavailable")
+			case value == "malformed":
+				errs[i] = NonRetriable(errors.New("malformed"))
+			case value == "last":
+				close(done)
+			}
+		}
+		batches = append(batches, batch)
+		return errs
+	})
+
+	consumer, err := client.NewConsumer("topic-a", WithBatching(Batching{MaxRecords: 3, MaxWait: 50 * time.Millisecond}))
+	require.NoError(t, err)
+	consumer.StartBatch(ctx, handler)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("records not handled")
+	}
+	require.NoError(t, consumer.Stop(ctx))
+
+	// only the failed record is retried, the non-retriable one is dropped
+	assert.Equal(t, map[string]int{"first": 1, "flaky": 2, "malformed": 1, "fourth": 1, "last": 1}, attempts)
+	assert.Contains(t, batches, []string{"flaky"})
+	for _, batch := range batches {
+		assert.LessOrEqual(t, len(batch), 3)
+	}
+	offsets, err := admin.FetchOffsets(ctx, "group-a")
+	require.NoError(t, err)
+	committed, _ := offsets.Lookup("topic-a", 0)
+	assert.Equal(t, int64(5), committed.At)
+}
+
+func TestConsumerStartBatchResults(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   func(messages []Message) []error
+		attempts  int
+		committed int64
+	}{
+		{
+			name:      "nil results",
+			results:   func([]Message) []error { return nil },
+			attempts:  1,
+			committed: 2,
+		},
+		{
+			name:      "missing results are retried",
+			results:   func([]Message) []error { return []error{nil} },
+			attempts:  2,
+			committed: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			client, admin := newTestCluster(t, 1, "topic-a")
+			defer client.Close(ctx)
+
+			require.NoError(t, client.NewProducer("topic-a").Send(ctx,
+				Message{Value: []byte("first")},
+				Message{Value: []byte("second")},
+			).FirstErr())
+
+			calls := make(chan struct{}, 10)
+			handler := BatchHandlerFunc(func(ctx context.Context, messages []Message) []error {
+				calls <- struct{}{}
+				return tt.results(messages)
+			})
+			consumer, err := client.NewConsumer("topic-a", WithBatching(Batching{MaxRecords: 2}))
+			require.NoError(t, err)
+			consumer.StartBatch(ctx, handler)
+			for range tt.attempts {
+				select {
+				case <-calls:
+				case <-ctx.Done():
+					t.Fatal("batch not handled")
+				}
+			}
+			require.NoError(t, consumer.Stop(ctx))
+
+			offsets, err := admin.FetchOffsets(ctx, "group-a")
+			require.NoError(t, err)
+			committed, ok := offsets.Lookup("topic-a", 0)
+			if tt.committed == 0 {
+				assert.False(t, ok && committed.At > 0)
+				return
+			}
+			assert.Equal(t, tt.committed, committed.At)
+		})
+	}
+}
@@ -1,36 +1,154 @@
 package kafka
 
-import "github.com/twmb/franz-go/pkg/kgo"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Client creates the producers, consumers and admins of a kafka cluster. Producers and admins share a
+// producer-only client, each consumer gets its own consumer group client, so closing a consumer never
+// affects the producers and producing never joins the consumer group.
 type Client struct {
-	client *kgo.Client
+	producer     *kgo.Client
+	consumerOpts []kgo.Opt
+	group        string
+	metrics      *clientMetrics
+
+	mu        sync.Mutex
+	consumers []*Consumer
+	// closed is set by Close, a closed client is not ready
+	closed bool
+}
+
+// NewClient creates a new kafka client. All settings are validated here, so creating
+// producers and consumers later only fails if the cluster cannot be reached. The client and its
+// consumers record their metrics with the meter of the context.
+func NewClient(ctx context.Context, config *Config) (*Client, error) {
+	common, err := commonOpts(config)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := producerOpts(config)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := consumerOpts(config)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics, err := newClientMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	common = append(common, metrics.hooks())
+
+	producerClient, err := kgo.NewClient(concatOpts(common, producer)...)
+	if err != nil {
+		return nil, err
+	}
+	metrics.observeProducer(producerClient)
+
+	// consumers produce too when they forward records to retry tiers and dead letter topics
+	consumerOpts := concatOpts(common, producer, consumer)
+
+	return &Client{producer: producerClient, consumerOpts: consumerOpts, group: config.GroupId, metrics: metrics}, nil
 }
 
-// NewClient creates a new kafka client
-func NewClient(config *Config) (*Client, error) {
-	client, err := kgo.NewClient(
-		kgo.SeedBrokers(config.BootstrapServers...),
-		kgo.ConsumerGroup(config.GroupId),
-	)
+// commonOpts maps the connection settings of the config to the options of every client
+func commonOpts(config *Config) ([]kgo.Opt, error) {
+	opts := []kgo.Opt{kgo.SeedBrokers(config.BootstrapServers...)}
 
+	security, err := securityOpts(config)
 	if err != nil {
 		return nil, err
 	}
+	opts = append(opts, security...)
 
-	return &Client{client: client}, nil
+	logger, err := newSlogLogger(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	if logger != nil {
+		opts = append(opts, kgo.WithLogger(logger))
+	}
+
+	return opts, nil
 }
 
-// Close closes the kafka client
-func (c *Client) Close() {
-	c.client.Close()
+func concatOpts(opts ...[]kgo.Opt) []kgo.Opt {
+	var all []kgo.Opt
+	for _, o := range opts {
+		all = append(all, o...)
+	}
+	return all
 }
 
-// NewProducer creates a new kafka producer
+// Close shuts the clients down in order: the producers are flushed so that no sent message is lost,
+// then the consumers are stopped, see Consumer.Stop, and leave their group, and finally the producer
+// client is flushed again for messages sent by the draining handlers and closed. Consumers that were
+// stopped before are skipped.
+func (c *Client) Close(ctx context.Context) error {
+	var errs []error
+	if err := c.producer.Flush(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to flush kafka producer: %w", err))
+	}
+
+	c.mu.Lock()
+	consumers := c.consumers
+	c.consumers = nil
+	c.closed = true
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	stopErrs := make([]error, len(consumers))
+	for i, consumer := range consumers {
+		wg.Add(1)
+		go func(i int, consumer *Consumer) {
+			defer wg.Done()
+			stopErrs[i] = consumer.Stop(ctx)
+		}(i, consumer)
+	}
+	wg.Wait()
+	errs = append(errs, stopErrs...)
+
+	if err := c.producer.Flush(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to flush kafka producer: %w", err))
+	}
+	c.producer.Close()
+	return errors.Join(errs...)
+}
+
+// NewProducer creates a new kafka producer on the shared producer client
 func (c *Client) NewProducer(topic string) *Producer {
-	return NewProducer(c.client, topic)
+	return NewProducer(c.producer, topic)
+}
+
+// NewConsumer creates a new kafka consumer with its own consumer group client
+func (c *Client) NewConsumer(topic string, opts ...ConsumerOption) (*Consumer, error) {
+	return c.NewTopicsConsumer([]string{topic}, opts...)
+}
+
+// NewTopicsConsumer creates a new kafka consumer of several topics with its own consumer group client and a
+// single poll loop. Its handler receives the messages of all topics, see Router to handle each topic separately.
+func (c *Client) NewTopicsConsumer(topics []string, opts ...ConsumerOption) (*Consumer, error) {
+	consumer, err := newConsumer(c.consumerOpts, c.group, c.metrics, topics, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.consumers = append(c.consumers, consumer)
+	c.mu.Unlock()
+
+	return consumer, nil
 }
 
-// NewConsumer creates a new kafka consumer
-func (c *Client) NewConsumer(topic string) *Consumer {
-	return NewConsumer(c.client, topic)
+// NewAdmin creates a new kafka admin on the shared producer client
+func (c *Client) NewAdmin() *Admin {
+	return NewAdmin(c.producer)
 }
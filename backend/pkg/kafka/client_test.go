@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestClientLifecycle(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "topic-a"))
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	client, err := NewClient(context.Background(), &Config{BootstrapServers: cluster.ListenAddrs(), GroupId: "group-a", AutoOffsetReset: "earliest"})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	producer := client.NewProducer("topic-a")
+	consumer, err := client.NewConsumer("topic-a")
+	require.NoError(t, err)
+
+	// only the consumer joins the group
+	assert.Equal(t, "", client.producer.OptValue(kgo.ConsumerGroup))
+	assert.Equal(t, "group-a", consumer.client.OptValue(kgo.ConsumerGroup))
+
+	handler := &channelHandler{messages: make(chan Message, 1)}
+	consumer.Start(ctx, handler)
+
+	require.NoError(t, producer.Send(ctx, Message{Key: []byte("k"), Value: []byte("first")}).FirstErr())
+	select {
+	case msg := <-handler.messages:
+		assert.Equal(t, "first", string(msg.Value))
+	case <-ctx.Done():
+		t.Fatal("message not consumed")
+	}
+
+	// closing the consumer leaves the producer working
+	consumer.Close()
+	require.NoError(t, producer.Send(ctx, Message{Value: []byte("second")}).FirstErr())
+
+	// messages sent asynchronously are flushed on close
+	acked := make(chan error, 1)
+	producer.SendAsync(ctx, Message{Value: []byte("third")}, func(_ Message, err error) {
+		acked <- err
+	})
+	assert.NoError(t, client.Close(ctx))
+	select {
+	case err := <-acked:
+		assert.NoError(t, err)
+	default:
+		t.Fatal("message not flushed on close")
+	}
+
+	// a closed client is not ready, although it has no consumers left
+	assert.ErrorContains(t, client.Ready(), "closed")
+}
+
+type channelHandler struct {
+	messages chan Message
+}
+
+func (h *channelHandler) Handle(ctx context.Context, msg Message) error {
+	h.messages <- msg
+	return nil
+}
@@ -0,0 +1,44 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes values of T to message values and decodes them back
+type Codec[T any] interface {
+	Encode(T) ([]byte, error)
+	Decode([]byte) (T, error)
+}
+
+// JSONCodec encodes values as JSON, it is the default codec of typed consumers and producers
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var value T
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// ProtoCodec encodes protobuf messages in the protobuf wire format, T is the pointer type of the generated message
+type ProtoCodec[T proto.Message] struct{}
+
+func (ProtoCodec[T]) Encode(value T) ([]byte, error) {
+	return proto.Marshal(value)
+}
+
+func (ProtoCodec[T]) Decode(data []byte) (T, error) {
+	var zero T
+	value, ok := zero.ProtoReflect().New().Interface().(T)
+	if !ok {
+		return zero, fmt.Errorf("cannot create a protobuf message of type %T", zero)
+	}
+	err := proto.Unmarshal(data, value)
+	return value, err
+}
@@ -0,0 +1,195 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"golang.org/x/exp/slog"
+)
+
+// CommitMode selects when the offsets of a consumer group are committed
+type CommitMode string
+
+const (
+	// CommitMarked commits the offsets of handled records periodically, before partitions are revoked and
+	// on Stop, this is the default. Records are delivered at least once.
+	CommitMarked CommitMode = CommitMode("marked")
+	// CommitPerRecord commits the offset of each handled record before the next record of the lane is
+	// handled, which narrows redelivery after a crash to the records in flight at the cost of a commit per record
+	CommitPerRecord CommitMode = CommitMode("record")
+	// CommitAuto commits the offsets of polled records periodically whether they were handled or not,
+	// records that are in flight on a crash are not delivered again
+	CommitAuto CommitMode = CommitMode("auto")
+)
+
+// PartitionsHook is called with the partitions of each topic that were assigned to or revoked from the consumer,
+// it is not called when a rebalance did not change the consumer's partitions
+type PartitionsHook func(ctx context.Context, partitions map[string][]int32)
+
+// WithCommitMode selects when the consumer commits offsets, see CommitMode
+func WithCommitMode(mode CommitMode) ConsumerOption {
+	return func(c *Consumer) {
+		c.commitMode = mode
+	}
+}
+
+// OnPartitionsAssigned calls the hook after partitions were assigned to the consumer
+func OnPartitionsAssigned(hook PartitionsHook) ConsumerOption {
+	return func(c *Consumer) {
+		c.onAssigned = hook
+	}
+}
+
+// OnPartitionsRevoked calls the hook after partitions were revoked from the consumer or lost, once the
+// records of revoked partitions that were in flight were handled and committed
+func OnPartitionsRevoked(hook PartitionsHook) ConsumerOption {
+	return func(c *Consumer) {
+		c.onRevoked = hook
+	}
+}
+
+// groupOpts returns the client options that implement the consumer's commit mode and rebalance hooks
+func (c *Consumer) groupOpts() ([]kgo.Opt, error) {
+	opts := []kgo.Opt{kgo.WithHooks(groupHooks{c})}
+	if c.group == "" {
+		// without a group all partitions are consumed and never rebalanced
+		return opts, nil
+	}
+	opts = append(opts,
+		kgo.OnPartitionsAssigned(c.partitionsAssigned),
+		kgo.OnPartitionsRevoked(c.partitionsRevoked),
+		kgo.OnPartitionsLost(c.partitionsLost),
+	)
+
+	switch c.commitMode {
+	case "", CommitMarked, CommitPerRecord:
+		// only records that were marked once they were handled are committed
+		opts = append(opts, kgo.AutoCommitMarks())
+	case CommitAuto:
+	default:
+		return nil, fmt.Errorf("unknown commit mode %q", c.commitMode)
+	}
+	opts = append(opts, kgo.AutoCommitCallback(c.autoCommitted))
+	return opts, nil
+}
+
+// autoCommitted is called after each periodic commit of the client
+func (c *Consumer) autoCommitted(_ *kgo.Client, _ *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+	if err := commitErr(resp, err); err != nil {
+		ctx := context.Background()
+		slog.ErrorContext(ctx, fmt.Sprintf("failed to commit offsets of %s: %v", c.name(), err))
+		c.metrics.commitFailure(ctx, c.group)
+	}
+}
+
+// groupHooks marks the consumer unhealthy when its group session fails
+type groupHooks struct {
+	c *Consumer
+}
+
+func (h groupHooks) OnGroupManageError(err error) {
+	h.c.setHealth(HealthUnhealthy, err)
+}
+
+// mark is called with the highest record of a partition below which every record was handled
+func (c *Consumer) mark(ctx context.Context, record *kgo.Record) {
+	c.lag.handled(record)
+
+	switch c.commitMode {
+	case CommitAuto:
+	case CommitPerRecord:
+		c.client.MarkCommitRecords(record)
+		// a failed commit is retried by the next commit, the record stays marked
+		if err := c.client.CommitMarkedOffsets(ctx); err != nil && ctx.Err() == nil {
+			c.metrics.commitFailure(ctx, c.group)
+			slog.ErrorContext(ctx, fmt.Sprintf("failed to commit offset %d of %s/%d: %v", record.Offset, record.Topic, record.Partition, err))
+		}
+	default:
+		c.client.MarkCommitRecords(record)
+	}
+}
+
+// commit commits the offsets that are ready to be committed in the consumer's commit mode
+func (c *Consumer) commit(ctx context.Context) error {
+	if c.group == "" {
+		return nil
+	}
+	if c.commitMode == CommitAuto {
+		return c.client.CommitUncommittedOffsets(ctx)
+	}
+	return c.client.CommitMarkedOffsets(ctx)
+}
+
+func (c *Consumer) partitionsAssigned(ctx context.Context, _ *kgo.Client, assigned map[string][]int32) {
+	// the consumer joined its group, even if no partitions were assigned to it
+	c.setHealth(HealthHealthy, nil)
+	c.metrics.rebalanced(ctx, c.group, "assigned", assigned)
+
+	if c.onAssigned != nil && len(assigned) > 0 {
+		c.onAssigned(ctx, assigned)
+	}
+}
+
+// partitionsRevoked drops the records of the revoked partitions that were not started, waits for the ones in
+// flight and commits them before the partitions move to another member
+func (c *Consumer) partitionsRevoked(ctx context.Context, _ *kgo.Client, revoked map[string][]int32) {
+	c.mu.Lock()
+	tracker, lanes, closed := c.tracker, c.lanes, c.closed
+	c.mu.Unlock()
+
+	tps := topicPartitions(revoked)
+	if tracker != nil {
+		tracker.revoke(tps)
+		lanes.drop(tps)
+		// Close does not wait for in-flight records
+		if !closed {
+			lanes.waitIdle(ctx, tps)
+		}
+	}
+
+	if err := c.commit(ctx); err != nil && ctx.Err() == nil {
+		c.metrics.commitFailure(ctx, c.group)
+		slog.ErrorContext(ctx, fmt.Sprintf("failed to commit offsets of %s before the rebalance: %v", c.name(), err))
+	}
+	if tracker != nil {
+		tracker.forget(tps)
+	}
+	c.lag.forget(tps)
+	c.metrics.rebalanced(ctx, c.group, "revoked", revoked)
+
+	if c.onRevoked != nil && len(revoked) > 0 {
+		c.onRevoked(ctx, revoked)
+	}
+}
+
+// partitionsLost drops the records of partitions that already moved to another member without committing them
+func (c *Consumer) partitionsLost(ctx context.Context, _ *kgo.Client, lost map[string][]int32) {
+	c.mu.Lock()
+	tracker, lanes := c.tracker, c.lanes
+	c.mu.Unlock()
+
+	tps := topicPartitions(lost)
+	if tracker != nil {
+		tracker.revoke(tps)
+		lanes.drop(tps)
+		tracker.forget(tps)
+	}
+	c.lag.forget(tps)
+	c.metrics.rebalanced(ctx, c.group, "lost", lost)
+
+	if c.onRevoked != nil && len(lost) > 0 {
+		c.onRevoked(ctx, lost)
+	}
+}
+
+func topicPartitions(partitions map[string][]int32) map[topicPartition]bool {
+	tps := make(map[topicPartition]bool)
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			tps[topicPartition{topic, p}] = true
+		}
+	}
+	return tps
+}
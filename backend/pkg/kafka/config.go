@@ -1,19 +1,118 @@
 package kafka
 
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
 type Config struct {
-	BootstrapServers       []string `mapstructure:"BOOTSTRAP_SERVERS"`
-	SecurityProtocol       string   `mapstructure:"SECURITY_PROTOCOL"`
-	SslKeyLocation         string   `mapstructure:"SSL_KEY_LOCATION"`
-	SslCertificateLocation string   `mapstructure:"SSL_CERTIFICATE_LOCATION"`
-	GroupId                string   `mapstructure:"GROUP_ID"`
-	AutoOffsetReset        string   `mapstructure:"AUTO_OFFSET_RESET"`
-	LingerMs               string   `mapstructure:"LINGER_MS"`
-	BatchSize              string   `mapstructure:"BATCH_SIZE"`
-	LogLevel               string   `mapstructure:"LOG_LEVEL"`
+	BootstrapServers []string `mapstructure:"BOOTSTRAP_SERVERS"`
+	// SecurityProtocol is one of PLAINTEXT (default), SSL, SASL_PLAINTEXT or SASL_SSL
+	SecurityProtocol string `mapstructure:"SECURITY_PROTOCOL"`
+	// SslCaLocation is a PEM bundle of the CAs that verify the brokers, the system pool is used if empty
+	SslCaLocation string `mapstructure:"SSL_CA_LOCATION"`
+	// SslKeyLocation and SslCertificateLocation are the PEM client key and certificate for mTLS
+	SslKeyLocation         string `mapstructure:"SSL_KEY_LOCATION"`
+	SslCertificateLocation string `mapstructure:"SSL_CERTIFICATE_LOCATION"`
+	// SaslMechanism is one of PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+	SaslMechanism string `mapstructure:"SASL_MECHANISM"`
+	SaslUsername  string `mapstructure:"SASL_USERNAME"`
+	SaslPassword  string `mapstructure:"SASL_PASSWORD"`
+
+	GroupId string `mapstructure:"GROUP_ID"`
+	// AutoOffsetReset is where a group starts without committed offsets: earliest, latest (default) or none
+	AutoOffsetReset string `mapstructure:"AUTO_OFFSET_RESET"`
+	// LogLevel of the kafka client logs: debug, info, warn, error or none (default)
+	LogLevel string `mapstructure:"LOG_LEVEL"`
+
+	// Producer tuning, zero values keep the client defaults
+	//
+	// Acks is all (default), leader or none. Idempotent producing requires all and is disabled with
+	// the other acks unless EnableIdempotence is set explicitly.
+	Acks              string `mapstructure:"ACKS"`
+	EnableIdempotence *bool  `mapstructure:"ENABLE_IDEMPOTENCE"`
+	// CompressionCodec is none, gzip, snappy, lz4 or zstd
+	CompressionCodec string `mapstructure:"COMPRESSION_CODEC"`
+	LingerMs         int    `mapstructure:"LINGER_MS"`
+	// BatchSize is the maximum size of a record batch in bytes
+	BatchSize int `mapstructure:"BATCH_SIZE"`
+	// MaxInFlight is the maximum number of produce requests in flight per broker, the idempotent
+	// producer manages this itself so it must be disabled to set it
+	MaxInFlight int `mapstructure:"MAX_IN_FLIGHT"`
+
+	// Consumer tuning, zero values keep the client defaults
+	FetchMinBytes          int `mapstructure:"FETCH_MIN_BYTES"`
+	FetchMaxBytes          int `mapstructure:"FETCH_MAX_BYTES"`
+	FetchMaxPartitionBytes int `mapstructure:"FETCH_MAX_PARTITION_BYTES"`
+	FetchMaxWaitMs         int `mapstructure:"FETCH_MAX_WAIT_MS"`
+	SessionTimeoutMs       int `mapstructure:"SESSION_TIMEOUT_MS"`
+	RebalanceTimeoutMs     int `mapstructure:"REBALANCE_TIMEOUT_MS"`
+	// Balancers are the group balancers in order of preference: range, roundrobin, sticky or cooperative-sticky
+	Balancers []string `mapstructure:"BALANCERS"`
 }
 
 type Topic struct {
 	Name           string `mapstructure:"NAME"`
 	PartitionCount int    `mapstructure:"PARTITION_COUNT"`
 	ReplicaCount   int    `mapstructure:"REPLICA_COUNT"`
+	// RetryDelays configures one retry tier per delay, e.g. ["1m", "10m"] retries failed records on
+	// <name>.retry.1m and then on <name>.retry.10m
+	RetryDelays []time.Duration `mapstructure:"RETRY_DELAYS"`
+	// DeadLetter sends records that failed all retry tiers, or failed with a non-retriable error, to <name>.dlq
+	DeadLetter bool `mapstructure:"DEAD_LETTER"`
+	// RetentionMs and CleanupPolicy are the retention.ms and cleanup.policy topic configs,
+	// zero values keep the broker defaults
+	RetentionMs   int64  `mapstructure:"RETENTION_MS"`
+	CleanupPolicy string `mapstructure:"CLEANUP_POLICY"`
+}
+
+// RetryTopic returns the name of the retry tier topic for the delay
+func (t Topic) RetryTopic(delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", t.Name, formatDelay(delay))
+}
+
+// DeadLetterTopic returns the name of the dead letter topic
+func (t Topic) DeadLetterTopic() string {
+	return t.Name + ".dlq"
+}
+
+// TopicNames returns the name of the topic followed by the names of its retry tier and dead letter topics
+func (t Topic) TopicNames() []string {
+	names := []string{t.Name}
+	for _, delay := range t.RetryDelays {
+		names = append(names, t.RetryTopic(delay))
+	}
+	if t.DeadLetter {
+		names = append(names, t.DeadLetterTopic())
+	}
+	return names
+}
+
+// TopicConfigs returns the topic configs that are set on the topic, its retry tiers and dead letter topic
+func (t Topic) TopicConfigs() map[string]*string {
+	configs := make(map[string]*string)
+	if t.RetentionMs != 0 {
+		retention := strconv.FormatInt(t.RetentionMs, 10)
+		configs["retention.ms"] = &retention
+	}
+	if t.CleanupPolicy != "" {
+		policy := t.CleanupPolicy
+		configs["cleanup.policy"] = &policy
+	}
+	return configs
+}
+
+// formatDelay formats the delay in the largest whole unit, e.g. 1m instead of 1m0s
+func formatDelay(delay time.Duration) string {
+	switch {
+	case delay%time.Hour == 0:
+		return fmt.Sprintf("%dh", delay/time.Hour)
+	case delay%time.Minute == 0:
+		return fmt.Sprintf("%dm", delay/time.Minute)
+	case delay%time.Second == 0:
+		return fmt.Sprintf("%ds", delay/time.Second)
+	default:
+		return fmt.Sprintf("%dms", delay/time.Millisecond)
+	}
 }
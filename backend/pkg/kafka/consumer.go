@@ -4,62 +4,379 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	otelUtil "github.com/kartpop/cruncan/backend/pkg/otel"
 	"github.com/twmb/franz-go/pkg/kgo"
 	"golang.org/x/exp/slog"
 )
 
 type ConsumerHandler interface {
-	// Handle is called for each message received from the kafka topic. The handle accepts 
-	// the context, message and topic.
-	Handle(context.Context, []byte, string) error
+	// Handle is called for each message received from the kafka topic. The handle accepts
+	// the context and the message, with its key, headers, partition and offset.
+	Handle(context.Context, Message) error
 }
 
 type Consumer struct {
 	client *kgo.Client
-	topic  string
+	topics []string
+	group  string
+	// retries are the retry policies by topic, tiers the topics of the retry tiers by retry tier topic
+	retries     map[string]*retryPolicy
+	tiers       map[string]string
+	concurrency Concurrency
+	batching    Batching
+	commitMode  CommitMode
+	onAssigned  PartitionsHook
+	onRevoked   PartitionsHook
+	middlewares []Middleware
+	metrics     *clientMetrics
+	lag         *lagTracker
+
+	mu           sync.Mutex
+	tracker      *offsetTracker
+	lanes        *lanes
+	stopPolling  context.CancelFunc
+	stopHandling context.CancelFunc
+	done         chan struct{}
+	stopOnce     sync.Once
+	stopErr      error
+	closeOnce    sync.Once
+	closed       bool
+	health       HealthState
+	healthErr    error
+}
+
+// commitTimeout bounds the final commit on Stop, which is attempted even after the stop deadline passed
+// since every handled record that is not committed is handled again by the next member
+const commitTimeout = 5 * time.Second
+
+// ConsumerOption configures a Consumer
+type ConsumerOption func(*Consumer)
+
+// WithRetryTopics routes records that failed handling through the retry tiers and the dead letter topic
+// configured on the topic. The retry tier topics are consumed along with the topic, and their records are
+// passed to the handler as messages of the topic once their delay has passed. The option can be given once
+// for each topic of the consumer.
+func WithRetryTopics(topic Topic) ConsumerOption {
+	return func(c *Consumer) {
+		c.retries[topic.Name] = newRetryPolicy(topic)
+	}
 }
 
-// NewConsumer creates a new kafka consumer
-func NewConsumer(client *kgo.Client, topic string) *Consumer {
+// newConsumer creates a new kafka consumer of the topics with its own client, which joins the group unless it
+// is empty. The commit mode and rebalance hooks of the consumer are options of the client, so the client cannot
+// be shared with producers or other consumers. The consumer records its metrics until it is closed.
+func newConsumer(clientOpts []kgo.Opt, group string, metrics *clientMetrics, topics []string, opts ...ConsumerOption) (*Consumer, error) {
+	c := &Consumer{
+		topics:  topics,
+		group:   group,
+		retries: make(map[string]*retryPolicy),
+		tiers:   make(map[string]string),
+		metrics: metrics,
+		lag:     newLagTracker(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	groupOpts, err := c.groupOpts()
+	if err != nil {
+		return nil, err
+	}
+	if group != "" {
+		groupOpts = append(groupOpts, kgo.ConsumerGroup(group))
+	}
+	client, err := kgo.NewClient(concatOpts(clientOpts, groupOpts)...)
+	if err != nil {
+		return nil, err
+	}
+	c.client = client
+
 	// REVISIT: AddConsumeTopics has tradeoffs in terms of partitions
-	client.AddConsumeTopics(topic)
-	return &Consumer{client: client, topic: topic}
+	consumed := append([]string(nil), topics...)
+	for topic, policy := range c.retries {
+		if !slices.Contains(topics, topic) {
+			return nil, fmt.Errorf("retry topics configured for %s, which is not consumed", topic)
+		}
+		for _, tierTopic := range policy.retryTopics() {
+			c.tiers[tierTopic] = topic
+			consumed = append(consumed, tierTopic)
+		}
+	}
+	client.AddConsumeTopics(consumed...)
+	metrics.register(c)
+
+	return c, nil
 }
 
-// Start starts the kafka consumer and calls the handler for each message for the topic until the context is cancelled.
-// The poll loop is inside a goroutine, so it will not block the caller.
+// Start starts the kafka consumer and calls the handler for each message for the topic until the context is cancelled
+// or the consumer is stopped. The poll loop is inside a goroutine, so it will not block the caller. Records are handled
+// on ordered lanes, see WithConcurrency, and a record's offset is only committed once it and all records before it
+// were handled, see WithCommitMode. A record whose handler fails is retried, see WithRetryTopics, and is only
+// skipped once its handler fails with a NonRetriable error. The handler is wrapped with the middlewares of the
+// consumer, see WithMiddleware.
 func (c *Consumer) Start(ctx context.Context, handler ConsumerHandler) {
+	handler = Chain(handler, c.middlewares...)
+
+	// polling stops first on Stop, handling is only cancelled once the stop deadline passed
+	pollCtx, stopPolling := context.WithCancel(ctx)
+	handleCtx, stopHandling := context.WithCancel(ctx)
+	concurrency := c.concurrency.withDefaults()
+	workers := make(chan struct{}, concurrency.MaxWorkers)
+	tracker := newOffsetTracker(func(record *kgo.Record) {
+		c.mark(handleCtx, record)
+	})
+	lanes := newLanes(concurrency.Lanes, func(record *kgo.Record) {
+		if c.handle(pollCtx, handleCtx, handler, record, workers, tracker) {
+			tracker.done(record)
+		}
+	})
+
+	c.poll(ctx, pollCtx, stopPolling, stopHandling, concurrency, tracker, lanes)
+}
+
+// poll starts the poll loop, which dispatches the records to the lanes until pollCtx is done
+func (c *Consumer) poll(ctx, pollCtx context.Context, stopPolling, stopHandling context.CancelFunc, concurrency Concurrency, tracker *offsetTracker, lanes *lanes) {
+	done := make(chan struct{})
+
+	c.mu.Lock()
+	c.stopPolling, c.stopHandling, c.done = stopPolling, stopHandling, done
+	c.tracker, c.lanes = tracker, lanes
+	// without a group there is no group to join before polling
+	c.health = HealthStarting
+	if c.group == "" {
+		c.health = HealthHealthy
+	}
+	c.mu.Unlock()
+
 	go func() {
+		defer close(done)
+		// in-flight records are finished before the consumer counts as stopped
+		defer lanes.wait()
+
 		for {
+			free := lanes.waitForCapacity(pollCtx, concurrency.MaxBuffered)
+			if free == 0 {
+				return
+			}
+
 			// Poll
-			fetches := c.client.PollFetches(ctx)
+			fetches := c.client.PollRecords(pollCtx, free)
+			if fetches.IsClientClosed() || pollCtx.Err() != nil {
+				return
+			}
 			if errs := fetches.Errors(); len(errs) > 0 {
-				for _, err := range errs {
-					if errors.Is(err.Err, kgo.ErrClientClosed) {
-						return
-					}
-				}
 				// All errors are retried internally when fetching, but non-retriable errors are
 				// returned from polls so that users can notice and take action.
 				slog.ErrorContext(ctx, fmt.Sprint(errs))
+				c.setHealth(HealthUnhealthy, errors.New(fmt.Sprint(errs)))
+			} else {
+				c.setHealth(HealthHealthy, nil)
 			}
 
-			// Process the records
+			fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+				if p.Err == nil && c.consumes(p.Topic) {
+					c.lag.fetched(p.Topic, p.FetchPartition)
+				}
+			})
+
+			// Dispatch the records to their lanes
+			var dispatched []*kgo.Record
 			iter := fetches.RecordIter()
 			for !iter.Done() {
 				record := iter.Next()
-				if record.Topic == c.topic {
-					// ignore error here, handle it in the handler
-					// but keep err in signature for future use
-					_ = handler.Handle(ctx, record.Value, record.Topic)
+				if c.consumes(record.Topic) {
+					tracker.add(record)
+					lanes.dispatch(record)
+					dispatched = append(dispatched, record)
 				}
 			}
+			c.metrics.polled(ctx, c.group, dispatched)
+		}
+	}()
+}
+
+// Stop stops polling, lets the records that are being handled finish until the context is done, commits the
+// offsets of all handled records and leaves the consumer group. Records that were fetched but not started, or
+// whose handler did not finish in time and was cancelled, are not committed and are consumed again by the
+// member that takes over their partition. Stop can be used as a util.TerminatorFunc.
+func (c *Consumer) Stop(ctx context.Context) error {
+	c.stopOnce.Do(func() {
+		c.mu.Lock()
+		stopPolling, stopHandling, done := c.stopPolling, c.stopHandling, c.done
+		c.mu.Unlock()
 
-			// Commit the offsets
-			if err := c.client.CommitUncommittedOffsets(ctx); err != nil {
-				slog.ErrorContext(ctx, fmt.Sprintf("Failed to commit offsets: %v", err))
+		if done != nil {
+			stopPolling()
+			select {
+			case <-done:
+			case <-ctx.Done():
+				slog.WarnContext(ctx, fmt.Sprintf("cancelling in-flight records of %s after the stop deadline", c.name()))
+				stopHandling()
+				<-done
 			}
+			stopHandling()
 		}
-	}()
+
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+
+		commitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), commitTimeout)
+		defer cancel()
+		if err := c.commit(commitCtx); err != nil {
+			c.metrics.commitFailure(ctx, c.group)
+			c.stopErr = fmt.Errorf("failed to commit offsets of %s: %w", c.name(), err)
+		}
+		c.Close()
+	})
+	return c.stopErr
+}
+
+// Wait blocks until the consumer stopped polling and all its in-flight records were handled, either because
+// the context passed to Start was cancelled or because Stop was called. It returns immediately if the consumer
+// was not started.
+func (c *Consumer) Wait() {
+	c.mu.Lock()
+	done := c.done
+	c.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// Close leaves the consumer group and closes the consumer's client without waiting for in-flight records,
+// which are consumed again by the next member. Use Stop to drain the consumer first.
+func (c *Consumer) Close() {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.mu.Unlock()
+		c.client.Close()
+		c.metrics.unregister(c)
+	})
+}
+
+// consumes reports whether the topic is one of the consumer's topics or one of their retry tiers
+func (c *Consumer) consumes(topic string) bool {
+	return slices.Contains(c.topics, topic) || c.tiers[topic] != ""
+}
+
+// origin returns the consumer's topic that the records of the topic were first consumed from, and its retry policy
+func (c *Consumer) origin(topic string) (string, *retryPolicy) {
+	if origin, ok := c.tiers[topic]; ok {
+		return origin, c.retries[origin]
+	}
+	return topic, c.retries[topic]
+}
+
+// name names the consumer by its topics in logs and errors
+func (c *Consumer) name() string {
+	return strings.Join(c.topics, ",")
+}
+
+// handle calls the handler for the record, once one of the workers is free. Records of a retry tier are handled
+// once their delay has passed. A record that failed is forwarded to the next retry tier if the consumer has retry
+// topics, otherwise it is handled again after a backoff until it succeeds, fails with a non-retriable error or
+// its partition is revoked. Waiting stops with pollCtx while the handler runs with ctx. handle reports whether
+// the record is done with, either handled, forwarded or dropped, and can be committed.
+func (c *Consumer) handle(pollCtx, ctx context.Context, handler ConsumerHandler, record *kgo.Record, workers chan struct{}, tracker *offsetTracker) bool {
+	// once the consumer is stopping, records that did not start are left for the next member
+	if pollCtx.Err() != nil {
+		return false
+	}
+
+	origin, retry := c.origin(record.Topic)
+	tier := 0
+	if retry != nil {
+		tier = retry.tier(record.Topic)
+		// waiting only blocks the record's lane and does not take up a worker
+		if !sleepUntil(pollCtx, retry.due(record, tier)) {
+			return false
+		}
+	}
+	message := messageFromRecord(record, origin)
+
+	for attempt := 1; ; attempt++ {
+		// records of revoked partitions are left for the member they were assigned to
+		if !tracker.owned(record) {
+			return false
+		}
+
+		started, err := c.attempt(pollCtx, ctx, handler, record, message, workers)
+		switch {
+		case !started:
+			return false
+		case err == nil:
+			return true
+		case ctx.Err() != nil:
+			// the handler was cancelled, by Stop or the context of Start, and the record is consumed again
+			return false
+		case retry != nil:
+			return c.forward(pollCtx, ctx, retry, record, tier, err, tracker)
+		case IsNonRetriable(err):
+			slog.ErrorContext(ctx, fmt.Sprintf("dropping record from %s after failed handling: %v", record.Topic, err))
+			return true
+		}
+
+		backoff := RetryBackoff(attempt)
+		slog.WarnContext(ctx, fmt.Sprintf("retrying record from %s in %s after failed handling: %v", record.Topic, backoff, err))
+		if !sleepUntil(pollCtx, time.Now().Add(backoff)) {
+			return false
+		}
+	}
+}
+
+// attempt calls the handler inside a consumer span that continues the producer's trace once a worker is free,
+// and reports whether the handler was called before pollCtx was done
+func (c *Consumer) attempt(pollCtx, ctx context.Context, handler ConsumerHandler, record *kgo.Record, message Message, workers chan struct{}) (bool, error) {
+	select {
+	case workers <- struct{}{}:
+		defer func() { <-workers }()
+	case <-pollCtx.Done():
+		return false, nil
+	}
+
+	ctx, span := startConsumerSpan(ctx, record, c.group)
+	defer span.End()
+
+	err := handler.Handle(ctx, message)
+	otelUtil.SetAutoSpanStatus(span, err)
+	return true, err
+}
+
+// forward sends a record that failed handling to the next retry tier or the dead letter topic and reports
+// whether the record is done with. A record that cannot be produced is forwarded again after a backoff, since
+// the records after it in its partition are not committed until it is done with. Forwarding stops when pollCtx
+// or ctx is done or the partition is revoked, and the record is then consumed again.
+func (c *Consumer) forward(pollCtx, ctx context.Context, retry *retryPolicy, record *kgo.Record, tier int, err error, tracker *offsetTracker) bool {
+	next := retry.next(tier, err)
+	if next == "" {
+		slog.ErrorContext(ctx, fmt.Sprintf("dropping record from %s after failed handling: %v", record.Topic, err))
+		return true
+	}
+
+	for attempt := 1; ; attempt++ {
+		fwd := forwardRecord(record, next, err)
+		produceCtx, span := startProducerSpan(ctx, fwd)
+		r, produceErr := c.client.ProduceSync(produceCtx, fwd).First()
+		endProducerSpan(span, r, produceErr)
+		if produceErr == nil {
+			return true
+		}
+
+		backoff := RetryBackoff(attempt)
+		slog.ErrorContext(ctx, fmt.Sprintf("failed to forward record from %s to %s, retrying in %s: %v", record.Topic, next, backoff, produceErr))
+		if !sleepUntil(pollCtx, time.Now().Add(backoff)) || ctx.Err() != nil || !tracker.owned(record) {
+			return false
+		}
+	}
 }
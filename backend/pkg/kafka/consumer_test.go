@@ -0,0 +1,369 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func TestConsumerStop(t *testing.T) {
+	tests := []struct {
+		name            string
+		stopTimeout     time.Duration
+		release         bool
+		expectedHandled []string
+		expectedCommit  int64
+	}{
+		{
+			name:            "in-flight record finishes and is committed",
+			stopTimeout:     5 * time.Second,
+			release:         true,
+			expectedHandled: []string{"0"},
+			expectedCommit:  1,
+		},
+		{
+			name:            "in-flight record is cancelled after the deadline and not committed",
+			stopTimeout:     100 * time.Millisecond,
+			expectedHandled: nil,
+			expectedCommit:  -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			client, admin := newTestCluster(t, 1, "topic-a")
+			defer client.Close(ctx)
+
+			producer := client.NewProducer("topic-a")
+			for i := 0; i < 3; i++ {
+				require.NoError(t, producer.Send(ctx, Message{Value: []byte(fmt.Sprint(i))}).FirstErr())
+			}
+
+			handler := &blockingHandler{started: make(chan struct{}, 3), release: make(chan struct{})}
+			consumer, err := client.NewConsumer("topic-a")
+			require.NoError(t, err)
+			consumer.Start(ctx, handler)
+
+			// the first record is in flight, the others wait on its lane
+			<-handler.started
+			if tt.release {
+				time.AfterFunc(50*time.Millisecond, func() { close(handler.release) })
+			}
+			stopCtx, stopCancel := context.WithTimeout(ctx, tt.stopTimeout)
+			defer stopCancel()
+			assert.NoError(t, consumer.Stop(stopCtx))
+			consumer.Wait()
+
+			assert.Equal(t, tt.expectedHandled, handler.handled)
+			offsets, err := admin.FetchOffsets(ctx, "group-a")
+			require.NoError(t, err)
+			committed, ok := offsets.Lookup("topic-a", 0)
+			if tt.expectedCommit < 0 {
+				assert.False(t, ok)
+			} else {
+				assert.Equal(t, tt.expectedCommit, committed.At)
+			}
+		})
+	}
+}
+
+func TestConsumerWait(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, _ := newTestCluster(t, 1, "topic-a")
+	defer client.Close(ctx)
+
+	consumer, err := client.NewConsumer("topic-a")
+	require.NoError(t, err)
+	// not started
+	consumer.Wait()
+
+	startCtx, stop := context.WithCancel(ctx)
+	consumer.Start(startCtx, &blockingHandler{started: make(chan struct{}, 1), release: make(chan struct{})})
+	stop()
+
+	waited := make(chan struct{})
+	go func() {
+		consumer.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-ctx.Done():
+		t.Fatal("Wait did not return after the context was cancelled")
+	}
+}
+
+func TestConsumerRetriesInPlace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, admin := newTestCluster(t, 1, "topic-a")
+	defer client.Close(ctx)
+
+	producer := client.NewProducer("topic-a")
+	for _, value := range []string{"flaky", "malformed", "ok"} {
+		require.NoError(t, producer.Send(ctx, Message{Value: []byte(value)}).FirstErr())
+	}
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	done := make(chan struct{})
+	handler := ConsumerHandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		value := string(msg.Value)
+		attempts[value]++
+		switch {
+		case value == "flaky" && attempts[value] < 3:
+			return errors.New("unavailable")
+		case value == "malformed":
+			return NonRetriable(errors.New("malformed"))
+		case value == "ok":
+			close(done)
+		}
+		return nil
+	})
+
+	consumer, err := client.NewConsumer("topic-a")
+	require.NoError(t, err)
+	consumer.Start(ctx, handler)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("records not handled")
+	}
+	require.NoError(t, consumer.Stop(ctx))
+
+	// failed records are not committed until they succeed or are dropped as non-retriable
+	assert.Equal(t, map[string]int{"flaky": 3, "malformed": 1, "ok": 1}, attempts)
+	offsets, err := admin.FetchOffsets(ctx, "group-a")
+	require.NoError(t, err)
+	committed, _ := offsets.Lookup("topic-a", 0)
+	assert.Equal(t, int64(3), committed.At)
+}
+
+func TestConsumerCommitModes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, admin := newTestCluster(t, 1, "topic-a")
+	defer client.Close(ctx)
+
+	_, err := client.NewConsumer("topic-a", WithCommitMode("sometimes"))
+	assert.Error(t, err)
+
+	auto, err := client.NewConsumer("topic-a", WithCommitMode(CommitAuto))
+	require.NoError(t, err)
+	assert.Equal(t, false, auto.client.OptValue(kgo.AutoCommitMarks))
+	auto.Close()
+
+	consumer, err := client.NewConsumer("topic-a", WithCommitMode(CommitPerRecord))
+	require.NoError(t, err)
+	assert.Equal(t, true, consumer.client.OptValue(kgo.AutoCommitMarks))
+
+	handler := &channelHandler{messages: make(chan Message, 1)}
+	consumer.Start(ctx, handler)
+	require.NoError(t, client.NewProducer("topic-a").Send(ctx, Message{Value: []byte("first")}).FirstErr())
+	<-handler.messages
+
+	// the offset is committed right after the record was handled, without waiting for Stop
+	assert.Eventually(t, func() bool {
+		offsets, err := admin.FetchOffsets(ctx, "group-a")
+		committed, _ := offsets.Lookup("topic-a", 0)
+		return err == nil && committed.At == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConsumerRebalanceHooks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	client, _ := newTestCluster(t, 2, "topic-a")
+	defer client.Close(ctx)
+
+	newConsumer := func(assigned, revoked chan map[string][]int32) *Consumer {
+		consumer, err := client.NewConsumer("topic-a",
+			OnPartitionsAssigned(func(_ context.Context, partitions map[string][]int32) { assigned <- partitions }),
+			OnPartitionsRevoked(func(_ context.Context, partitions map[string][]int32) { revoked <- partitions }),
+		)
+		require.NoError(t, err)
+		consumer.Start(ctx, &channelHandler{messages: make(chan Message, 10)})
+		return consumer
+	}
+	receive := func(ch chan map[string][]int32) map[string][]int32 {
+		select {
+		case partitions := <-ch:
+			return partitions
+		case <-ctx.Done():
+			t.Fatal("hook not called")
+			return nil
+		}
+	}
+
+	assignedA, revokedA := make(chan map[string][]int32, 10), make(chan map[string][]int32, 10)
+	newConsumer(assignedA, revokedA)
+	assert.ElementsMatch(t, []int32{0, 1}, receive(assignedA)["topic-a"])
+
+	// a second member takes over one partition, which the first member hands over first
+	assignedB, revokedB := make(chan map[string][]int32, 10), make(chan map[string][]int32, 10)
+	b := newConsumer(assignedB, revokedB)
+	revoked := receive(revokedA)["topic-a"]
+	assert.Len(t, revoked, 1)
+	taken := receive(assignedB)["topic-a"]
+	assert.Equal(t, revoked, taken)
+
+	// leaving the group revokes the partitions of the member
+	require.NoError(t, b.Stop(ctx))
+	assert.Equal(t, taken, receive(revokedB)["topic-a"])
+}
+
+func newTestCluster(t *testing.T, partitions int32, topics ...string) (*Client, *kadm.Client) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(partitions, topics...))
+	require.NoError(t, err)
+	t.Cleanup(cluster.Close)
+
+	client, err := NewClient(context.Background(), &Config{BootstrapServers: cluster.ListenAddrs(), GroupId: "group-a", AutoOffsetReset: "earliest"})
+	require.NoError(t, err)
+	return client, kadm.NewClient(client.producer)
+}
+
+// blockingHandler handles a message once it is released or fails when its context is cancelled
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+	handled []string
+}
+
+func (h *blockingHandler) Handle(ctx context.Context, msg Message) error {
+	h.started <- struct{}{}
+	select {
+	case <-h.release:
+		h.handled = append(h.handled, string(msg.Value))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestConsumerForwardRetriesFailedProduce(t *testing.T) {
+	tests := []struct {
+		name  string
+		start func(*Consumer, context.Context, func(Message) error)
+	}{
+		{
+			name: "Start",
+			start: func(c *Consumer, ctx context.Context, handle func(Message) error) {
+				c.Start(ctx, ConsumerHandlerFunc(func(_ context.Context, msg Message) error { return handle(msg) }))
+			},
+		},
+		{
+			name: "StartBatch",
+			start: func(c *Consumer, ctx context.Context, handle func(Message) error) {
+				c.StartBatch(ctx, BatchHandlerFunc(func(_ context.Context, messages []Message) []error {
+					errs := make([]error, len(messages))
+					for i, msg := range messages {
+						errs[i] = handle(msg)
+					}
+					return errs
+				}))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			topic := Topic{Name: "topic-a", DeadLetter: true}
+			cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, topic.Name, topic.DeadLetterTopic()))
+			require.NoError(t, err)
+			defer cluster.Close()
+			client, err := NewClient(ctx, &Config{BootstrapServers: cluster.ListenAddrs(), GroupId: "group-a", AutoOffsetReset: "earliest"})
+			require.NoError(t, err)
+			defer client.Close(ctx)
+			admin := kadm.NewClient(client.producer)
+
+			producer := client.NewProducer(topic.Name)
+			for _, value := range []string{"bad", "ok"} {
+				require.NoError(t, producer.Send(ctx, Message{Value: []byte(value)}).FirstErr())
+			}
+
+			// the first two produce requests to the dead letter topic fail
+			var failedProduces atomic.Int32
+			cluster.ControlKey(int16(kmsg.Produce), func(req kmsg.Request) (kmsg.Response, error, bool) {
+				cluster.KeepControl()
+				produce := req.(*kmsg.ProduceRequest)
+				if len(produce.Topics) == 0 || produce.Topics[0].Topic != topic.DeadLetterTopic() || failedProduces.Load() >= 2 {
+					return nil, nil, false
+				}
+				failedProduces.Add(1)
+				res := produce.ResponseKind().(*kmsg.ProduceResponse)
+				for _, reqTopic := range produce.Topics {
+					resTopic := kmsg.NewProduceResponseTopic()
+					resTopic.Topic = reqTopic.Topic
+					for _, reqPartition := range reqTopic.Partitions {
+						resPartition := kmsg.NewProduceResponseTopicPartition()
+						resPartition.Partition = reqPartition.Partition
+						resPartition.ErrorCode = kerr.InvalidRecord.Code
+						resTopic.Partitions = append(resTopic.Partitions, resPartition)
+					}
+					res.Topics = append(res.Topics, resTopic)
+				}
+				return res, nil, true
+			})
+
+			var mu sync.Mutex
+			attempts := map[string]int{}
+			done := make(chan struct{})
+			consumer, err := client.NewConsumer(topic.Name, WithRetryTopics(topic))
+			require.NoError(t, err)
+			tt.start(consumer, ctx, func(msg Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				value := string(msg.Value)
+				attempts[value]++
+				switch value {
+				case "bad":
+					return errors.New("unavailable")
+				case "ok":
+					close(done)
+				}
+				return nil
+			})
+			select {
+			case <-done:
+			case <-ctx.Done():
+				t.Fatal("records not handled")
+			}
+
+			// the failed record reaches the dead letter topic once producing succeeds
+			require.Eventually(t, func() bool {
+				ends, err := admin.ListEndOffsets(ctx, topic.DeadLetterTopic())
+				require.NoError(t, err)
+				end, _ := ends.Lookup(topic.DeadLetterTopic(), 0)
+				return end.Offset == 1
+			}, 5*time.Second, 10*time.Millisecond)
+			require.NoError(t, consumer.Stop(ctx))
+
+			// and both records are committed
+			assert.EqualValues(t, 2, failedProduces.Load())
+			assert.Equal(t, map[string]int{"bad": 1, "ok": 1}, attempts)
+			offsets, err := admin.FetchOffsets(ctx, "group-a")
+			require.NoError(t, err)
+			committed, _ := offsets.Lookup(topic.Name, 0)
+			assert.Equal(t, int64(2), committed.At)
+		})
+	}
+}
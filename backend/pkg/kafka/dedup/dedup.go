@@ -0,0 +1,192 @@
+// Package dedup skips kafka messages that were already handled, so handlers with side effects can be run on
+// an at-least-once consumer. Messages are identified by their kafka.HeaderMessageID header, and their
+// processing state is kept in a Store that all replicas of the consumer share.
+package dedup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// DuplicateMeterName is the name of the counter of duplicate messages that were skipped or postponed
+const DuplicateMeterName = "kafka.consumer.messages.duplicate"
+
+// ErrInProgress is returned for a message that is being handled by another consumer, e.g. the one that owned
+// its partition before a rebalance. The message is retried and skipped once the other consumer is done.
+var ErrInProgress = errors.New("message is being handled by another consumer")
+
+// Status is the processing state of a message in a Store
+type Status string
+
+const (
+	// StatusClaimed is returned by Store.Claim when the caller may handle the message
+	StatusClaimed Status = Status("claimed")
+	// StatusInProgress is the state of a message that another caller claimed and whose lease did not expire
+	StatusInProgress Status = Status("in_progress")
+	// StatusDone is the state of a message that was handled
+	StatusDone Status = Status("done")
+)
+
+// Store keeps the processing state of messages by their ID. Claims are identified by a token, so that a
+// caller whose lease expired cannot complete or release the claim of the caller that took the message over.
+type Store interface {
+	// Claim marks the message in progress for the lease and returns StatusClaimed, unless it is done or
+	// claimed by a lease that did not expire, then it returns the state of the message
+	Claim(ctx context.Context, id, token string, lease time.Duration) (Status, error)
+	// Done marks the claimed message done, it is kept for the retention of the store
+	Done(ctx context.Context, id, token string) error
+	// Release removes the claim so that the message can be claimed again
+	Release(ctx context.Context, id, token string) error
+}
+
+// Config configures deduplication, see Middleware
+type Config struct {
+	// Store is memory or postgres, deduplication is disabled if empty
+	Store string `mapstructure:"STORE"`
+	// Lease bounds how long a message stays in progress when its handler never finishes, e.g. because the
+	// consumer crashed. It must be longer than the handler may take, defaults to 5m.
+	Lease time.Duration `mapstructure:"LEASE"`
+	// Retention is how long handled messages are remembered, defaults to 24h
+	Retention time.Duration `mapstructure:"RETENTION"`
+	// PurgeInterval is how often the postgres store deletes the messages whose retention or lease expired,
+	// defaults to 1h
+	PurgeInterval time.Duration `mapstructure:"PURGE_INTERVAL"`
+}
+
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+
+	defaultLease         = 5 * time.Minute
+	defaultRetention     = 24 * time.Hour
+	defaultPurgeInterval = time.Hour
+)
+
+func (c Config) withDefaults() Config {
+	if c.Lease <= 0 {
+		c.Lease = defaultLease
+	}
+	if c.Retention <= 0 {
+		c.Retention = defaultRetention
+	}
+	return c
+}
+
+// IDFunc returns the ID of a message, messages without an ID are not deduplicated
+type IDFunc func(kafkaUtil.Message) string
+
+// MessageID returns the kafka.HeaderMessageID header of the message. Keys are not IDs, many messages share the
+// key of their aggregate, so messages without the header are not deduplicated unless WithID identifies them.
+func MessageID(message kafkaUtil.Message) string {
+	id, _ := message.Header(kafkaUtil.HeaderMessageID)
+	return string(id)
+}
+
+// Option configures the Middleware
+type Option func(*options)
+
+type options struct {
+	id IDFunc
+}
+
+// WithID identifies messages with the function instead of MessageID
+func WithID(id IDFunc) Option {
+	return func(o *options) {
+		o.id = id
+	}
+}
+
+// Middleware handles each message at most once across the consumers that share the store. A message is
+// claimed before it is handled and marked done once its handler succeeded. If the handler fails the claim is
+// released, so the message is handled again when it is retried. A message that is done is skipped, and a
+// message that is in progress elsewhere fails with ErrInProgress. A handler that panics releases the claim
+// before the panic is passed on, place it inside Recover to turn the panic into an error. Place it inside
+// Timeout too, so a message that times out is released.
+func Middleware(ctx context.Context, store Store, config Config, opts ...Option) (kafkaUtil.Middleware, error) {
+	config = config.withDefaults()
+	o := options{id: MessageID}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	meter, _ := otelContext.Meter(ctx)
+	duplicates, err := meter.Int64Counter(DuplicateMeterName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create counter %q: %w", DuplicateMeterName, err)
+	}
+
+	return func(next kafkaUtil.ConsumerHandler) kafkaUtil.ConsumerHandler {
+		return kafkaUtil.ConsumerHandlerFunc(func(ctx context.Context, message kafkaUtil.Message) error {
+			id := o.id(message)
+			if id == "" {
+				return next.Handle(ctx, message)
+			}
+
+			token, err := newToken()
+			if err != nil {
+				return err
+			}
+			status, err := store.Claim(ctx, id, token, config.Lease)
+			if err != nil {
+				return fmt.Errorf("failed to claim message %s: %w", id, err)
+			}
+			if status != StatusClaimed {
+				duplicates.Add(ctx, 1, metric.WithAttributes(
+					semconv.MessagingDestinationName(message.Topic),
+					attribute.String("status", string(status)),
+				))
+			}
+			switch status {
+			case StatusDone:
+				slog.InfoContext(ctx, fmt.Sprintf("skipping message %s from %s, it was handled before", id, message.Topic))
+				return nil
+			case StatusInProgress:
+				return fmt.Errorf("%w: %s", ErrInProgress, id)
+			}
+
+			// the state is stored even if the handler's context was cancelled
+			storeCtx := context.WithoutCancel(ctx)
+			release := func() {
+				if err := store.Release(storeCtx, id, token); err != nil {
+					slog.ErrorContext(ctx, fmt.Sprintf("failed to release message %s, it is retried once its lease expired: %v", id, err))
+				}
+			}
+			defer func() {
+				if r := recover(); r != nil {
+					release()
+					panic(r)
+				}
+			}()
+
+			handleErr := next.Handle(ctx, message)
+			if handleErr != nil {
+				release()
+				return handleErr
+			}
+			if err := store.Done(storeCtx, id, token); err != nil {
+				// the message is handled again if it is redelivered after its lease expired
+				slog.ErrorContext(ctx, fmt.Sprintf("failed to mark message %s done: %v", id, err))
+			}
+			return nil
+		})
+	}, nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create claim token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,185 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMessageID(t *testing.T) {
+	tests := []struct {
+		name    string
+		message kafkaUtil.Message
+		id      string
+	}{
+		{
+			name:    "header",
+			message: kafkaUtil.Message{Key: []byte("key"), Headers: []kafkaUtil.Header{{Key: kafkaUtil.HeaderMessageID, Value: []byte("id")}}},
+			id:      "id",
+		},
+		{
+			name:    "key only",
+			message: kafkaUtil.Message{Key: []byte("key")},
+			id:      "",
+		},
+		{
+			name:    "none",
+			message: kafkaUtil.Message{},
+			id:      "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.id, MessageID(tt.message))
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	ctx := otelContext.WithMeter(context.Background(), mp.Meter("test"))
+
+	store := NewMemoryStore(time.Hour)
+	dedup, err := Middleware(ctx, store, Config{})
+	require.NoError(t, err)
+
+	calls := 0
+	var handlerErr error
+	handler := dedup(kafkaUtil.ConsumerHandlerFunc(func(context.Context, kafkaUtil.Message) error {
+		calls++
+		return handlerErr
+	}))
+	message := withID("request-1")
+
+	// a failed message is released and handled again
+	handlerErr = errors.New("failed")
+	assert.ErrorIs(t, handler.Handle(ctx, message), handlerErr)
+	handlerErr = nil
+	assert.NoError(t, handler.Handle(ctx, message))
+	assert.Equal(t, 2, calls)
+
+	// a redelivered message is skipped
+	assert.NoError(t, handler.Handle(ctx, message))
+	assert.Equal(t, 2, calls)
+
+	// a message in progress elsewhere is retried
+	status, err := store.Claim(ctx, "request-2", "other", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, StatusClaimed, status)
+	assert.ErrorIs(t, handler.Handle(ctx, withID("request-2")), ErrInProgress)
+	assert.Equal(t, 2, calls)
+
+	// messages without an ID are not deduplicated, even if they have a key
+	assert.NoError(t, handler.Handle(ctx, kafkaUtil.Message{Topic: "topic-a", Key: []byte("request-3")}))
+	assert.NoError(t, handler.Handle(ctx, kafkaUtil.Message{Topic: "topic-a", Key: []byte("request-3")}))
+	assert.Equal(t, 4, calls)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &rm))
+	duplicates := map[string]int64{}
+	for _, dp := range rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Sum[int64]).DataPoints {
+		status, _ := dp.Attributes.Value("status")
+		duplicates[status.AsString()] = dp.Value
+	}
+	assert.Equal(t, map[string]int64{"done": 1, "in_progress": 1}, duplicates)
+}
+
+func TestMiddlewareWithID(t *testing.T) {
+	ctx := context.Background()
+	byKey := func(message kafkaUtil.Message) string { return string(message.Key) }
+	dedup, err := Middleware(ctx, NewMemoryStore(time.Hour), Config{}, WithID(byKey))
+	require.NoError(t, err)
+
+	calls := 0
+	handler := dedup(kafkaUtil.ConsumerHandlerFunc(func(context.Context, kafkaUtil.Message) error {
+		calls++
+		return nil
+	}))
+	message := kafkaUtil.Message{Topic: "topic-a", Key: []byte("request-1")}
+
+	assert.NoError(t, handler.Handle(ctx, message))
+	assert.NoError(t, handler.Handle(ctx, message))
+	assert.Equal(t, 1, calls)
+}
+
+// withID returns a message identified by the kafka.HeaderMessageID header
+func withID(id string) kafkaUtil.Message {
+	return kafkaUtil.Message{Topic: "topic-a", Headers: []kafkaUtil.Header{{Key: kafkaUtil.HeaderMessageID, Value: []byte(id)}}}
+}
+
+func TestMiddlewareReleasesOnPanic(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(time.Hour)
+	dedup, err := Middleware(ctx, store, Config{})
+	require.NoError(t, err)
+
+	calls := 0
+	panics := true
+	handler := dedup(kafkaUtil.ConsumerHandlerFunc(func(context.Context, kafkaUtil.Message) error {
+		calls++
+		if panics {
+			panic("boom")
+		}
+		return nil
+	}))
+	message := withID("request-1")
+
+	assert.PanicsWithValue(t, "boom", func() { _ = handler.Handle(ctx, message) })
+
+	// the claim was released, so the retry handles the message instead of failing with ErrInProgress
+	panics = false
+	assert.NoError(t, handler.Handle(ctx, message))
+	assert.Equal(t, 2, calls)
+
+	// behind Recover the panic is an error
+	panics = true
+	recovered := kafkaUtil.Recover()(handler)
+	message = withID("request-2")
+	assert.True(t, kafkaUtil.IsNonRetriable(recovered.Handle(ctx, message)))
+	status, err := store.Claim(ctx, "request-2", "other", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, StatusClaimed, status)
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore(time.Hour)
+	store.now = func() time.Time { return now }
+
+	claim := func(token string) Status {
+		status, err := store.Claim(ctx, "id", token, time.Minute)
+		require.NoError(t, err)
+		return status
+	}
+
+	assert.Equal(t, StatusClaimed, claim("a"))
+	assert.Equal(t, StatusInProgress, claim("b"))
+
+	// only the owner of the claim can complete or release it
+	require.NoError(t, store.Release(ctx, "id", "b"))
+	assert.Equal(t, StatusInProgress, claim("b"))
+
+	// an expired lease is taken over, the previous owner cannot complete it anymore
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, StatusClaimed, claim("b"))
+	require.NoError(t, store.Done(ctx, "id", "a"))
+	assert.Equal(t, StatusInProgress, claim("c"))
+
+	require.NoError(t, store.Done(ctx, "id", "b"))
+	assert.Equal(t, StatusDone, claim("c"))
+
+	// handled messages are forgotten after the retention
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, StatusClaimed, claim("c"))
+	assert.Len(t, store.entries, 1)
+}
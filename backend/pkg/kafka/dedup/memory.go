@@ -0,0 +1,77 @@
+package dedup
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the processing state in memory. It only deduplicates the messages of a single replica,
+// e.g. redeliveries after a retry, and forgets them on restart.
+type MemoryStore struct {
+	retention time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastPurge time.Time
+}
+
+// purgeInterval bounds how often expired entries are removed
+const purgeInterval = time.Minute
+
+type memoryEntry struct {
+	status  Status
+	token   string
+	expires time.Time
+}
+
+// NewMemoryStore creates a store that remembers handled messages for the retention
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{retention: retention, now: time.Now, entries: make(map[string]memoryEntry)}
+}
+
+func (s *MemoryStore) Claim(_ context.Context, id, token string, lease time.Duration) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastPurge) >= purgeInterval {
+		s.purge(now)
+		s.lastPurge = now
+	}
+	if entry, ok := s.entries[id]; ok && now.Before(entry.expires) {
+		return entry.status, nil
+	}
+	s.entries[id] = memoryEntry{status: StatusInProgress, token: token, expires: now.Add(lease)}
+	return StatusClaimed, nil
+}
+
+func (s *MemoryStore) Done(_ context.Context, id, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[id]; ok && entry.token == token {
+		s.entries[id] = memoryEntry{status: StatusDone, token: token, expires: s.now().Add(s.retention)}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, id, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[id]; ok && entry.token == token && entry.status == StatusInProgress {
+		delete(s.entries, id)
+	}
+	return nil
+}
+
+// purge removes expired entries
+func (s *MemoryStore) purge(now time.Time) {
+	for id, entry := range s.entries {
+		if !now.Before(entry.expires) {
+			delete(s.entries, id)
+		}
+	}
+}
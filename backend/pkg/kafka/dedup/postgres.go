@@ -0,0 +1,115 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PostgresStore keeps the processing state in a table that all replicas share. Claims are a single upsert,
+// so two replicas never both claim a message. The table is created by the service's migrations:
+//
+//	CREATE TABLE kafka_dedup (
+//	    id text NOT NULL,
+//	    status text NOT NULL,
+//	    token text NOT NULL,
+//	    expires_at timestamp with time zone NOT NULL,
+//	    PRIMARY KEY (id)
+//	);
+type PostgresStore struct {
+	db        *gorm.DB
+	retention time.Duration
+}
+
+// NewPostgresStore creates a store that remembers handled messages for the retention, see PurgeEvery
+func NewPostgresStore(db *gorm.DB, retention time.Duration) *PostgresStore {
+	return &PostgresStore{db: db, retention: retention}
+}
+
+type dedupRow struct {
+	ID        string    `gorm:"type:text;primary_key"`
+	Status    string    `gorm:"type:text not null"`
+	Token     string    `gorm:"type:text not null"`
+	ExpiresAt time.Time `gorm:"type:timestamptz not null"`
+}
+
+func (dedupRow) TableName() string {
+	return "kafka_dedup"
+}
+
+func (s *PostgresStore) Claim(ctx context.Context, id, token string, lease time.Duration) (Status, error) {
+	// an expired row, in progress or done, is taken over by the new claim. Expiry uses the database clock
+	// only, so the clocks of the replicas do not matter.
+	res := s.db.WithContext(ctx).Exec(`INSERT INTO kafka_dedup (id, status, token, expires_at)
+		VALUES (?, ?, ?, now() + ?::double precision * interval '1 second')
+		ON CONFLICT (id) DO UPDATE SET status = EXCLUDED.status, token = EXCLUDED.token, expires_at = EXCLUDED.expires_at
+		WHERE kafka_dedup.expires_at <= now()`,
+		id, string(StatusInProgress), token, lease.Seconds())
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected > 0 {
+		return StatusClaimed, nil
+	}
+
+	var row dedupRow
+	err := s.db.WithContext(ctx).Where("id = ?", id).Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// released or purged in between, the message is claimed on its next attempt
+		return StatusInProgress, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return Status(row.Status), nil
+}
+
+func (s *PostgresStore) Done(ctx context.Context, id, token string) error {
+	return s.db.WithContext(ctx).Model(&dedupRow{}).
+		Where("id = ? AND token = ?", id, token).
+		Updates(map[string]any{
+			"status":     string(StatusDone),
+			"expires_at": gorm.Expr("now() + ?::double precision * interval '1 second'", s.retention.Seconds()),
+		}).Error
+}
+
+func (s *PostgresStore) Release(ctx context.Context, id, token string) error {
+	return s.db.WithContext(ctx).
+		Where("id = ? AND token = ? AND status = ?", id, token, string(StatusInProgress)).
+		Delete(&dedupRow{}).Error
+}
+
+// Purge deletes the expired rows and returns how many were deleted, expired rows are ignored by Claim so
+// purging only bounds the size of the table
+func (s *PostgresStore) Purge(ctx context.Context) (int64, error) {
+	res := s.db.WithContext(ctx).Where("expires_at <= now()").Delete(&dedupRow{})
+	return res.RowsAffected, res.Error
+}
+
+// PurgeEvery purges the expired rows every interval until the context is done, a failed purge is retried on
+// the next tick. An interval that is not positive defaults to 1h.
+func (s *PostgresStore) PurgeEvery(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultPurgeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			purged, err := s.Purge(ctx)
+			if err != nil {
+				slog.WarnContext(ctx, fmt.Sprintf("failed to purge expired kafka_dedup rows: %v", err))
+				continue
+			}
+			slog.DebugContext(ctx, fmt.Sprintf("purged %d expired kafka_dedup rows", purged))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HealthState is the state of a Consumer for readiness checks
+type HealthState string
+
+const (
+	// HealthIdle is the state of a consumer that was not started
+	HealthIdle HealthState = HealthState("idle")
+	// HealthStarting is the state of a started consumer that did not join its group yet
+	HealthStarting HealthState = HealthState("starting")
+	// HealthHealthy is the state of a consumer that joined its group and polls without errors
+	HealthHealthy HealthState = HealthState("healthy")
+	// HealthUnhealthy is the state of a consumer whose last poll or group session failed, it becomes healthy
+	// again once it polls without errors or partitions are assigned to it
+	HealthUnhealthy HealthState = HealthState("unhealthy")
+	// HealthStopped is the state of a consumer that was stopped or closed
+	HealthStopped HealthState = HealthState("stopped")
+)
+
+// Health returns the state of the consumer and, if it is unhealthy, the error that made it so
+func (c *Consumer) Health() (HealthState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return HealthStopped, nil
+	}
+	if c.done == nil {
+		return HealthIdle, nil
+	}
+	select {
+	case <-c.done:
+		// the context of Start was cancelled
+		return HealthStopped, nil
+	default:
+		return c.health, c.healthErr
+	}
+}
+
+// Ready returns an error unless the consumer is healthy, see Health
+func (c *Consumer) Ready() error {
+	state, err := c.Health()
+	if state == HealthHealthy {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("kafka consumer of %s is %s: %w", c.name(), state, err)
+	}
+	return fmt.Errorf("kafka consumer of %s is %s", c.name(), state)
+}
+
+// setHealth records the outcome of a poll or a group session
+func (c *Consumer) setHealth(state HealthState, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.health, c.healthErr = state, err
+}
+
+// Ready returns an error unless all consumers of the client are healthy, see Consumer.Ready, so the client
+// can back a readiness check. A closed client is not ready.
+func (c *Client) Ready() error {
+	c.mu.Lock()
+	consumers, closed := c.consumers, c.closed
+	c.mu.Unlock()
+
+	if closed {
+		return errors.New("kafka client is closed")
+	}
+
+	var errs []error
+	for _, consumer := range consumers {
+		errs = append(errs, consumer.Ready())
+	}
+	return errors.Join(errs...)
+}
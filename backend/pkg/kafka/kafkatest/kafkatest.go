@@ -0,0 +1,184 @@
+// Package kafkatest runs an in-process fake kafka cluster for unit and component tests, so tests of kafka
+// producers and consumers run with plain go test and no broker.
+package kafkatest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	"github.com/twmb/franz-go/pkg/kfake"
+)
+
+// Timeout bounds how long the helpers wait for the cluster and for records
+const Timeout = 10 * time.Second
+
+// Cluster is an in-process fake kafka cluster with a single broker
+type Cluster struct {
+	// Client is a client of the cluster that joins the consumer group "kafkatest"
+	Client *kafkaUtil.Client
+
+	cluster *kfake.Cluster
+}
+
+// NewCluster starts a fake cluster with the topics created from their config, including their retry tier and
+// dead letter topics, see Admin.EnsureTopics. The cluster and its client are closed when the test ends.
+func NewCluster(t testing.TB, topics ...kafkaUtil.Topic) *Cluster {
+	t.Helper()
+
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1))
+	if err != nil {
+		t.Fatalf("failed to start fake kafka cluster: %v", err)
+	}
+	t.Cleanup(cluster.Close)
+
+	c := &Cluster{cluster: cluster}
+	c.Client = c.NewClient(t, &kafkaUtil.Config{GroupId: "kafkatest"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	// the fake cluster has a single broker to place replicas on
+	replicated := make([]kafkaUtil.Topic, 0, len(topics))
+	for _, topic := range topics {
+		topic.ReplicaCount = 1
+		replicated = append(replicated, topic)
+	}
+	if _, err := c.Client.NewAdmin().EnsureTopics(ctx, replicated...); err != nil {
+		t.Fatalf("failed to create kafka topics: %v", err)
+	}
+
+	return c
+}
+
+// Config returns a copy of the config that connects to the cluster, e.g. to create the client of the service
+// under test from its own config. Groups start at the earliest offset unless the config sets otherwise.
+func (c *Cluster) Config(config *kafkaUtil.Config) *kafkaUtil.Config {
+	cfg := kafkaUtil.Config{}
+	if config != nil {
+		cfg = *config
+	}
+	cfg.BootstrapServers = c.cluster.ListenAddrs()
+	cfg.SecurityProtocol, cfg.SaslMechanism, cfg.SaslUsername, cfg.SaslPassword = "", "", "", ""
+	cfg.SslCaLocation, cfg.SslCertificateLocation, cfg.SslKeyLocation = "", "", ""
+	if cfg.AutoOffsetReset == "" {
+		cfg.AutoOffsetReset = "earliest"
+	}
+	return &cfg
+}
+
+// NewClient creates a client of the cluster from a copy of the config, see Config, which is closed when the
+// test ends
+func (c *Cluster) NewClient(t testing.TB, config *kafkaUtil.Config) *kafkaUtil.Client {
+	t.Helper()
+
+	client, err := kafkaUtil.NewClient(context.Background(), c.Config(config))
+	if err != nil {
+		t.Fatalf("failed to create kafka client: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+		defer cancel()
+		_ = client.Close(ctx)
+	})
+	return client
+}
+
+// Record starts recording the messages of the topic from its first offset, without joining a consumer group.
+// The recorder stops when the test ends.
+func (c *Cluster) Record(t testing.TB, topic string) *Recorder {
+	t.Helper()
+
+	client := c.NewClient(t, &kafkaUtil.Config{})
+	consumer, err := client.NewConsumer(topic)
+	if err != nil {
+		t.Fatalf("failed to record kafka topic %s: %v", topic, err)
+	}
+
+	r := &Recorder{topic: topic, added: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	consumer.Start(ctx, r)
+	return r
+}
+
+// Recorder records the messages of a topic, see Cluster.Record
+type Recorder struct {
+	topic string
+
+	mu       sync.Mutex
+	messages []kafkaUtil.Message
+	// added is closed and replaced whenever a message is recorded
+	added chan struct{}
+}
+
+func (r *Recorder) Handle(_ context.Context, message kafkaUtil.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, message)
+	close(r.added)
+	r.added = make(chan struct{})
+	return nil
+}
+
+// Messages returns the messages recorded so far in offset order per partition
+func (r *Recorder) Messages() []kafkaUtil.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]kafkaUtil.Message(nil), r.messages...)
+}
+
+// Await waits until at least n messages were recorded and returns them. It fails once the context is done
+// or Timeout passed.
+func (r *Recorder) Await(ctx context.Context, n int) ([]kafkaUtil.Message, error) {
+	messages, err := r.wait(ctx, func(messages []kafkaUtil.Message) bool {
+		return len(messages) >= n
+	})
+	if err != nil {
+		return messages, fmt.Errorf("expected %d messages on %s, got %d: %w", n, r.topic, len(messages), err)
+	}
+	return messages, nil
+}
+
+// AwaitMatch waits until a message that matches was recorded and returns it. It fails once the context is done
+// or Timeout passed.
+func (r *Recorder) AwaitMatch(ctx context.Context, match func(kafkaUtil.Message) bool) (kafkaUtil.Message, error) {
+	var matched kafkaUtil.Message
+	messages, err := r.wait(ctx, func(messages []kafkaUtil.Message) bool {
+		for _, message := range messages {
+			if match(message) {
+				matched = message
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return matched, fmt.Errorf("no matching message among the %d messages on %s: %w", len(messages), r.topic, err)
+	}
+	return matched, nil
+}
+
+// wait calls done with the recorded messages until it returns true
+func (r *Recorder) wait(ctx context.Context, done func([]kafkaUtil.Message) bool) ([]kafkaUtil.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	for {
+		r.mu.Lock()
+		messages, added := append([]kafkaUtil.Message(nil), r.messages...), r.added
+		r.mu.Unlock()
+
+		if done(messages) {
+			return messages, nil
+		}
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return messages, ctx.Err()
+		}
+	}
+}
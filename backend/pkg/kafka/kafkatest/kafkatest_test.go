@@ -0,0 +1,59 @@
+package kafkatest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCluster(t *testing.T) {
+	ctx := context.Background()
+	topic := kafkaUtil.Topic{Name: "topic-a", PartitionCount: 2, ReplicaCount: 3, RetryDelays: []time.Duration{time.Minute}, DeadLetter: true}
+	cluster := NewCluster(t, topic)
+
+	// the topic, its retry tier and dead letter topic exist already
+	report, err := cluster.Client.NewAdmin().EnsureTopics(ctx, kafkaUtil.Topic{Name: "topic-a", PartitionCount: 2, ReplicaCount: 1, RetryDelays: topic.RetryDelays, DeadLetter: true})
+	require.NoError(t, err)
+	assert.Empty(t, report.Created)
+
+	recorder := cluster.Record(t, "topic-a")
+	producer := cluster.Client.NewProducer("topic-a")
+	require.NoError(t, producer.Send(ctx,
+		kafkaUtil.Message{Key: []byte("a"), Value: []byte("first")},
+		kafkaUtil.Message{Key: []byte("b"), Value: []byte("second")},
+	).FirstErr())
+
+	messages, err := recorder.Await(ctx, 2)
+	require.NoError(t, err)
+	assert.Len(t, messages, 2)
+
+	message, err := recorder.AwaitMatch(ctx, func(message kafkaUtil.Message) bool {
+		return string(message.Key) == "b"
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(message.Value))
+
+	// a service creates its own client from its config
+	client := cluster.NewClient(t, &kafkaUtil.Config{GroupId: "service", BootstrapServers: []string{"localhost:9092"}})
+	consumer, err := client.NewConsumer("topic-a")
+	require.NoError(t, err)
+	received := make(chan kafkaUtil.Message, 2)
+	consumer.Start(ctx, kafkaUtil.ConsumerHandlerFunc(func(_ context.Context, message kafkaUtil.Message) error {
+		received <- message
+		return nil
+	}))
+	select {
+	case <-received:
+	case <-time.After(Timeout):
+		t.Fatal("service consumer did not start at the earliest offset")
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = recorder.Await(waitCtx, 3)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
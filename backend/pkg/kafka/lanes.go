@@ -0,0 +1,358 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// LaneMode selects how records are assigned to ordered lanes. Records within a lane are handled one at a
+// time in offset order, records of different lanes are handled concurrently.
+type LaneMode string
+
+const (
+	// LanePerPartition keeps the order of all records of a partition, this is the default
+	LanePerPartition LaneMode = LaneMode("partition")
+	// LanePerKey keeps the order of the records with the same key within a partition
+	LanePerKey LaneMode = LaneMode("key")
+)
+
+// Concurrency configures how many records a Consumer handles at the same time
+type Concurrency struct {
+	// Lanes selects how records are assigned to ordered lanes
+	Lanes LaneMode `mapstructure:"LANES"`
+	// MaxWorkers bounds the number of handlers in flight across all lanes, defaults to 1
+	MaxWorkers int `mapstructure:"MAX_WORKERS"`
+	// MaxBuffered bounds the number of records fetched but not yet handled, defaults to 16 records per worker
+	MaxBuffered int `mapstructure:"MAX_BUFFERED"`
+}
+
+// WithConcurrency handles records on ordered lanes with at most MaxWorkers handlers in flight.
+// Offsets are committed per partition up to the highest record below which all records were handled.
+func WithConcurrency(concurrency Concurrency) ConsumerOption {
+	return func(c *Consumer) {
+		c.concurrency = concurrency
+	}
+}
+
+func (cc Concurrency) withDefaults() Concurrency {
+	if cc.MaxWorkers <= 0 {
+		cc.MaxWorkers = 1
+	}
+	if cc.MaxBuffered <= 0 {
+		cc.MaxBuffered = 16 * cc.MaxWorkers
+	}
+	return cc
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type laneKey struct {
+	topicPartition
+	key string
+}
+
+// lanes dispatches records to ordered lanes. A lane's goroutine is started with its first record and
+// exits once the lane is empty, so idle keys do not hold on to goroutines.
+type lanes struct {
+	mode   LaneMode
+	handle func([]*kgo.Record)
+	// a lane hands up to maxRecords queued records to handle at once, waiting up to maxWait for them
+	maxRecords int
+	maxWait    time.Duration
+	// waiting for records stops once ctx is done
+	ctx context.Context
+
+	mu       sync.Mutex
+	queues   map[laneKey][]*kgo.Record
+	handling map[laneKey]int
+	buffered int
+	freed    chan struct{}
+	// exited is closed and replaced whenever a lane exits, changed whenever records are dispatched or dropped
+	exited  chan struct{}
+	changed chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newLanes(mode LaneMode, handle func(*kgo.Record)) *lanes {
+	return newBatchLanes(context.Background(), mode, 1, 0, func(records []*kgo.Record) {
+		handle(records[0])
+	})
+}
+
+// newBatchLanes creates lanes that hand batches of the records queued on a lane to handle
+func newBatchLanes(ctx context.Context, mode LaneMode, maxRecords int, maxWait time.Duration, handle func([]*kgo.Record)) *lanes {
+	return &lanes{
+		mode:       mode,
+		handle:     handle,
+		maxRecords: maxRecords,
+		maxWait:    maxWait,
+		ctx:        ctx,
+		queues:     make(map[laneKey][]*kgo.Record),
+		handling:   make(map[laneKey]int),
+		freed:      make(chan struct{}, 1),
+		exited:     make(chan struct{}),
+		changed:    make(chan struct{}),
+	}
+}
+
+// dispatch queues the record on its lane
+func (l *lanes) dispatch(record *kgo.Record) {
+	key := laneKey{topicPartition: topicPartition{record.Topic, record.Partition}}
+	if l.mode == LanePerKey {
+		key.key = string(record.Key)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buffered++
+	queue, running := l.queues[key]
+	l.queues[key] = append(queue, record)
+	l.notifyChanged()
+	if !running {
+		l.wg.Add(1)
+		go l.run(key)
+	}
+}
+
+func (l *lanes) run(key laneKey) {
+	defer l.wg.Done()
+	for {
+		records := l.next(key)
+		if len(records) == 0 {
+			return
+		}
+
+		l.handle(records)
+
+		l.mu.Lock()
+		l.queues[key] = l.queues[key][len(records):]
+		l.buffered -= len(records)
+		delete(l.handling, key)
+		l.mu.Unlock()
+
+		select {
+		case l.freed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// next returns the records the lane handles next, once maxRecords are queued, maxWait passed or ctx is done.
+// It removes the lane and returns none if the lane is empty.
+func (l *lanes) next(key laneKey) []*kgo.Record {
+	deadline := time.Now().Add(l.maxWait)
+	for {
+		l.mu.Lock()
+		queue := l.queues[key]
+		if len(queue) == 0 {
+			delete(l.queues, key)
+			close(l.exited)
+			l.exited = make(chan struct{})
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Until(deadline)
+		if len(queue) >= l.maxRecords || wait <= 0 || l.ctx.Err() != nil {
+			n := min(len(queue), l.maxRecords)
+			l.handling[key] = n
+			l.mu.Unlock()
+			return queue[:n:n]
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-l.ctx.Done():
+		}
+		timer.Stop()
+	}
+}
+
+// notifyChanged wakes up the lanes waiting for records, l.mu must be held
+func (l *lanes) notifyChanged() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// waitForCapacity blocks until fewer than max records are buffered and returns how many more records
+// can be dispatched, or 0 if the context is done first
+func (l *lanes) waitForCapacity(ctx context.Context, max int) int {
+	for {
+		l.mu.Lock()
+		free := max - l.buffered
+		l.mu.Unlock()
+		if free > 0 {
+			return free
+		}
+
+		select {
+		case <-l.freed:
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+// wait blocks until all dispatched records were handled
+func (l *lanes) wait() {
+	l.wg.Wait()
+}
+
+// drop removes the records of the partitions that are queued behind the records their lane is handling
+func (l *lanes) drop(partitions map[topicPartition]bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, queue := range l.queues {
+		// the head of a lane is kept even if the lane did not take it yet, it is skipped as its partition is revoked
+		keep := max(l.handling[key], 1)
+		if partitions[key.topicPartition] && len(queue) > keep {
+			l.buffered -= len(queue) - keep
+			l.queues[key] = queue[:keep]
+		}
+	}
+	l.notifyChanged()
+	select {
+	case l.freed <- struct{}{}:
+	default:
+	}
+}
+
+// waitIdle blocks until no lane of the partitions is running, or the context is done
+func (l *lanes) waitIdle(ctx context.Context, partitions map[topicPartition]bool) {
+	for {
+		l.mu.Lock()
+		busy := false
+		for key := range l.queues {
+			if partitions[key.topicPartition] {
+				busy = true
+				break
+			}
+		}
+		exited := l.exited
+		l.mu.Unlock()
+		if !busy {
+			return
+		}
+
+		select {
+		case <-exited:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// offsetTracker marks a record for commit once it and all records dispatched before it from the same
+// partition were handled, so a commit never skips over a record that is still in flight
+type offsetTracker struct {
+	mark func(*kgo.Record)
+
+	mu         sync.Mutex
+	partitions map[topicPartition][]*trackedRecord
+	tracked    map[*kgo.Record]*trackedRecord
+	revoked    map[topicPartition]bool
+}
+
+type trackedRecord struct {
+	record *kgo.Record
+	done   bool
+}
+
+func newOffsetTracker(mark func(*kgo.Record)) *offsetTracker {
+	return &offsetTracker{
+		mark:       mark,
+		partitions: make(map[topicPartition][]*trackedRecord),
+		tracked:    make(map[*kgo.Record]*trackedRecord),
+		revoked:    make(map[topicPartition]bool),
+	}
+}
+
+// add tracks a record, records must be added in the order they were fetched
+func (t *offsetTracker) add(record *kgo.Record) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tr := &trackedRecord{record: record}
+	tp := topicPartition{record.Topic, record.Partition}
+	t.partitions[tp] = append(t.partitions[tp], tr)
+	t.tracked[record] = tr
+}
+
+// owned reports whether the record is tracked and its partition was not revoked
+func (t *offsetTracker) owned(record *kgo.Record) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.tracked[record]
+	return ok && !t.revoked[topicPartition{record.Topic, record.Partition}]
+}
+
+// revoke flags the partitions as revoked, their records are still marked once handled until they are forgotten
+func (t *offsetTracker) revoke(partitions map[topicPartition]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for tp := range partitions {
+		t.revoked[tp] = true
+	}
+}
+
+// forget stops tracking the records of the partitions, they are not marked anymore
+func (t *offsetTracker) forget(partitions map[topicPartition]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for tp := range partitions {
+		for _, tr := range t.partitions[tp] {
+			delete(t.tracked, tr.record)
+		}
+		delete(t.partitions, tp)
+		delete(t.revoked, tp)
+	}
+}
+
+// done flags the record as handled and marks the highest contiguous handled record of its partition
+// outside the lock, since marking may commit synchronously
+func (t *offsetTracker) done(record *kgo.Record) {
+	if last := t.handled(record); last != nil {
+		t.mark(last)
+	}
+}
+
+// handled flags the record as handled and returns the highest contiguous handled record of its partition, if
+// that advanced
+func (t *offsetTracker) handled(record *kgo.Record) *kgo.Record {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tr, ok := t.tracked[record]
+	if !ok {
+		return nil
+	}
+	tr.done = true
+	delete(t.tracked, record)
+
+	tp := topicPartition{record.Topic, record.Partition}
+	pending := t.partitions[tp]
+	var last *kgo.Record
+	for len(pending) > 0 && pending[0].done {
+		last = pending[0].record
+		pending = pending[1:]
+	}
+	if len(pending) == 0 {
+		delete(t.partitions, tp)
+	} else {
+		t.partitions[tp] = pending
+	}
+	return last
+}
@@ -0,0 +1,183 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	var marked []int64
+	tracker := newOffsetTracker(func(r *kgo.Record) {
+		marked = append(marked, r.Offset)
+	})
+
+	p0 := []*kgo.Record{
+		{Topic: "t", Partition: 0, Offset: 10},
+		{Topic: "t", Partition: 0, Offset: 11},
+		// offsets may have gaps, e.g. on compacted topics
+		{Topic: "t", Partition: 0, Offset: 15},
+	}
+	p1 := &kgo.Record{Topic: "t", Partition: 1, Offset: 3}
+	for _, r := range append(p0, p1) {
+		tracker.add(r)
+	}
+
+	tracker.done(p0[1])
+	assert.Empty(t, marked, "offset 11 must wait for offset 10")
+
+	tracker.done(p1)
+	assert.Equal(t, []int64{3}, marked)
+
+	tracker.done(p0[0])
+	assert.Equal(t, []int64{3, 11}, marked, "offset 10 releases offset 11")
+
+	tracker.done(p0[2])
+	assert.Equal(t, []int64{3, 11, 15}, marked)
+
+	// completing a record twice is a no-op
+	tracker.done(p0[2])
+	assert.Equal(t, []int64{3, 11, 15}, marked)
+}
+
+func TestLanesOrdering(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  LaneMode
+		lanes int
+	}{
+		{name: "lane per partition", mode: LanePerPartition, lanes: 2},
+		{name: "lane per key", mode: LanePerKey, lanes: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			handled := make(map[laneKey][]int64)
+			var inFlight, maxInFlight atomic.Int32
+
+			l := newLanes(tt.mode, func(r *kgo.Record) {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					m := maxInFlight.Load()
+					if n <= m || maxInFlight.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+
+				key := laneKey{topicPartition: topicPartition{r.Topic, r.Partition}}
+				if tt.mode == LanePerKey {
+					key.key = string(r.Key)
+				}
+				mu.Lock()
+				handled[key] = append(handled[key], r.Offset)
+				mu.Unlock()
+			})
+
+			for offset := int64(0); offset < 20; offset++ {
+				for partition := int32(0); partition < 2; partition++ {
+					key := "a"
+					if offset%2 == 1 {
+						key = "b"
+					}
+					l.dispatch(&kgo.Record{Topic: "t", Partition: partition, Offset: offset, Key: []byte(key)})
+				}
+			}
+			l.wait()
+
+			assert.Len(t, handled, tt.lanes)
+			for key, offsets := range handled {
+				assert.IsIncreasing(t, offsets, "lane %v handled out of order", key)
+			}
+			assert.LessOrEqual(t, int(maxInFlight.Load()), tt.lanes)
+			assert.Equal(t, 0, l.waitForCapacity(canceledContext(), 0))
+		})
+	}
+}
+
+func TestLanesCapacity(t *testing.T) {
+	release := make(chan struct{})
+	l := newLanes(LanePerKey, func(r *kgo.Record) {
+		<-release
+	})
+
+	l.dispatch(&kgo.Record{Key: []byte("a")})
+	l.dispatch(&kgo.Record{Key: []byte("b")})
+
+	assert.Equal(t, 1, l.waitForCapacity(context.Background(), 3))
+	assert.Equal(t, 0, l.waitForCapacity(canceledContext(), 2))
+
+	close(release)
+	l.wait()
+	assert.Equal(t, 2, l.waitForCapacity(context.Background(), 2))
+}
+
+func TestLanesDrop(t *testing.T) {
+	release := make(chan struct{})
+	var handled atomic.Int32
+	l := newLanes(LanePerPartition, func(r *kgo.Record) {
+		<-release
+		handled.Add(1)
+	})
+
+	for i := int64(0); i < 3; i++ {
+		l.dispatch(&kgo.Record{Topic: "t", Partition: 0, Offset: i})
+	}
+	l.dispatch(&kgo.Record{Topic: "t", Partition: 1})
+	revoked := map[topicPartition]bool{{"t", 0}: true}
+
+	// only the record the lane is handling is kept
+	l.drop(revoked)
+	assert.Equal(t, 2, l.waitForCapacity(context.Background(), 4))
+
+	l.waitIdle(canceledContext(), revoked)
+	close(release)
+	l.waitIdle(context.Background(), revoked)
+	l.wait()
+	assert.Equal(t, int32(2), handled.Load())
+}
+
+func TestOffsetTrackerRevoke(t *testing.T) {
+	var marked []int64
+	tracker := newOffsetTracker(func(r *kgo.Record) {
+		marked = append(marked, r.Offset)
+	})
+
+	p0 := &kgo.Record{Topic: "t", Partition: 0, Offset: 1}
+	p1 := &kgo.Record{Topic: "t", Partition: 1, Offset: 1}
+	tracker.add(p0)
+	tracker.add(p1)
+	revoked := map[topicPartition]bool{{"t", 0}: true}
+
+	tracker.revoke(revoked)
+	assert.False(t, tracker.owned(p0))
+	assert.True(t, tracker.owned(p1))
+
+	// records that finish while the partition is revoked are still marked
+	tracker.done(p0)
+	assert.Equal(t, []int64{1}, marked)
+
+	late := &kgo.Record{Topic: "t", Partition: 0, Offset: 2}
+	tracker.add(late)
+	tracker.forget(revoked)
+	tracker.done(late)
+	assert.Equal(t, []int64{1}, marked)
+
+	// the partition can be assigned again
+	p0 = &kgo.Record{Topic: "t", Partition: 0, Offset: 3}
+	tracker.add(p0)
+	assert.True(t, tracker.owned(p0))
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
@@ -0,0 +1,57 @@
+package kafka
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// slogLogger writes the kafka client logs to the default slog logger, which InitLogger sends to the
+// otel-collector. The default logger is looked up on every log, so it may be set after the client is created.
+type slogLogger struct {
+	level kgo.LogLevel
+}
+
+// newSlogLogger returns the logger for the level: debug, info, warn, error or none. It returns nil for none,
+// which leaves the client without a logger.
+func newSlogLogger(level string) (kgo.Logger, error) {
+	var l kgo.LogLevel
+	switch strings.ToLower(level) {
+	case "", "none":
+		return nil, nil
+	case "debug":
+		l = kgo.LogLevelDebug
+	case "info":
+		l = kgo.LogLevelInfo
+	case "warn":
+		l = kgo.LogLevelWarn
+	case "error":
+		l = kgo.LogLevelError
+	default:
+		return nil, invalidTuningConfig("unknown log level %q", level)
+	}
+	return &slogLogger{level: l}, nil
+}
+
+func (l *slogLogger) Level() kgo.LogLevel {
+	return l.level
+}
+
+func (l *slogLogger) Log(level kgo.LogLevel, msg string, keyvals ...any) {
+	slog.Default().With("component", "kafka").Log(context.Background(), slogLevel(level), msg, keyvals...)
+}
+
+func slogLevel(level kgo.LogLevel) slog.Level {
+	switch level {
+	case kgo.LogLevelError:
+		return slog.LevelError
+	case kgo.LogLevelWarn:
+		return slog.LevelWarn
+	case kgo.LogLevelInfo:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug
+	}
+}
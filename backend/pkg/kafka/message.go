@@ -0,0 +1,103 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// HeaderMessageID identifies a message across redeliveries, retries and replays, e.g. to deduplicate it
+const HeaderMessageID = "x-message-id"
+
+// Header is a kafka record header
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message is a kafka record as sent by a Producer and received by a ConsumerHandler
+type Message struct {
+	// Topic overrides the producer's topic when sending. When consuming it is the consumer's topic,
+	// also for records consumed from one of its retry tiers.
+	Topic string
+	// Key is hashed to pick the partition, messages with the same key keep their order
+	Key   []byte
+	Value []byte
+	// Headers carry metadata along with the message, the trace context is added when sending
+	Headers []Header
+	// Timestamp defaults to the time the message is sent
+	Timestamp time.Time
+
+	// Partition and Offset are set on consumed messages and on successfully sent messages
+	Partition int32
+	Offset    int64
+}
+
+// Header returns the value of the last header with the key and whether it exists
+func (m Message) Header(key string) ([]byte, bool) {
+	for i := len(m.Headers) - 1; i >= 0; i-- {
+		if m.Headers[i].Key == key {
+			return m.Headers[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// SendResult is the outcome of sending a single message
+type SendResult struct {
+	Message Message
+	Err     error
+}
+
+// SendResults are the outcomes of sending a batch of messages, in the order the messages were passed
+type SendResults []SendResult
+
+// FirstErr returns the first error of the results, or nil if all messages were sent
+func (rs SendResults) FirstErr() error {
+	for _, r := range rs {
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+	return nil
+}
+
+// toRecord creates the record for the message, sent to topic unless the message overrides it
+func (m Message) toRecord(topic string) *kgo.Record {
+	if m.Topic != "" {
+		topic = m.Topic
+	}
+
+	record := &kgo.Record{
+		Topic:     topic,
+		Key:       m.Key,
+		Value:     m.Value,
+		Timestamp: m.Timestamp,
+	}
+	if len(m.Headers) > 0 {
+		record.Headers = make([]kgo.RecordHeader, 0, len(m.Headers))
+		for _, h := range m.Headers {
+			record.Headers = append(record.Headers, kgo.RecordHeader{Key: h.Key, Value: h.Value})
+		}
+	}
+	return record
+}
+
+// messageFromRecord creates the message for the record, reported as coming from topic
+func messageFromRecord(record *kgo.Record, topic string) Message {
+	msg := Message{
+		Topic:     topic,
+		Key:       record.Key,
+		Value:     record.Value,
+		Timestamp: record.Timestamp,
+		Partition: record.Partition,
+		Offset:    record.Offset,
+	}
+	if len(record.Headers) > 0 {
+		msg.Headers = make([]Header, 0, len(record.Headers))
+		for _, h := range record.Headers {
+			msg.Headers = append(msg.Headers, Header{Key: h.Key, Value: h.Value})
+		}
+	}
+	return msg
+}
@@ -0,0 +1,79 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestMessageToRecord(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		message       Message
+		expectedTopic string
+	}{
+		{
+			name:          "producer topic",
+			message:       Message{Key: []byte("usr-1"), Value: []byte("v"), Headers: []Header{{Key: "h", Value: []byte("1")}}, Timestamp: now},
+			expectedTopic: "default",
+		},
+		{
+			name:          "topic override",
+			message:       Message{Topic: "other", Key: []byte("usr-1"), Value: []byte("v"), Headers: []Header{{Key: "h", Value: []byte("1")}}, Timestamp: now},
+			expectedTopic: "other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := tt.message.toRecord("default")
+
+			assert.Equal(t, tt.expectedTopic, record.Topic)
+			assert.Equal(t, []byte("usr-1"), record.Key)
+			assert.Equal(t, []byte("v"), record.Value)
+			assert.Equal(t, now, record.Timestamp)
+			assert.Equal(t, []kgo.RecordHeader{{Key: "h", Value: []byte("1")}}, record.Headers)
+
+			// headers added to the record, e.g. the trace context, do not leak into the message
+			NewRecordCarrier(record).Set("traceparent", "tp")
+			assert.Len(t, tt.message.Headers, 1)
+		})
+	}
+}
+
+func TestMessageFromRecord(t *testing.T) {
+	record := &kgo.Record{
+		Topic:     "t.retry.1m",
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("usr-1"),
+		Value:     []byte("v"),
+		Headers:   []kgo.RecordHeader{{Key: "h", Value: []byte("1")}, {Key: "h", Value: []byte("2")}},
+	}
+
+	msg := messageFromRecord(record, "t")
+
+	assert.Equal(t, "t", msg.Topic)
+	assert.Equal(t, int32(2), msg.Partition)
+	assert.Equal(t, int64(42), msg.Offset)
+	assert.Equal(t, []byte("usr-1"), msg.Key)
+	assert.Equal(t, []byte("v"), msg.Value)
+
+	value, ok := msg.Header("h")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("2"), value)
+	_, ok = msg.Header("missing")
+	assert.False(t, ok)
+}
+
+func TestSendResultsFirstErr(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	assert.NoError(t, SendResults{{}, {}}.FirstErr())
+	assert.ErrorIs(t, SendResults{{}, {Err: errFailed}, {Err: errors.New("other")}}.FirstErr(), errFailed)
+}
@@ -0,0 +1,300 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+const (
+	// ConsumedMeterName is the name of the counter of records polled by the consumers
+	ConsumedMeterName = "kafka.consumer.records.consumed"
+	// ConsumedBytesMeterName is the name of the counter of the key and value bytes of the records polled by the consumers
+	ConsumedBytesMeterName = "kafka.consumer.bytes.consumed"
+	// CommitFailedMeterName is the name of the counter of offset commits that failed
+	CommitFailedMeterName = "kafka.consumer.commits.failed"
+	// RebalanceMeterName is the name of the counter of partitions assigned to, revoked from or lost by the consumers
+	RebalanceMeterName = "kafka.consumer.rebalances"
+	// LagMeterName is the name of the gauge of the records of each partition between the next record to be
+	// handled and the high watermark
+	LagMeterName = "kafka.consumer.lag"
+	// ProduceDurationMeterName is the name of the histogram of the time in seconds from sending a record until
+	// it was acknowledged or failed
+	ProduceDurationMeterName = "kafka.producer.produce.duration"
+	// ProduceFailedMeterName is the name of the counter of records that could not be produced
+	ProduceFailedMeterName = "kafka.producer.records.failed"
+	// BufferedMeterName is the name of the gauge of the records sent but not yet acknowledged by each client
+	BufferedMeterName = "kafka.producer.records.buffered"
+	// BatchRecordsMeterName is the name of the histogram of the number of records of each batch written
+	BatchRecordsMeterName = "kafka.producer.batch.records"
+	// BatchBytesMeterName is the name of the histogram of the uncompressed bytes of each batch written
+	BatchBytesMeterName = "kafka.producer.batch.bytes"
+)
+
+// clientMetrics are the instruments of a Client and its consumers, created with the meter of the context
+// passed to NewClient
+type clientMetrics struct {
+	consumed        metric.Int64Counter
+	consumedBytes   metric.Int64Counter
+	commitFailed    metric.Int64Counter
+	rebalances      metric.Int64Counter
+	produceDuration metric.Float64Histogram
+	produceFailed   metric.Int64Counter
+	batchRecords    metric.Int64Histogram
+	batchBytes      metric.Int64Histogram
+
+	// produced holds the time each record in flight was sent at
+	produced sync.Map
+
+	mu        sync.Mutex
+	producer  *kgo.Client
+	consumers map[*Consumer]bool
+}
+
+func newClientMetrics(ctx context.Context) (*clientMetrics, error) {
+	meter, _ := otelContext.Meter(ctx)
+	m := &clientMetrics{consumers: make(map[*Consumer]bool)}
+
+	counters := []struct {
+		counter *metric.Int64Counter
+		name    string
+		opts    []metric.Int64CounterOption
+	}{
+		{&m.consumed, ConsumedMeterName, nil},
+		{&m.consumedBytes, ConsumedBytesMeterName, []metric.Int64CounterOption{metric.WithUnit("By")}},
+		{&m.commitFailed, CommitFailedMeterName, nil},
+		{&m.rebalances, RebalanceMeterName, nil},
+		{&m.produceFailed, ProduceFailedMeterName, nil},
+	}
+	for _, c := range counters {
+		counter, err := meter.Int64Counter(c.name, c.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create counter %q: %w", c.name, err)
+		}
+		*c.counter = counter
+	}
+
+	var err error
+	if m.produceDuration, err = meter.Float64Histogram(ProduceDurationMeterName, metric.WithUnit("s")); err != nil {
+		return nil, fmt.Errorf("failed to create histogram %q: %w", ProduceDurationMeterName, err)
+	}
+	if m.batchRecords, err = meter.Int64Histogram(BatchRecordsMeterName); err != nil {
+		return nil, fmt.Errorf("failed to create histogram %q: %w", BatchRecordsMeterName, err)
+	}
+	if m.batchBytes, err = meter.Int64Histogram(BatchBytesMeterName, metric.WithUnit("By")); err != nil {
+		return nil, fmt.Errorf("failed to create histogram %q: %w", BatchBytesMeterName, err)
+	}
+	if _, err = meter.Int64ObservableGauge(LagMeterName, metric.WithInt64Callback(m.observeLag)); err != nil {
+		return nil, fmt.Errorf("failed to create gauge %q: %w", LagMeterName, err)
+	}
+	if _, err = meter.Int64ObservableGauge(BufferedMeterName, metric.WithInt64Callback(m.observeBuffered)); err != nil {
+		return nil, fmt.Errorf("failed to create gauge %q: %w", BufferedMeterName, err)
+	}
+
+	return m, nil
+}
+
+// observeProducer adds the producer client to the buffered records gauge
+func (m *clientMetrics) observeProducer(producer *kgo.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.producer = producer
+}
+
+// register adds the consumer to the gauges until it is unregistered
+func (m *clientMetrics) register(consumer *Consumer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.consumers[consumer] = true
+}
+
+func (m *clientMetrics) unregister(consumer *Consumer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.consumers, consumer)
+}
+
+func (m *clientMetrics) registered() (*kgo.Client, []*Consumer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	consumers := make([]*Consumer, 0, len(m.consumers))
+	for consumer := range m.consumers {
+		consumers = append(consumers, consumer)
+	}
+	return m.producer, consumers
+}
+
+func (m *clientMetrics) observeLag(_ context.Context, observer metric.Int64Observer) error {
+	_, consumers := m.registered()
+	for _, consumer := range consumers {
+		group := semconv.MessagingKafkaConsumerGroup(consumer.group)
+		consumer.lag.each(func(tp topicPartition, lag int64) {
+			observer.Observe(lag, metric.WithAttributes(
+				group,
+				semconv.MessagingDestinationName(tp.topic),
+				semconv.MessagingKafkaDestinationPartition(int(tp.partition)),
+			))
+		})
+	}
+	return nil
+}
+
+func (m *clientMetrics) observeBuffered(_ context.Context, observer metric.Int64Observer) error {
+	producer, consumers := m.registered()
+	if producer != nil {
+		observer.Observe(producer.BufferedProduceRecords(), metric.WithAttributes(attribute.String("client", "producer")))
+	}
+	for _, consumer := range consumers {
+		observer.Observe(consumer.client.BufferedProduceRecords(), metric.WithAttributes(attribute.String("client", consumer.name())))
+	}
+	return nil
+}
+
+// polled counts the records of a poll
+func (m *clientMetrics) polled(ctx context.Context, group string, records []*kgo.Record) {
+	counts := make(map[string][2]int64)
+	for _, record := range records {
+		count := counts[record.Topic]
+		counts[record.Topic] = [2]int64{count[0] + 1, count[1] + int64(len(record.Key)+len(record.Value))}
+	}
+	for topic, count := range counts {
+		attrs := metric.WithAttributes(semconv.MessagingKafkaConsumerGroup(group), semconv.MessagingDestinationName(topic))
+		m.consumed.Add(ctx, count[0], attrs)
+		m.consumedBytes.Add(ctx, count[1], attrs)
+	}
+}
+
+// rebalanced counts the partitions of a rebalance event, which is assigned, revoked or lost
+func (m *clientMetrics) rebalanced(ctx context.Context, group, event string, partitions map[string][]int32) {
+	for topic, ps := range partitions {
+		m.rebalances.Add(ctx, int64(len(ps)), metric.WithAttributes(
+			semconv.MessagingKafkaConsumerGroup(group),
+			semconv.MessagingDestinationName(topic),
+			attribute.String("event", event),
+		))
+	}
+}
+
+func (m *clientMetrics) commitFailure(ctx context.Context, group string) {
+	m.commitFailed.Add(ctx, 1, metric.WithAttributes(semconv.MessagingKafkaConsumerGroup(group)))
+}
+
+// hooks returns the client hooks that record the produce metrics of a client
+func (m *clientMetrics) hooks() kgo.Opt {
+	return kgo.WithHooks(produceHooks{m})
+}
+
+type produceHooks struct {
+	m *clientMetrics
+}
+
+func (h produceHooks) OnProduceRecordBuffered(record *kgo.Record) {
+	h.m.produced.Store(record, time.Now())
+}
+
+func (h produceHooks) OnProduceRecordUnbuffered(record *kgo.Record, err error) {
+	ctx := record.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	topic := semconv.MessagingDestinationName(record.Topic)
+	if sent, ok := h.m.produced.LoadAndDelete(record); ok {
+		h.m.produceDuration.Record(ctx, time.Since(sent.(time.Time)).Seconds(), metric.WithAttributes(topic, attribute.Bool("error", err != nil)))
+	}
+	if err != nil {
+		h.m.produceFailed.Add(ctx, 1, metric.WithAttributes(topic))
+	}
+}
+
+func (h produceHooks) OnProduceBatchWritten(_ kgo.BrokerMetadata, topic string, _ int32, metrics kgo.ProduceBatchMetrics) {
+	attrs := metric.WithAttributes(semconv.MessagingDestinationName(topic))
+	h.m.batchRecords.Record(context.Background(), int64(metrics.NumRecords), attrs)
+	h.m.batchBytes.Record(context.Background(), int64(metrics.UncompressedBytes), attrs)
+}
+
+// commitErr returns the error of an offset commit, either of the request or of the first partition that failed
+func commitErr(resp *kmsg.OffsetCommitResponse, err error) error {
+	if err != nil || resp == nil {
+		return err
+	}
+	for _, topic := range resp.Topics {
+		for _, p := range topic.Partitions {
+			if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+				return fmt.Errorf("%s/%d: %w", topic.Topic, p.Partition, err)
+			}
+		}
+	}
+	return nil
+}
+
+// lagTracker tracks the position of each partition of a consumer, the offset of the next record to be handled,
+// and the high watermark of the partition's last fetch
+type lagTracker struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionLag
+}
+
+type partitionLag struct {
+	highWatermark int64
+	position      int64
+}
+
+func newLagTracker() *lagTracker {
+	return &lagTracker{partitions: make(map[topicPartition]*partitionLag)}
+}
+
+// fetched updates the high watermark of the partition, the first record fetched is the initial position
+func (l *lagTracker) fetched(topic string, p kgo.FetchPartition) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tp := topicPartition{topic, p.Partition}
+	pl, ok := l.partitions[tp]
+	if !ok {
+		if len(p.Records) == 0 {
+			return
+		}
+		pl = &partitionLag{position: p.Records[0].Offset}
+		l.partitions[tp] = pl
+	}
+	pl.highWatermark = p.HighWatermark
+}
+
+// handled moves the position of the record's partition past the record, all records before it were handled
+func (l *lagTracker) handled(record *kgo.Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// records may be marked out of order once the tracker released its lock, the position only moves forward
+	if pl, ok := l.partitions[topicPartition{record.Topic, record.Partition}]; ok && record.Offset+1 > pl.position {
+		pl.position = record.Offset + 1
+	}
+}
+
+// forget stops tracking the partitions once they were revoked
+func (l *lagTracker) forget(partitions map[topicPartition]bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for tp := range partitions {
+		delete(l.partitions, tp)
+	}
+}
+
+func (l *lagTracker) each(fn func(topicPartition, int64)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for tp, pl := range l.partitions {
+		fn(tp, max(pl.highWatermark-pl.position, 0))
+	}
+}
@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestClientMetrics(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "topic-a"))
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	meterCtx := otelContext.WithMeter(ctx, mp.Meter("test"))
+	client, err := NewClient(meterCtx, &Config{BootstrapServers: cluster.ListenAddrs(), GroupId: "group-a", AutoOffsetReset: "earliest"})
+	require.NoError(t, err)
+	defer client.Close(ctx)
+
+	producer := client.NewProducer("topic-a")
+	require.NoError(t, producer.Send(ctx, Message{Value: []byte("first")}, Message{Value: []byte("second")}).FirstErr())
+
+	consumer, err := client.NewConsumer("topic-a")
+	require.NoError(t, err)
+	handler := &blockingHandler{started: make(chan struct{}, 2), release: make(chan struct{})}
+	consumer.Start(ctx, handler)
+	<-handler.started
+
+	// the first record is in flight, so both records count towards the lag
+	collect := func() map[string]int64 {
+		var rm metricdata.ResourceMetrics
+		require.NoError(t, reader.Collect(ctx, &rm))
+		values := map[string]int64{}
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					values[m.Name] += dp.Value
+				}
+			case metricdata.Gauge[int64]:
+				for _, dp := range data.DataPoints {
+					values[m.Name] += dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					values[m.Name] += int64(dp.Count)
+				}
+			case metricdata.Histogram[int64]:
+				for _, dp := range data.DataPoints {
+					values[m.Name] += dp.Sum
+				}
+			}
+		}
+		return values
+	}
+	values := collect()
+	assert.Equal(t, int64(2), values[ConsumedMeterName])
+	assert.Equal(t, int64(len("first")+len("second")), values[ConsumedBytesMeterName])
+	assert.Equal(t, int64(2), values[LagMeterName])
+	assert.Equal(t, int64(2), values[ProduceDurationMeterName])
+	assert.Equal(t, int64(2), values[BatchRecordsMeterName])
+	assert.Equal(t, int64(1), values[RebalanceMeterName])
+	assert.Zero(t, values[ProduceFailedMeterName])
+
+	close(handler.release)
+	<-handler.started
+	assert.Eventually(t, func() bool {
+		return collect()[LagMeterName] == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestLagTracker(t *testing.T) {
+	lag := newLagTracker()
+	records := []*kgo.Record{
+		{Topic: "topic-a", Partition: 0, Offset: 5},
+		{Topic: "topic-a", Partition: 0, Offset: 6},
+	}
+	lag.fetched("topic-a", kgo.FetchPartition{Partition: 0, HighWatermark: 10, Records: records})
+	// a partition is only tracked once records were fetched from it
+	lag.fetched("topic-a", kgo.FetchPartition{Partition: 1, HighWatermark: 10})
+
+	lags := func() map[topicPartition]int64 {
+		values := map[topicPartition]int64{}
+		lag.each(func(tp topicPartition, lag int64) {
+			values[tp] = lag
+		})
+		return values
+	}
+	tp := topicPartition{"topic-a", 0}
+	assert.Equal(t, map[topicPartition]int64{tp: 5}, lags())
+
+	lag.handled(records[1])
+	assert.Equal(t, map[topicPartition]int64{tp: 3}, lags())
+
+	lag.forget(map[topicPartition]bool{tp: true})
+	assert.Empty(t, lags())
+}
+
+func TestConsumerHealth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, _ := newTestCluster(t, 1, "topic-a")
+	defer client.Close(ctx)
+
+	consumer, err := client.NewConsumer("topic-a")
+	require.NoError(t, err)
+	state, _ := consumer.Health()
+	assert.Equal(t, HealthIdle, state)
+	assert.Error(t, client.Ready())
+
+	consumer.Start(ctx, &channelHandler{messages: make(chan Message, 1)})
+	// the consumer is healthy once it joined the group, even without records
+	assert.Eventually(t, func() bool {
+		return client.Ready() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	consumer.setHealth(HealthUnhealthy, assert.AnError)
+	assert.ErrorIs(t, consumer.Ready(), assert.AnError)
+
+	require.NoError(t, consumer.Stop(ctx))
+	state, _ = consumer.Health()
+	assert.Equal(t, HealthStopped, state)
+}
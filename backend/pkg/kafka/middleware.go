@@ -0,0 +1,161 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	otelUtil "github.com/kartpop/cruncan/backend/pkg/otel"
+	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ProcessDurationMeterName is the name of the histogram of the handling duration in seconds
+	ProcessDurationMeterName = "kafka.consumer.process.duration"
+	// HandledMeterName is the name of the counter of messages that were handled successfully
+	HandledMeterName = "kafka.consumer.messages.handled"
+	// FailedMeterName is the name of the counter of messages whose handler failed
+	FailedMeterName = "kafka.consumer.messages.failed"
+)
+
+// Middleware wraps a ConsumerHandler with behaviour around the handling of each message
+type Middleware func(ConsumerHandler) ConsumerHandler
+
+// ConsumerHandlerFunc adapts a function to a ConsumerHandler
+type ConsumerHandlerFunc func(ctx context.Context, message Message) error
+
+func (f ConsumerHandlerFunc) Handle(ctx context.Context, message Message) error {
+	return f(ctx, message)
+}
+
+// Chain wraps the handler with the middlewares, the first middleware is the outermost one
+func Chain(handler ConsumerHandler, middlewares ...Middleware) ConsumerHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// WithMiddleware wraps the handler passed to Start with the middlewares, see Chain
+func WithMiddleware(middlewares ...Middleware) ConsumerOption {
+	return func(c *Consumer) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// Recover turns a panic of the handler into a non-retriable error, so a bug in the handler sends the message
+// to the dead letter topic instead of crashing the service
+func Recover() Middleware {
+	return func(next ConsumerHandler) ConsumerHandler {
+		return ConsumerHandlerFunc(func(ctx context.Context, message Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					slog.ErrorContext(ctx, fmt.Sprintf("panic handling message from %s: %v\n%s", message.Topic, r, debug.Stack()))
+					err = NonRetriable(fmt.Errorf("panic handling message from %s: %v", message.Topic, r))
+				}
+			}()
+			return next.Handle(ctx, message)
+		})
+	}
+}
+
+// Tracing runs the handler inside a process span, a child of the consumer's deliver span, with the
+// status set from the handler's error
+func Tracing() Middleware {
+	return func(next ConsumerHandler) ConsumerHandler {
+		return ConsumerHandlerFunc(func(ctx context.Context, message Message) error {
+			tracer, _ := otelContext.Tracer(ctx)
+			ctx, span := tracer.Start(ctx, message.Topic+" process",
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(
+					semconv.MessagingSystemKafka,
+					// semconv v1.24 has no process operation yet, deliver is taken by the consumer's span
+					semconv.MessagingOperationKey.String("process"),
+					semconv.MessagingDestinationName(message.Topic),
+					semconv.MessagingKafkaDestinationPartition(int(message.Partition)),
+					semconv.MessagingKafkaMessageOffset(int(message.Offset)),
+				),
+			)
+			defer span.End()
+
+			err := next.Handle(ctx, message)
+			otelUtil.SetAutoSpanStatus(span, err)
+			return err
+		})
+	}
+}
+
+// Metrics records the handling duration and counts the handled and failed messages per topic
+// with the meter of the context
+func Metrics(ctx context.Context) (Middleware, error) {
+	meter, _ := otelContext.Meter(ctx)
+	duration, err := meter.Float64Histogram(ProcessDurationMeterName, metric.WithUnit("s"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create histogram %q: %w", ProcessDurationMeterName, err)
+	}
+	handled, err := meter.Int64Counter(HandledMeterName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create counter %q: %w", HandledMeterName, err)
+	}
+	failed, err := meter.Int64Counter(FailedMeterName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create counter %q: %w", FailedMeterName, err)
+	}
+
+	return func(next ConsumerHandler) ConsumerHandler {
+		return ConsumerHandlerFunc(func(ctx context.Context, message Message) error {
+			start := time.Now()
+			err := next.Handle(ctx, message)
+
+			topic := semconv.MessagingDestinationName(message.Topic)
+			duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(topic, attribute.Bool("error", err != nil)))
+			if err != nil {
+				failed.Add(ctx, 1, metric.WithAttributes(topic))
+			} else {
+				handled.Add(ctx, 1, metric.WithAttributes(topic))
+			}
+			return err
+		})
+	}, nil
+}
+
+// Timeout cancels the context of the handler once the timeout has passed, the message then fails with the
+// handler's error and is retried. A timeout of zero leaves the handler without a deadline.
+func Timeout(timeout time.Duration) Middleware {
+	return func(next ConsumerHandler) ConsumerHandler {
+		if timeout <= 0 {
+			return next
+		}
+		return ConsumerHandlerFunc(func(ctx context.Context, message Message) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next.Handle(ctx, message)
+		})
+	}
+}
+
+// Logging adds the topic, partition and offset of the message to the logs written with the handler's context
+// and logs the errors of the handler
+func Logging() Middleware {
+	return func(next ConsumerHandler) ConsumerHandler {
+		return ConsumerHandlerFunc(func(ctx context.Context, message Message) error {
+			ctx = otelContext.AddSlogAttributes(ctx,
+				slog.String(string(semconv.MessagingDestinationNameKey), message.Topic),
+				slog.Int(string(semconv.MessagingKafkaDestinationPartitionKey), int(message.Partition)),
+				slog.Int64(string(semconv.MessagingKafkaMessageOffsetKey), message.Offset),
+			)
+
+			err := next.Handle(ctx, message)
+			if err != nil {
+				slog.ErrorContext(ctx, fmt.Sprintf("failed to handle message from %s: %v", message.Topic, err))
+			}
+			return err
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+var testMessage = Message{Topic: "topic-a", Partition: 2, Offset: 42}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next ConsumerHandler) ConsumerHandler {
+			return ConsumerHandlerFunc(func(ctx context.Context, message Message) error {
+				calls = append(calls, name)
+				return next.Handle(ctx, message)
+			})
+		}
+	}
+	handler := Chain(ConsumerHandlerFunc(func(context.Context, Message) error {
+		calls = append(calls, "handler")
+		return nil
+	}), record("outer"), record("inner"))
+
+	require.NoError(t, handler.Handle(context.Background(), testMessage))
+	assert.Equal(t, []string{"outer", "inner", "handler"}, calls)
+}
+
+func TestRecover(t *testing.T) {
+	handler := Recover()(ConsumerHandlerFunc(func(context.Context, Message) error {
+		panic("boom")
+	}))
+
+	err := handler.Handle(context.Background(), testMessage)
+	assert.ErrorContains(t, err, "boom")
+	assert.True(t, IsNonRetriable(err))
+}
+
+func TestTimeout(t *testing.T) {
+	handler := Timeout(10 * time.Millisecond)(ConsumerHandlerFunc(func(ctx context.Context, _ Message) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+
+	assert.ErrorIs(t, handler.Handle(context.Background(), testMessage), context.DeadlineExceeded)
+
+	noDeadline := Timeout(0)(ConsumerHandlerFunc(func(ctx context.Context, _ Message) error {
+		_, ok := ctx.Deadline()
+		assert.False(t, ok)
+		return nil
+	}))
+	assert.NoError(t, noDeadline.Handle(context.Background(), testMessage))
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx := otelContext.WithTracer(context.Background(), tp.Tracer("test"))
+	handlerErr := errors.New("failed")
+
+	handler := Tracing()(ConsumerHandlerFunc(func(context.Context, Message) error {
+		return handlerErr
+	}))
+	assert.ErrorIs(t, handler.Handle(ctx, testMessage), handlerErr)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "topic-a process", spans[0].Name())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Contains(t, spans[0].Attributes(), semconv.MessagingKafkaMessageOffset(42))
+}
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	ctx := otelContext.WithMeter(context.Background(), mp.Meter("test"))
+
+	metrics, err := Metrics(ctx)
+	require.NoError(t, err)
+	fail := true
+	handler := metrics(ConsumerHandlerFunc(func(context.Context, Message) error {
+		fail = !fail
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	}))
+	for i := 0; i < 3; i++ {
+		_ = handler.Handle(ctx, testMessage)
+	}
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &rm))
+	sums := map[string]int64{}
+	var durations uint64
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		switch data := m.Data.(type) {
+		case metricdata.Sum[int64]:
+			sums[m.Name] = data.DataPoints[0].Value
+		case metricdata.Histogram[float64]:
+			for _, dp := range data.DataPoints {
+				durations += dp.Count
+			}
+		}
+	}
+	assert.Equal(t, map[string]int64{HandledMeterName: 2, FailedMeterName: 1}, sums)
+	assert.Equal(t, uint64(3), durations)
+}
+
+func TestLogging(t *testing.T) {
+	handler := Logging()(ConsumerHandlerFunc(func(ctx context.Context, _ Message) error {
+		attrs, _ := otelContext.SlogAttributes(ctx)
+		values := map[string]string{}
+		for _, attr := range attrs {
+			values[attr.Key] = attr.Value.String()
+		}
+		assert.Equal(t, map[string]string{
+			"messaging.destination.name":            "topic-a",
+			"messaging.kafka.destination.partition": "2",
+			"messaging.kafka.message.offset":        "42",
+		}, values)
+		return nil
+	}))
+
+	assert.NoError(t, handler.Handle(context.Background(), testMessage))
+}
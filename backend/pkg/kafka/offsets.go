@@ -0,0 +1,213 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+)
+
+// ErrGroupActive is returned when the offsets of a consumer group with members are reset. The members would
+// overwrite the reset offsets with their next commit, so the consumers have to be stopped first.
+var ErrGroupActive = errors.New("consumer group has active members")
+
+// PartitionLag is the committed offset and lag of a consumer group on a partition
+type PartitionLag struct {
+	Topic     string
+	Partition int32
+	// Committed is the offset of the next record the group handles, -1 if the group never committed
+	Committed int64
+	// End is the offset of the next record produced to the partition
+	End int64
+	// Lag is the number of records between the committed offset and the end
+	Lag int64
+	// Member is the client ID of the member that consumes the partition, empty if the group has no members
+	Member string
+}
+
+// GroupLag lists the committed offsets and lag of a consumer group
+type GroupLag struct {
+	Group string
+	// State is the group state reported by the cluster, e.g. Empty, Stable or Dead for an unknown group
+	State      string
+	Partitions []PartitionLag
+}
+
+func (g *GroupLag) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "group %s (%s)\n", g.Group, g.State)
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tPARTITION\tCOMMITTED\tEND\tLAG\tMEMBER")
+	for _, p := range g.Partitions {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", p.Topic, p.Partition, p.Committed, p.End, p.Lag, p.Member)
+	}
+	_ = w.Flush()
+	return sb.String()
+}
+
+// GroupLag returns the committed offsets and lag of the group on every partition it committed to
+func (a *Admin) GroupLag(ctx context.Context, group string) (*GroupLag, error) {
+	lags, err := a.client.Lag(ctx, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe lag of group %s: %w", group, err)
+	}
+	described, ok := lags[group]
+	if !ok {
+		return nil, fmt.Errorf("group %s is missing in the lag response", group)
+	}
+	if err := described.Error(); err != nil {
+		return nil, fmt.Errorf("failed to describe lag of group %s: %w", group, err)
+	}
+
+	lag := &GroupLag{Group: group, State: described.State}
+	for _, l := range described.Lag.Sorted() {
+		if l.Err != nil {
+			return nil, fmt.Errorf("failed to describe lag of %s/%d: %w", l.Topic, l.Partition, l.Err)
+		}
+		p := PartitionLag{Topic: l.Topic, Partition: l.Partition, Committed: l.Commit.At, End: l.End.Offset, Lag: l.Lag}
+		if l.Member != nil {
+			p.Member = l.Member.ClientID
+		}
+		lag.Partitions = append(lag.Partitions, p)
+	}
+	return lag, nil
+}
+
+// StartOffsets returns the offset of the first record of every partition of the topic
+func (a *Admin) StartOffsets(ctx context.Context, topic string) (map[int32]int64, error) {
+	listed, err := a.client.ListStartOffsets(ctx, topic)
+	return partitionOffsets(topic, listed, err)
+}
+
+// EndOffsets returns the offset of the next record produced to every partition of the topic
+func (a *Admin) EndOffsets(ctx context.Context, topic string) (map[int32]int64, error) {
+	listed, err := a.client.ListEndOffsets(ctx, topic)
+	return partitionOffsets(topic, listed, err)
+}
+
+// OffsetsAt returns the offset of the first record at or after t of every partition of the topic, or the end
+// offset of partitions without such a record
+func (a *Admin) OffsetsAt(ctx context.Context, topic string, t time.Time) (map[int32]int64, error) {
+	listed, err := a.client.ListOffsetsAfterMilli(ctx, t.UnixMilli(), topic)
+	return partitionOffsets(topic, listed, err)
+}
+
+func partitionOffsets(topic string, listed kadm.ListedOffsets, err error) (map[int32]int64, error) {
+	if err == nil {
+		err = listed.Error()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets of topic %s: %w", topic, err)
+	}
+	offsets := make(map[int32]int64, len(listed[topic]))
+	for partition, o := range listed[topic] {
+		offsets[partition] = o.Offset
+	}
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("topic %s has no partitions", topic)
+	}
+	return offsets, nil
+}
+
+// OffsetChange is the move of the committed offset of a consumer group on a partition
+type OffsetChange struct {
+	Topic     string
+	Partition int32
+	// From is the committed offset before the reset, -1 if the group never committed
+	From int64
+	To   int64
+}
+
+// ResetReport lists the offsets ResetGroupOffsets changed, or would change on a dry run
+type ResetReport struct {
+	Group   string
+	DryRun  bool
+	Changes []OffsetChange
+}
+
+func (r *ResetReport) String() string {
+	var sb strings.Builder
+	action := "reset"
+	if r.DryRun {
+		action = "would reset"
+	}
+	for _, c := range r.Changes {
+		fmt.Fprintf(&sb, "%s %s %s/%d: %d -> %d\n", action, r.Group, c.Topic, c.Partition, c.From, c.To)
+	}
+	if sb.Len() == 0 {
+		return "offsets unchanged\n"
+	}
+	return sb.String()
+}
+
+// ResetGroupOffsets commits the offsets for the partitions of the topic, see OffsetsAt, StartOffsets and
+// EndOffsets, so the group continues from there when it is started again. Partitions that are not in offsets
+// keep their committed offset. The offsets must lie between the start and end of their partition. The group
+// must not have members, see ErrGroupActive. On a dry run the changes are only reported.
+func (a *Admin) ResetGroupOffsets(ctx context.Context, group, topic string, offsets map[int32]int64, dryRun bool) (*ResetReport, error) {
+	described, err := a.client.DescribeGroups(ctx, group)
+	if err == nil {
+		err = described.Error()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe group %s: %w", group, err)
+	}
+	if d := described[group]; len(d.Members) > 0 {
+		return nil, fmt.Errorf("%w: %s has %d members in state %s", ErrGroupActive, group, len(d.Members), d.State)
+	}
+
+	start, err := a.StartOffsets(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	end, err := a.EndOffsets(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	committed, err := a.client.FetchOffsetsForTopics(ctx, group, topic)
+	if err == nil {
+		err = committed.Error()
+	}
+	if errors.Is(err, kerr.GroupIDNotFound) {
+		// the group never committed, e.g. because it is set up before its consumers first start
+		committed, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch offsets of group %s: %w", group, err)
+	}
+
+	report := &ResetReport{Group: group, DryRun: dryRun}
+	commit := make(kadm.Offsets)
+	for partition, offset := range offsets {
+		if _, ok := end[partition]; !ok {
+			return nil, fmt.Errorf("topic %s has no partition %d", topic, partition)
+		}
+		if offset < start[partition] || offset > end[partition] {
+			return nil, fmt.Errorf("offset %d of %s/%d is outside of [%d, %d]", offset, topic, partition, start[partition], end[partition])
+		}
+		from := int64(-1)
+		if c, ok := committed.Lookup(topic, partition); ok && c.Err == nil {
+			from = c.At
+		}
+		if from == offset {
+			continue
+		}
+		report.Changes = append(report.Changes, OffsetChange{Topic: topic, Partition: partition, From: from, To: offset})
+		commit.Add(kadm.Offset{Topic: topic, Partition: partition, At: offset, LeaderEpoch: -1})
+	}
+	sort.Slice(report.Changes, func(i, j int) bool { return report.Changes[i].Partition < report.Changes[j].Partition })
+
+	if dryRun || len(report.Changes) == 0 {
+		return report, nil
+	}
+	if err := a.client.CommitAllOffsets(ctx, group, commit); err != nil {
+		return nil, fmt.Errorf("failed to commit offsets of group %s: %w", group, err)
+	}
+	return report, nil
+}
@@ -0,0 +1,93 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestResetGroupOffsets(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, kadmClient := newTestCluster(t, 2, "topic-a")
+	defer client.Close(ctx)
+	admin := client.NewAdmin()
+
+	// three records an hour apart on partition 0, one on partition 1
+	base := time.Now().Add(-3 * time.Hour).Truncate(time.Millisecond)
+	var messages []Message
+	for i := 0; i < 3; i++ {
+		messages = append(messages, Message{Value: []byte("a"), Partition: 0, Timestamp: base.Add(time.Duration(i) * time.Hour)})
+	}
+	produceToPartitions(ctx, t, client, "topic-a", append(messages, Message{Value: []byte("b"), Partition: 1, Timestamp: base})...)
+
+	require.NoError(t, kadmClient.CommitAllOffsets(ctx, "group-a", kadm.Offsets{
+		"topic-a": {0: {Topic: "topic-a", Partition: 0, At: 3, LeaderEpoch: -1}},
+	}))
+
+	lag, err := admin.GroupLag(ctx, "group-a")
+	require.NoError(t, err)
+	assert.Equal(t, "Empty", lag.State)
+	assert.Contains(t, lag.Partitions, PartitionLag{Topic: "topic-a", Partition: 0, Committed: 3, End: 3, Lag: 0})
+
+	offsets, err := admin.OffsetsAt(ctx, "topic-a", base.Add(90*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, map[int32]int64{0: 2, 1: 1}, offsets)
+
+	// a dry run only reports the changes
+	report, err := admin.ResetGroupOffsets(ctx, "group-a", "topic-a", offsets, true)
+	require.NoError(t, err)
+	assert.Equal(t, []OffsetChange{
+		{Topic: "topic-a", Partition: 0, From: 3, To: 2},
+		{Topic: "topic-a", Partition: 1, From: -1, To: 1},
+	}, report.Changes)
+	assert.Equal(t, "would reset group-a topic-a/0: 3 -> 2\nwould reset group-a topic-a/1: -1 -> 1\n", report.String())
+	committed, err := kadmClient.FetchOffsets(ctx, "group-a")
+	require.NoError(t, err)
+	c, _ := committed.Lookup("topic-a", 0)
+	assert.Equal(t, int64(3), c.At)
+
+	report, err = admin.ResetGroupOffsets(ctx, "group-a", "topic-a", map[int32]int64{0: 1}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []OffsetChange{{Topic: "topic-a", Partition: 0, From: 3, To: 1}}, report.Changes)
+	lag, err = admin.GroupLag(ctx, "group-a")
+	require.NoError(t, err)
+	assert.Contains(t, lag.Partitions, PartitionLag{Topic: "topic-a", Partition: 0, Committed: 1, End: 3, Lag: 2})
+
+	// the offsets of a new group are set before its consumers first start
+	report, err = admin.ResetGroupOffsets(ctx, "group-b", "topic-a", map[int32]int64{1: 1}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []OffsetChange{{Topic: "topic-a", Partition: 1, From: -1, To: 1}}, report.Changes)
+
+	_, err = admin.ResetGroupOffsets(ctx, "group-a", "topic-a", map[int32]int64{0: 4}, false)
+	assert.ErrorContains(t, err, "outside of [0, 3]")
+	_, err = admin.ResetGroupOffsets(ctx, "group-a", "topic-a", map[int32]int64{2: 0}, false)
+	assert.ErrorContains(t, err, "no partition 2")
+
+	// the members of an active group would overwrite the offsets
+	consumer, err := client.NewConsumer("topic-a")
+	require.NoError(t, err)
+	handler := &channelHandler{messages: make(chan Message, 10)}
+	consumer.Start(ctx, handler)
+	<-handler.messages
+	_, err = admin.ResetGroupOffsets(ctx, "group-a", "topic-a", map[int32]int64{0: 0}, true)
+	assert.ErrorIs(t, err, ErrGroupActive)
+}
+
+// produceToPartitions sends each message to its partition
+func produceToPartitions(ctx context.Context, t *testing.T, client *Client, topic string, messages ...Message) {
+	producer, err := kgo.NewClient(concatOpts(client.consumerOpts, []kgo.Opt{kgo.RecordPartitioner(kgo.ManualPartitioner())})...)
+	require.NoError(t, err)
+	defer producer.Close()
+
+	for _, message := range messages {
+		record := message.toRecord(topic)
+		record.Partition = message.Partition
+		require.NoError(t, producer.ProduceSync(ctx, record).FirstErr())
+	}
+}
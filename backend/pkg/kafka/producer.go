@@ -17,23 +17,56 @@ func NewProducer(client *kgo.Client, topic string) *Producer {
 	return &Producer{client: client, topic: topic}
 }
 
-// SendMessage sends a message to the kafka topic and returns an error if any
+// SendMessage sends a message to the kafka topic and returns an error if any.
+// The trace context of ctx is propagated to the consumers in the record headers.
 func (p *Producer) SendMessage(ctx context.Context, message []byte) error {
+	return p.Send(ctx, Message{Value: message}).FirstErr()
+}
+
+// Send sends the messages as one batch and waits until all of them were acknowledged or failed.
+// The results are in the order of the messages, successfully sent messages have their partition,
+// offset and timestamp set. The trace context of ctx is propagated in the record headers.
+func (p *Producer) Send(ctx context.Context, messages ...Message) SendResults {
+	results := make(SendResults, len(messages))
+
 	var wg sync.WaitGroup
-	wg.Add(1)
-	var produceErr error
-	p.client.Produce(ctx, &kgo.Record{Topic: p.topic, Value: message}, func(_ *kgo.Record, err error) {
-		defer wg.Done()
+	wg.Add(len(messages))
+	for i, msg := range messages {
+		i := i
+		p.SendAsync(ctx, msg, func(sent Message, err error) {
+			defer wg.Done()
+			results[i] = SendResult{Message: sent, Err: err}
+		})
+	}
+	wg.Wait()
+
+	return results
+}
+
+// SendAsync sends the message without waiting for it to be acknowledged. The callback is called with the
+// sent message, or the error if sending failed, and must not block since it runs on the client's
+// produce goroutine. The trace context of ctx is propagated in the record headers.
+func (p *Producer) SendAsync(ctx context.Context, message Message, callback func(Message, error)) {
+	record := message.toRecord(p.topic)
+	ctx, span := startProducerSpan(ctx, record)
+
+	p.client.Produce(ctx, record, func(r *kgo.Record, err error) {
+		endProducerSpan(span, r, err)
+		if callback == nil {
+			return
+		}
 		if err != nil {
-			produceErr = err
+			callback(message, err)
+			return
 		}
+		sent := message
+		sent.Topic, sent.Timestamp, sent.Partition, sent.Offset = r.Topic, r.Timestamp, r.Partition, r.Offset
+		callback(sent, nil)
 	})
-	wg.Wait()
-
-	return produceErr
 }
 
-// Close closes the kafka producer
-func (p *Producer) Close() {
-	p.client.Close()
+// Flush waits until all messages sent on the producer's client were acknowledged or failed.
+// The client is shared, see Client, so it is not closed by the producer.
+func (p *Producer) Flush(ctx context.Context) error {
+	return p.client.Flush(ctx)
 }
@@ -0,0 +1,241 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Range selects the records of a topic that were written from Start, inclusive, to End, exclusive. A zero
+// Start reads from the start of the partitions and a zero End up to their end when the read starts.
+type Range struct {
+	Topic string
+	Start time.Time
+	End   time.Time
+	// Partitions restricts the range to some partitions, all partitions are read if empty
+	Partitions []int32
+}
+
+// readIdleCheck is how long Read waits for records before it checks whether the rest of the range is gone
+const readIdleCheck = 500 * time.Millisecond
+
+// Read calls fn with the records of the range, in order within each partition, and stops at the first error
+// of fn. Records are read without a consumer group, so no offsets are committed. Records produced after
+// Read started are not read, even if they fall into the range.
+//
+// A partition is done once its fetch position reaches the end of the range, which is the high watermark or
+// last stable offset when Range.End is zero. Offsets without a record at the end of the range, such as
+// transaction markers or records deleted by retention while reading, do not keep Read waiting.
+func (c *Client) Read(ctx context.Context, r Range, fn func(Message) error) error {
+	bounds, err := c.rangeBounds(ctx, r)
+	if err != nil {
+		return err
+	}
+	if len(bounds) == 0 {
+		return nil
+	}
+
+	partitions := make(map[int32]kgo.Offset, len(bounds))
+	positions := make(map[int32]int64, len(bounds))
+	for partition, b := range bounds {
+		partitions[partition] = kgo.NewOffset().At(b[0])
+		positions[partition] = b[0]
+	}
+	client, err := kgo.NewClient(concatOpts(c.consumerOpts, []kgo.Opt{
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{r.Topic: partitions}),
+		// control records move the position past transaction markers, they are not passed to fn
+		kgo.KeepControlRecords(),
+	})...)
+	if err != nil {
+		return fmt.Errorf("failed to create kafka reader: %w", err)
+	}
+	defer client.Close()
+
+	// finish stops reading the partitions whose position reached the end of their range
+	finish := func() {
+		for partition, b := range bounds {
+			if positions[partition] >= b[1] {
+				delete(bounds, partition)
+				client.PauseFetchPartitions(map[string][]int32{r.Topic: {partition}})
+			}
+		}
+	}
+
+	for len(bounds) > 0 {
+		pollCtx, cancel := context.WithTimeout(ctx, readIdleCheck)
+		fetches := client.PollFetches(pollCtx)
+		cancel()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fetches.Err(); errors.Is(err, context.DeadlineExceeded) {
+			// nothing was fetched, records that retention deleted are never fetched
+			start, err := c.NewAdmin().StartOffsets(ctx, r.Topic)
+			if err != nil {
+				return err
+			}
+			for partition := range bounds {
+				positions[partition] = max(positions[partition], start[partition])
+			}
+			finish()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to read topic %s: %w", r.Topic, err)
+		}
+
+		var fnErr error
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			b, ok := bounds[p.Partition]
+			if fnErr != nil || !ok {
+				return
+			}
+			for _, record := range p.Records {
+				if record.Offset >= b[1] {
+					positions[p.Partition] = b[1]
+					break
+				}
+				positions[p.Partition] = record.Offset + 1
+				if record.Attrs.IsControl() {
+					continue
+				}
+				if err := fn(messageFromRecord(record, record.Topic)); err != nil {
+					fnErr = err
+					return
+				}
+			}
+		})
+		if fnErr != nil {
+			return fnErr
+		}
+		finish()
+	}
+	return nil
+}
+
+// rangeBounds returns the first and the end offset of each partition of the range that has records in it
+func (c *Client) rangeBounds(ctx context.Context, r Range) (map[int32][2]int64, error) {
+	admin := c.NewAdmin()
+	var start, end map[int32]int64
+	var err error
+	if r.Start.IsZero() {
+		start, err = admin.StartOffsets(ctx, r.Topic)
+	} else {
+		start, err = admin.OffsetsAt(ctx, r.Topic, r.Start)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r.End.IsZero() {
+		end, err = admin.EndOffsets(ctx, r.Topic)
+	} else {
+		end, err = admin.OffsetsAt(ctx, r.Topic, r.End)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := r.Partitions
+	if len(partitions) == 0 {
+		for partition := range end {
+			partitions = append(partitions, partition)
+		}
+	}
+	bounds := make(map[int32][2]int64, len(partitions))
+	for _, partition := range partitions {
+		e, ok := end[partition]
+		if !ok {
+			return nil, fmt.Errorf("topic %s has no partition %d", r.Topic, partition)
+		}
+		if s := start[partition]; s < e {
+			bounds[partition] = [2]int64{s, e}
+		}
+	}
+	return bounds, nil
+}
+
+// ReplayReport counts the records Replay copied, or would copy on a dry run, by partition of the source
+type ReplayReport struct {
+	From    string
+	To      string
+	DryRun  bool
+	Records map[int32]int
+}
+
+func (r *ReplayReport) String() string {
+	action := "replayed"
+	if r.DryRun {
+		action = "would replay"
+	}
+	partitions := make([]int32, 0, len(r.Records))
+	total := 0
+	for partition, n := range r.Records {
+		partitions = append(partitions, partition)
+		total += n
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	var sb strings.Builder
+	for _, partition := range partitions {
+		fmt.Fprintf(&sb, "%s %d records from %s/%d to %s\n", action, r.Records[partition], r.From, partition, r.To)
+	}
+	fmt.Fprintf(&sb, "%s %d records from %s to %s\n", action, total, r.From, r.To)
+	return sb.String()
+}
+
+// Replay copies the records of the range to the topic, e.g. from a dead letter topic back to the main topic
+// once the cause of the failures is fixed. Records keep their key and headers, except the retry attempt and
+// last error, so a replayed record goes through the retry tiers again if it fails. On a dry run the records
+// are only counted.
+func (c *Client) Replay(ctx context.Context, r Range, to string, dryRun bool) (*ReplayReport, error) {
+	report := &ReplayReport{From: r.Topic, To: to, DryRun: dryRun, Records: map[int32]int{}}
+	producer := c.NewProducer(to)
+
+	var mu sync.Mutex
+	var sendErr error
+	failed := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return sendErr
+	}
+
+	err := c.Read(ctx, r, func(message Message) error {
+		if err := failed(); err != nil {
+			return err
+		}
+		report.Records[message.Partition]++
+		if dryRun {
+			return nil
+		}
+		replayed := Message{Key: message.Key, Value: message.Value}
+		for _, h := range message.Headers {
+			if h.Key != HeaderAttempt && h.Key != HeaderLastError {
+				replayed.Headers = append(replayed.Headers, h)
+			}
+		}
+		source := fmt.Sprintf("%s/%d@%d", r.Topic, message.Partition, message.Offset)
+		producer.SendAsync(ctx, replayed, func(_ Message, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && sendErr == nil {
+				sendErr = fmt.Errorf("failed to replay %s: %w", source, err)
+			}
+		})
+		return nil
+	})
+	if dryRun {
+		return report, err
+	}
+	if flushErr := producer.Flush(ctx); err == nil {
+		err = flushErr
+	}
+	if err == nil {
+		err = failed()
+	}
+	return report, err
+}
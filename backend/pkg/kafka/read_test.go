@@ -0,0 +1,159 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func TestRead(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, _ := newTestCluster(t, 2, "topic-a")
+	defer client.Close(ctx)
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+	produceToPartitions(ctx, t, client, "topic-a",
+		Message{Value: []byte("0-0"), Partition: 0, Timestamp: at(0)},
+		Message{Value: []byte("0-10"), Partition: 0, Timestamp: at(10)},
+		Message{Value: []byte("0-20"), Partition: 0, Timestamp: at(20)},
+		Message{Value: []byte("1-5"), Partition: 1, Timestamp: at(5)},
+		Message{Value: []byte("1-15"), Partition: 1, Timestamp: at(15)},
+	)
+
+	tests := []struct {
+		name   string
+		r      Range
+		values []string
+	}{
+		{
+			name:   "all",
+			r:      Range{Topic: "topic-a"},
+			values: []string{"0-0", "0-10", "0-20", "1-5", "1-15"},
+		},
+		{
+			name:   "time range",
+			r:      Range{Topic: "topic-a", Start: at(5), End: at(20)},
+			values: []string{"0-10", "1-5", "1-15"},
+		},
+		{
+			name:   "partition",
+			r:      Range{Topic: "topic-a", Start: at(10), Partitions: []int32{0}},
+			values: []string{"0-10", "0-20"},
+		},
+		{
+			name: "empty range",
+			r:    Range{Topic: "topic-a", Start: at(30)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var values []string
+			err := client.Read(ctx, tt.r, func(message Message) error {
+				values = append(values, string(message.Value))
+				return nil
+			})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.values, values)
+		})
+	}
+
+	stop := errors.New("stop")
+	err := client.Read(ctx, Range{Topic: "topic-a"}, func(Message) error { return stop })
+	assert.ErrorIs(t, err, stop)
+	err = client.Read(ctx, Range{Topic: "topic-a", Partitions: []int32{2}}, func(Message) error { return nil })
+	assert.ErrorContains(t, err, "no partition 2")
+}
+
+func TestReadGapAtTheEnd(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(2, "topic-a"))
+	require.NoError(t, err)
+	defer cluster.Close()
+	client, err := NewClient(ctx, &Config{BootstrapServers: cluster.ListenAddrs(), GroupId: "group-a", AutoOffsetReset: "earliest"})
+	require.NoError(t, err)
+	defer client.Close(ctx)
+	admin := kadm.NewClient(client.producer)
+
+	produceToPartitions(ctx, t, client, "topic-a",
+		Message{Value: []byte("0-0"), Partition: 0},
+		Message{Value: []byte("0-1"), Partition: 0},
+		Message{Value: []byte("1-0"), Partition: 1},
+		Message{Value: []byte("1-1"), Partition: 1},
+	)
+
+	// retention deletes the records of partition 1 after Read listed the end offsets, so no record at the
+	// end of its range is ever fetched
+	cluster.ControlKey(int16(kmsg.Fetch), func(kmsg.Request) (kmsg.Response, error, bool) {
+		cluster.SleepControl(func() {
+			offsets := kadm.Offsets{}
+			offsets.Add(kadm.Offset{Topic: "topic-a", Partition: 1, At: 2})
+			_, err := admin.DeleteRecords(ctx, offsets)
+			assert.NoError(t, err)
+		})
+		return nil, nil, false
+	})
+
+	var values []string
+	err = client.Read(ctx, Range{Topic: "topic-a"}, func(message Message) error {
+		values = append(values, string(message.Value))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"0-0", "0-1"}, values)
+}
+
+func TestReplay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, _ := newTestCluster(t, 1, "topic-a", "topic-a.dlq")
+	defer client.Close(ctx)
+
+	headers := []Header{
+		{Key: HeaderMessageID, Value: []byte("id-1")},
+		{Key: HeaderAttempt, Value: []byte("2")},
+		{Key: HeaderOriginalTopic, Value: []byte("topic-a")},
+		{Key: HeaderLastError, Value: []byte("three is down")},
+	}
+	require.NoError(t, client.NewProducer("topic-a.dlq").Send(ctx,
+		Message{Key: []byte("req-1"), Value: []byte("first"), Headers: headers},
+		Message{Key: []byte("req-2"), Value: []byte("second")},
+	).FirstErr())
+
+	report, err := client.Replay(ctx, Range{Topic: "topic-a.dlq"}, "topic-a", true)
+	require.NoError(t, err)
+	assert.Equal(t, "would replay 2 records from topic-a.dlq/0 to topic-a\nwould replay 2 records from topic-a.dlq to topic-a\n", report.String())
+	end, err := client.NewAdmin().EndOffsets(ctx, "topic-a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), end[0])
+
+	report, err = client.Replay(ctx, Range{Topic: "topic-a.dlq"}, "topic-a", false)
+	require.NoError(t, err)
+	assert.Equal(t, map[int32]int{0: 2}, report.Records)
+
+	var replayed []Message
+	require.NoError(t, client.Read(ctx, Range{Topic: "topic-a"}, func(message Message) error {
+		replayed = append(replayed, message)
+		return nil
+	}))
+	require.Len(t, replayed, 2)
+	assert.Equal(t, "req-1", string(replayed[0].Key))
+	assert.Equal(t, "first", string(replayed[0].Value))
+	id, _ := replayed[0].Header(HeaderMessageID)
+	assert.Equal(t, "id-1", string(id))
+	original, _ := replayed[0].Header(HeaderOriginalTopic)
+	assert.Equal(t, "topic-a", string(original))
+	_, ok := replayed[0].Header(HeaderAttempt)
+	assert.False(t, ok)
+	_, ok = replayed[0].Header(HeaderLastError)
+	assert.False(t, ok)
+}
@@ -0,0 +1,154 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Headers set on records forwarded to a retry tier or dead letter topic
+const (
+	// HeaderAttempt is the number of failed handling attempts
+	HeaderAttempt = "x-retry-attempt"
+	// HeaderOriginalTopic is the topic the record was first consumed from
+	HeaderOriginalTopic = "x-original-topic"
+	// HeaderOriginalPartition is the partition the record was first consumed from
+	HeaderOriginalPartition = "x-original-partition"
+	// HeaderOriginalOffset is the offset the record was first consumed from
+	HeaderOriginalOffset = "x-original-offset"
+	// HeaderLastError is the error returned by the last failed handling attempt
+	HeaderLastError = "x-last-error"
+)
+
+type nonRetriableError struct {
+	err error
+}
+
+func (e *nonRetriableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nonRetriableError) Unwrap() error {
+	return e.err
+}
+
+// NonRetriable marks the error as non-retriable. A record whose handler fails with a non-retriable
+// error skips the remaining retry tiers and goes straight to the dead letter topic.
+func NonRetriable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &nonRetriableError{err: err}
+}
+
+// IsNonRetriable reports whether any error in the chain was marked with NonRetriable
+func IsNonRetriable(err error) bool {
+	var nrErr *nonRetriableError
+	return errors.As(err, &nrErr)
+}
+
+// retryPolicy routes records that failed handling through the retry tiers and dead letter topic of a topic
+type retryPolicy struct {
+	topic Topic
+	tiers map[string]int
+}
+
+func newRetryPolicy(topic Topic) *retryPolicy {
+	tiers := make(map[string]int, len(topic.RetryDelays))
+	for i, delay := range topic.RetryDelays {
+		tiers[topic.RetryTopic(delay)] = i + 1
+	}
+	return &retryPolicy{topic: topic, tiers: tiers}
+}
+
+// retryTopics returns the names of the retry tier topics, which are consumed along with the main topic
+func (p *retryPolicy) retryTopics() []string {
+	names := make([]string, 0, len(p.topic.RetryDelays))
+	for _, delay := range p.topic.RetryDelays {
+		names = append(names, p.topic.RetryTopic(delay))
+	}
+	return names
+}
+
+// tier returns the retry tier of the topic, the main topic is tier 0
+func (p *retryPolicy) tier(topic string) int {
+	return p.tiers[topic]
+}
+
+// due returns the time at which a record of the tier may be handled
+func (p *retryPolicy) due(record *kgo.Record, tier int) time.Time {
+	if tier == 0 {
+		return record.Timestamp
+	}
+	return record.Timestamp.Add(p.topic.RetryDelays[tier-1])
+}
+
+// next returns the topic that a record which failed on the tier with err is forwarded to,
+// or an empty string if the record is dropped
+func (p *retryPolicy) next(tier int, err error) string {
+	if !IsNonRetriable(err) && tier < len(p.topic.RetryDelays) {
+		return p.topic.RetryTopic(p.topic.RetryDelays[tier])
+	}
+	if p.topic.DeadLetter {
+		return p.topic.DeadLetterTopic()
+	}
+	return ""
+}
+
+// forwardRecord creates the record that is forwarded to the topic after the record failed with err.
+// The original topic, partition and offset are kept from the first failure.
+func forwardRecord(record *kgo.Record, topic string, err error) *kgo.Record {
+	fwd := &kgo.Record{
+		Topic:   topic,
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: append([]kgo.RecordHeader(nil), record.Headers...),
+	}
+
+	carrier := NewRecordCarrier(fwd)
+	attempt, _ := strconv.Atoi(carrier.Get(HeaderAttempt))
+	carrier.Set(HeaderAttempt, strconv.Itoa(attempt+1))
+	if carrier.Get(HeaderOriginalTopic) == "" {
+		carrier.Set(HeaderOriginalTopic, record.Topic)
+		carrier.Set(HeaderOriginalPartition, strconv.Itoa(int(record.Partition)))
+		carrier.Set(HeaderOriginalOffset, strconv.FormatInt(record.Offset, 10))
+	}
+	carrier.Set(HeaderLastError, err.Error())
+
+	return fwd
+}
+
+// Backoff between the attempts of a message that is retried in place, without retry topics
+const (
+	MinRetryBackoff = 100 * time.Millisecond
+	MaxRetryBackoff = 30 * time.Second
+)
+
+// RetryBackoff returns the backoff after the failed attempt, doubling from MinRetryBackoff up to MaxRetryBackoff
+func RetryBackoff(attempt int) time.Duration {
+	backoff := MinRetryBackoff
+	for i := 1; i < attempt && backoff < MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, MaxRetryBackoff)
+}
+
+// sleepUntil blocks until t or until the context is done, and reports whether t was reached
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
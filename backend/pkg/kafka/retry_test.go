@@ -0,0 +1,137 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestTopicNames(t *testing.T) {
+	topic := Topic{
+		Name:        "one-request",
+		RetryDelays: []time.Duration{30 * time.Second, time.Minute, 10 * time.Minute, 2 * time.Hour, 1500 * time.Millisecond},
+		DeadLetter:  true,
+	}
+
+	assert.Equal(t, []string{
+		"one-request",
+		"one-request.retry.30s",
+		"one-request.retry.1m",
+		"one-request.retry.10m",
+		"one-request.retry.2h",
+		"one-request.retry.1500ms",
+		"one-request.dlq",
+	}, topic.TopicNames())
+}
+
+func TestRetryPolicyNext(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name     string
+		topic    Topic
+		tier     int
+		err      error
+		expected string
+	}{
+		{
+			name:     "main topic goes to first retry tier",
+			topic:    Topic{Name: "t", RetryDelays: []time.Duration{time.Minute, 10 * time.Minute}, DeadLetter: true},
+			tier:     0,
+			err:      errFailed,
+			expected: "t.retry.1m",
+		},
+		{
+			name:     "retry tier goes to next retry tier",
+			topic:    Topic{Name: "t", RetryDelays: []time.Duration{time.Minute, 10 * time.Minute}, DeadLetter: true},
+			tier:     1,
+			err:      errFailed,
+			expected: "t.retry.10m",
+		},
+		{
+			name:     "last retry tier goes to dead letter topic",
+			topic:    Topic{Name: "t", RetryDelays: []time.Duration{time.Minute, 10 * time.Minute}, DeadLetter: true},
+			tier:     2,
+			err:      errFailed,
+			expected: "t.dlq",
+		},
+		{
+			name:     "non-retriable error goes straight to dead letter topic",
+			topic:    Topic{Name: "t", RetryDelays: []time.Duration{time.Minute}, DeadLetter: true},
+			tier:     0,
+			err:      fmt.Errorf("wrapped: %w", NonRetriable(errFailed)),
+			expected: "t.dlq",
+		},
+		{
+			name:     "dropped without dead letter topic",
+			topic:    Topic{Name: "t", RetryDelays: []time.Duration{time.Minute}},
+			tier:     1,
+			err:      errFailed,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, newRetryPolicy(tt.topic).next(tt.tier, tt.err))
+		})
+	}
+}
+
+func TestRetryPolicyDue(t *testing.T) {
+	policy := newRetryPolicy(Topic{Name: "t", RetryDelays: []time.Duration{time.Minute}})
+	produced := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 1, policy.tier("t.retry.1m"))
+	assert.Equal(t, 0, policy.tier("t"))
+	assert.Equal(t, produced, policy.due(&kgo.Record{Timestamp: produced}, 0))
+	assert.Equal(t, produced.Add(time.Minute), policy.due(&kgo.Record{Timestamp: produced}, 1))
+}
+
+func TestForwardRecord(t *testing.T) {
+	record := &kgo.Record{
+		Topic:     "t",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+		Headers:   []kgo.RecordHeader{{Key: "traceparent", Value: []byte("tp")}},
+	}
+
+	first := forwardRecord(record, "t.retry.1m", errors.New("first"))
+	first.Partition, first.Offset = 0, 7
+	second := forwardRecord(first, "t.dlq", errors.New("second"))
+
+	carrier := NewRecordCarrier(second)
+	assert.Equal(t, "t.dlq", second.Topic)
+	assert.Equal(t, []byte("key"), second.Key)
+	assert.Equal(t, []byte("value"), second.Value)
+	assert.Equal(t, "tp", carrier.Get("traceparent"))
+	assert.Equal(t, "2", carrier.Get(HeaderAttempt))
+	assert.Equal(t, "t", carrier.Get(HeaderOriginalTopic))
+	assert.Equal(t, "3", carrier.Get(HeaderOriginalPartition))
+	assert.Equal(t, "42", carrier.Get(HeaderOriginalOffset))
+	assert.Equal(t, "second", carrier.Get(HeaderLastError))
+	// the failed record itself is left untouched
+	assert.Len(t, record.Headers, 1)
+}
+
+func TestNonRetriable(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	assert.Nil(t, NonRetriable(nil))
+	assert.False(t, IsNonRetriable(errFailed))
+	assert.True(t, IsNonRetriable(NonRetriable(errFailed)))
+	assert.ErrorIs(t, NonRetriable(errFailed), errFailed)
+	assert.EqualError(t, NonRetriable(errFailed), "failed")
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, MinRetryBackoff, RetryBackoff(1))
+	assert.Equal(t, 2*MinRetryBackoff, RetryBackoff(2))
+	assert.Equal(t, MaxRetryBackoff, RetryBackoff(100))
+}
@@ -0,0 +1,94 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrUnroutable is returned by the default fallback of a Router for messages without a route
+var ErrUnroutable = errors.New("no route for message")
+
+// Router is a ConsumerHandler that routes each message to the handler registered for its topic, or for a header
+// value of its topic. It is started on a consumer of all its topics, so the topics share one consumer group
+// client and poll loop:
+//
+//	consumer, err := client.NewTopicsConsumer(router.Topics(), opts...)
+//	consumer.Start(ctx, router)
+type Router struct {
+	topics       []string
+	routes       map[string]ConsumerHandler
+	headerRoutes []headerRoute
+	fallback     ConsumerHandler
+}
+
+type headerRoute struct {
+	topic   string
+	key     string
+	value   []byte
+	handler ConsumerHandler
+}
+
+// NewRouter creates a new router without routes. Messages without a route fail with a non-retriable
+// ErrUnroutable unless a fallback handler is set.
+func NewRouter() *Router {
+	return &Router{
+		routes:   make(map[string]ConsumerHandler),
+		fallback: ConsumerHandlerFunc(unroutable),
+	}
+}
+
+// Route routes the messages of the topic to the handler
+func (r *Router) Route(topic string, handler ConsumerHandler) *Router {
+	r.addTopic(topic)
+	r.routes[topic] = handler
+	return r
+}
+
+// RouteHeader routes the messages of the topic whose last header with the key has the value to the handler,
+// e.g. to route by a message type header. Header routes are matched in the order they were added, before the
+// route of the topic.
+func (r *Router) RouteHeader(topic, key, value string, handler ConsumerHandler) *Router {
+	r.addTopic(topic)
+	r.headerRoutes = append(r.headerRoutes, headerRoute{topic: topic, key: key, value: []byte(value), handler: handler})
+	return r
+}
+
+// Fallback handles the messages that match no route
+func (r *Router) Fallback(handler ConsumerHandler) *Router {
+	r.fallback = handler
+	return r
+}
+
+// Topics returns the topics of the routes in the order they were added
+func (r *Router) Topics() []string {
+	return slices.Clone(r.topics)
+}
+
+// Handle calls the handler of the first route that matches the message, or the fallback handler
+func (r *Router) Handle(ctx context.Context, message Message) error {
+	for _, route := range r.headerRoutes {
+		if route.topic != message.Topic {
+			continue
+		}
+		if value, ok := message.Header(route.key); ok && bytes.Equal(value, route.value) {
+			return route.handler.Handle(ctx, message)
+		}
+	}
+	if handler, ok := r.routes[message.Topic]; ok {
+		return handler.Handle(ctx, message)
+	}
+	return r.fallback.Handle(ctx, message)
+}
+
+func (r *Router) addTopic(topic string) {
+	if !slices.Contains(r.topics, topic) {
+		r.topics = append(r.topics, topic)
+	}
+}
+
+func unroutable(_ context.Context, message Message) error {
+	return NonRetriable(fmt.Errorf("%w from %s at offset %d", ErrUnroutable, message.Topic, message.Offset))
+}
@@ -0,0 +1,112 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	var routed string
+	route := func(name string) ConsumerHandler {
+		return ConsumerHandlerFunc(func(context.Context, Message) error {
+			routed = name
+			return nil
+		})
+	}
+	router := NewRouter().
+		Route("topic-a", route("topic-a")).
+		RouteHeader("topic-a", "type", "created", route("topic-a created")).
+		Route("topic-b", route("topic-b"))
+
+	tests := []struct {
+		name    string
+		message Message
+		routed  string
+		err     error
+	}{
+		{
+			name:    "topic",
+			message: Message{Topic: "topic-a"},
+			routed:  "topic-a",
+		},
+		{
+			name:    "header",
+			message: Message{Topic: "topic-a", Headers: []Header{{Key: "type", Value: []byte("created")}}},
+			routed:  "topic-a created",
+		},
+		{
+			name:    "header of another value",
+			message: Message{Topic: "topic-a", Headers: []Header{{Key: "type", Value: []byte("deleted")}}},
+			routed:  "topic-a",
+		},
+		{
+			name:    "header of another topic",
+			message: Message{Topic: "topic-b", Headers: []Header{{Key: "type", Value: []byte("created")}}},
+			routed:  "topic-b",
+		},
+		{
+			name:    "unroutable",
+			message: Message{Topic: "topic-c"},
+			err:     ErrUnroutable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routed = ""
+			err := router.Handle(context.Background(), tt.message)
+			assert.Equal(t, tt.routed, routed)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				assert.True(t, IsNonRetriable(err))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	assert.Equal(t, []string{"topic-a", "topic-b"}, router.Topics())
+
+	router.Fallback(route("fallback"))
+	assert.NoError(t, router.Handle(context.Background(), Message{Topic: "topic-c"}))
+	assert.Equal(t, "fallback", routed)
+}
+
+func TestRouterConsumer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, _ := newTestCluster(t, 1, "topic-a", "topic-b")
+	defer client.Close(ctx)
+
+	a := &channelHandler{messages: make(chan Message, 1)}
+	b := make(chan string, 1)
+	router := NewRouter().
+		Route("topic-a", a).
+		Route("topic-b", NewTypedHandler[string](TypedHandlerFunc[string](func(_ context.Context, value string, _ Message) error {
+			b <- value
+			return nil
+		})))
+
+	consumer, err := client.NewTopicsConsumer(router.Topics())
+	require.NoError(t, err)
+	consumer.Start(ctx, router)
+
+	require.NoError(t, client.NewProducer("topic-a").Send(ctx, Message{Value: []byte("first")}).FirstErr())
+	require.NoError(t, NewTypedProducer[string](client.NewProducer("topic-b")).Send(ctx, nil, "second"))
+
+	select {
+	case msg := <-a.messages:
+		assert.Equal(t, "first", string(msg.Value))
+	case <-ctx.Done():
+		t.Fatal("no message routed to topic-a")
+	}
+	select {
+	case value := <-b:
+		assert.Equal(t, "second", value)
+	case <-ctx.Done():
+		t.Fatal("no message routed to topic-b")
+	}
+}
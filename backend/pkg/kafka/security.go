@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// Security protocols, named as in the kafka client configuration
+const (
+	SecurityProtocolPlaintext     = "PLAINTEXT"
+	SecurityProtocolSSL           = "SSL"
+	SecurityProtocolSASLPlaintext = "SASL_PLAINTEXT"
+	SecurityProtocolSASLSSL       = "SASL_SSL"
+)
+
+// SASL mechanisms
+const (
+	SaslMechanismPlain       = "PLAIN"
+	SaslMechanismScramSHA256 = "SCRAM-SHA-256"
+	SaslMechanismScramSHA512 = "SCRAM-SHA-512"
+)
+
+var ErrInvalidSecurityConfig = errors.New("invalid kafka security config")
+
+// securityOpts returns the client options for the TLS and SASL settings of the config. Settings that
+// the security protocol would silently ignore are rejected, so a misconfigured client fails at startup
+// instead of connecting without the expected protection.
+func securityOpts(config *Config) ([]kgo.Opt, error) {
+	protocol := strings.ToUpper(config.SecurityProtocol)
+	if protocol == "" {
+		protocol = SecurityProtocolPlaintext
+	}
+
+	var useTLS, useSASL bool
+	switch protocol {
+	case SecurityProtocolPlaintext:
+	case SecurityProtocolSSL:
+		useTLS = true
+	case SecurityProtocolSASLPlaintext:
+		useSASL = true
+	case SecurityProtocolSASLSSL:
+		useTLS, useSASL = true, true
+	default:
+		return nil, invalidSecurityConfig("unknown security protocol %q", config.SecurityProtocol)
+	}
+
+	if !useTLS && (config.SslCaLocation != "" || config.SslCertificateLocation != "" || config.SslKeyLocation != "") {
+		return nil, invalidSecurityConfig("ssl locations are set but security protocol %s does not use TLS", protocol)
+	}
+	if !useSASL && (config.SaslMechanism != "" || config.SaslUsername != "" || config.SaslPassword != "") {
+		return nil, invalidSecurityConfig("sasl settings are set but security protocol %s does not use SASL", protocol)
+	}
+
+	var opts []kgo.Opt
+	if useTLS {
+		tlsCfg, err := tlsConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.DialTLSConfig(tlsCfg))
+	}
+	if useSASL {
+		mechanism, err := saslMechanism(config)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.SASL(mechanism))
+	}
+	return opts, nil
+}
+
+// tlsConfig builds the TLS config from the CA bundle and the client certificate and key, if any.
+// The server name is set per broker by the client.
+func tlsConfig(config *Config) (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.SslCaLocation != "" {
+		pem, err := os.ReadFile(config.SslCaLocation)
+		if err != nil {
+			return nil, invalidSecurityConfig("failed to read ssl ca location: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, invalidSecurityConfig("no certificates found in ssl ca location %s", config.SslCaLocation)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if (config.SslCertificateLocation == "") != (config.SslKeyLocation == "") {
+		return nil, invalidSecurityConfig("ssl certificate location and ssl key location must be set together")
+	}
+	if config.SslCertificateLocation != "" {
+		cert, err := tls.LoadX509KeyPair(config.SslCertificateLocation, config.SslKeyLocation)
+		if err != nil {
+			return nil, invalidSecurityConfig("failed to load ssl client certificate: %v", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+// saslMechanism builds the SASL mechanism with the configured credentials
+func saslMechanism(config *Config) (sasl.Mechanism, error) {
+	if config.SaslUsername == "" || config.SaslPassword == "" {
+		return nil, invalidSecurityConfig("sasl username and password are required")
+	}
+
+	switch strings.ToUpper(config.SaslMechanism) {
+	case SaslMechanismPlain:
+		return plain.Auth{User: config.SaslUsername, Pass: config.SaslPassword}.AsMechanism(), nil
+	case SaslMechanismScramSHA256:
+		return scram.Auth{User: config.SaslUsername, Pass: config.SaslPassword}.AsSha256Mechanism(), nil
+	case SaslMechanismScramSHA512:
+		return scram.Auth{User: config.SaslUsername, Pass: config.SaslPassword}.AsSha512Mechanism(), nil
+	case "":
+		return nil, invalidSecurityConfig("sasl mechanism is required")
+	default:
+		return nil, invalidSecurityConfig("unknown sasl mechanism %q", config.SaslMechanism)
+	}
+}
+
+func invalidSecurityConfig(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSecurityConfig, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,192 @@
+package kafka
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+)
+
+func TestSecurityOptsValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		expectedErr bool
+	}{
+		{name: "plaintext by default", config: Config{}},
+		{name: "sasl plaintext", config: Config{SecurityProtocol: "SASL_PLAINTEXT", SaslMechanism: "PLAIN", SaslUsername: "u", SaslPassword: "p"}},
+		{name: "sasl ssl with lower case settings", config: Config{SecurityProtocol: "sasl_ssl", SaslMechanism: "scram-sha-512", SaslUsername: "u", SaslPassword: "p"}},
+		{name: "unknown protocol", config: Config{SecurityProtocol: "TLS"}, expectedErr: true},
+		{name: "ssl locations without tls", config: Config{SslCaLocation: "ca.pem"}, expectedErr: true},
+		{name: "sasl settings without sasl", config: Config{SecurityProtocol: "SSL", SaslMechanism: "PLAIN"}, expectedErr: true},
+		{name: "certificate without key", config: Config{SecurityProtocol: "SSL", SslCertificateLocation: "client.pem"}, expectedErr: true},
+		{name: "missing ca file", config: Config{SecurityProtocol: "SSL", SslCaLocation: "missing.pem"}, expectedErr: true},
+		{name: "sasl without mechanism", config: Config{SecurityProtocol: "SASL_SSL", SaslUsername: "u", SaslPassword: "p"}, expectedErr: true},
+		{name: "unknown sasl mechanism", config: Config{SecurityProtocol: "SASL_SSL", SaslMechanism: "GSSAPI", SaslUsername: "u", SaslPassword: "p"}, expectedErr: true},
+		{name: "sasl without password", config: Config{SecurityProtocol: "SASL_PLAINTEXT", SaslMechanism: "PLAIN", SaslUsername: "u"}, expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := securityOpts(&tt.config)
+			if tt.expectedErr {
+				assert.ErrorIs(t, err, ErrInvalidSecurityConfig)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewClientSecurity(t *testing.T) {
+	pki := newTestPKI(t)
+
+	tests := []struct {
+		name        string
+		clusterOpts []kfake.Opt
+		config      Config
+		expectedErr bool
+	}{
+		{
+			name:        "mtls",
+			clusterOpts: []kfake.Opt{kfake.TLS(pki.serverTLS(tls.RequireAndVerifyClientCert))},
+			config:      Config{SecurityProtocol: "SSL", SslCaLocation: pki.caFile, SslCertificateLocation: pki.certFile, SslKeyLocation: pki.keyFile},
+		},
+		{
+			name:        "mtls without client certificate",
+			clusterOpts: []kfake.Opt{kfake.TLS(pki.serverTLS(tls.RequireAndVerifyClientCert))},
+			config:      Config{SecurityProtocol: "SSL", SslCaLocation: pki.caFile},
+			expectedErr: true,
+		},
+		{
+			name: "sasl scram over tls",
+			clusterOpts: []kfake.Opt{
+				kfake.TLS(pki.serverTLS(tls.NoClientCert)),
+				kfake.EnableSASL(),
+				kfake.Superuser("SCRAM-SHA-256", "admin", "secret"),
+			},
+			config: Config{SecurityProtocol: "SASL_SSL", SslCaLocation: pki.caFile, SaslMechanism: "SCRAM-SHA-256", SaslUsername: "admin", SaslPassword: "secret"},
+		},
+		{
+			name: "sasl plain with wrong password",
+			clusterOpts: []kfake.Opt{
+				kfake.EnableSASL(),
+				kfake.Superuser("PLAIN", "admin", "secret"),
+			},
+			config:      Config{SecurityProtocol: "SASL_PLAINTEXT", SaslMechanism: "PLAIN", SaslUsername: "admin", SaslPassword: "wrong"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, err := kfake.NewCluster(append(tt.clusterOpts, kfake.NumBrokers(1))...)
+			require.NoError(t, err)
+			defer cluster.Close()
+
+			config := tt.config
+			config.BootstrapServers = cluster.ListenAddrs()
+			client, err := NewClient(context.Background(), &config)
+			require.NoError(t, err)
+			defer client.Close(context.Background())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = client.producer.Ping(ctx)
+			if tt.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// testPKI is a CA with a server certificate for 127.0.0.1 and a client certificate, written as PEM files
+type testPKI struct {
+	caPool     *x509.CertPool
+	serverCert tls.Certificate
+	caFile     string
+	certFile   string
+	keyFile    string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "test"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+
+	serverCertPEM, serverKeyPEM := issue(2, x509.ExtKeyUsageServerAuth)
+	serverCert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	require.NoError(t, err)
+	clientCertPEM, clientKeyPEM := issue(3, x509.ExtKeyUsageClientAuth)
+
+	pki := &testPKI{
+		caPool:     x509.NewCertPool(),
+		serverCert: serverCert,
+		caFile:     filepath.Join(dir, "ca.pem"),
+		certFile:   filepath.Join(dir, "client.pem"),
+		keyFile:    filepath.Join(dir, "client.key"),
+	}
+	pki.caPool.AddCert(caCert)
+	require.NoError(t, os.WriteFile(pki.caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0o600))
+	require.NoError(t, os.WriteFile(pki.certFile, clientCertPEM, 0o600))
+	require.NoError(t, os.WriteFile(pki.keyFile, clientKeyPEM, 0o600))
+	return pki
+}
+
+func (p *testPKI) serverTLS(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{p.serverCert},
+		ClientCAs:    p.caPool,
+		ClientAuth:   clientAuth,
+	}
+}
@@ -0,0 +1,194 @@
+package kafka
+
+import (
+	"context"
+
+	otelUtil "github.com/kartpop/cruncan/backend/pkg/otel"
+	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RecordCarrier adapts the headers of a kafka record to a propagation.TextMapCarrier,
+// so the trace context and baggage can travel with the record
+type RecordCarrier struct {
+	record *kgo.Record
+}
+
+var _ propagation.TextMapCarrier = (*RecordCarrier)(nil)
+
+// NewRecordCarrier creates a new RecordCarrier for the record
+func NewRecordCarrier(record *kgo.Record) *RecordCarrier {
+	return &RecordCarrier{record: record}
+}
+
+// Get returns the value of the first header with the key
+func (c *RecordCarrier) Get(key string) string {
+	for _, h := range c.record.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set sets the header with the key, replacing any existing header with the same key
+func (c *RecordCarrier) Set(key, value string) {
+	headers := c.record.Headers[:0]
+	for _, h := range c.record.Headers {
+		if h.Key != key {
+			headers = append(headers, h)
+		}
+	}
+	c.record.Headers = append(headers, kgo.RecordHeader{Key: key, Value: []byte(value)})
+}
+
+// Keys returns the keys of all headers
+func (c *RecordCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.record.Headers))
+	for _, h := range c.record.Headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// MessageCarrier adapts the headers of a message to a propagation.TextMapCarrier, like RecordCarrier does for
+// the record of the message
+type MessageCarrier struct {
+	message *Message
+}
+
+var _ propagation.TextMapCarrier = (*MessageCarrier)(nil)
+
+// NewMessageCarrier creates a new MessageCarrier for the message
+func NewMessageCarrier(message *Message) *MessageCarrier {
+	return &MessageCarrier{message: message}
+}
+
+// Get returns the value of the first header with the key, like RecordCarrier.Get and unlike Message.Header
+func (c *MessageCarrier) Get(key string) string {
+	for _, h := range c.message.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set sets the header with the key, replacing any existing header with the same key
+func (c *MessageCarrier) Set(key, value string) {
+	headers := make([]Header, 0, len(c.message.Headers)+1)
+	for _, h := range c.message.Headers {
+		if h.Key != key {
+			headers = append(headers, h)
+		}
+	}
+	c.message.Headers = append(headers, Header{Key: key, Value: []byte(value)})
+}
+
+// Keys returns the keys of all headers
+func (c *MessageCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.message.Headers))
+	for _, h := range c.message.Headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// InjectTraceContext sets the trace context and baggage of ctx in the headers of the message, so the message is
+// published in that trace rather than the trace of the context it is sent with, see PublishContext
+func (m *Message) InjectTraceContext(ctx context.Context) {
+	otel.GetTextMapPropagator().Inject(ctx, NewMessageCarrier(m))
+}
+
+// PublishContext returns the context to start the producer span of a message in, and the links of the span. A
+// message whose headers already carry a trace context is published in that trace and linked to the span of ctx,
+// so the messages of a batch sent with one context each keep their own trace.
+func PublishContext(ctx context.Context, carrier propagation.TextMapCarrier) (context.Context, trace.SpanStartOption) {
+	sent := trace.SpanContextFromContext(ctx)
+	carried := otel.GetTextMapPropagator().Extract(ctx, carrier)
+	if sc := trace.SpanContextFromContext(carried); !sc.IsValid() || sc.Equal(sent) {
+		return ctx, trace.WithLinks()
+	}
+	if !sent.IsValid() {
+		return carried, trace.WithLinks()
+	}
+	return carried, trace.WithLinks(trace.Link{SpanContext: sent})
+}
+
+// startProducerSpan starts a producer span for the record and injects the span context into the record headers
+func startProducerSpan(ctx context.Context, record *kgo.Record) (context.Context, trace.Span) {
+	ctx, links := PublishContext(ctx, NewRecordCarrier(record))
+	tracer, _ := otelContext.Tracer(ctx)
+	ctx, span := tracer.Start(ctx, record.Topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		links,
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationPublish,
+			semconv.MessagingDestinationName(record.Topic),
+			semconv.MessagingMessageBodySize(len(record.Value)),
+		),
+	)
+	otel.GetTextMapPropagator().Inject(ctx, NewRecordCarrier(record))
+	return ctx, span
+}
+
+// endProducerSpan records the partition and offset assigned by the broker on the producer span
+func endProducerSpan(span trace.Span, record *kgo.Record, err error) {
+	if err == nil {
+		span.SetAttributes(
+			semconv.MessagingKafkaDestinationPartition(int(record.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(record.Offset)),
+		)
+	}
+	otelUtil.SetAutoSpanStatus(span, err)
+	span.End()
+}
+
+// startConsumerSpan extracts the trace context propagated in the record headers and starts a consumer span
+// as its child, so the producing and consuming services share a single trace
+func startConsumerSpan(ctx context.Context, record *kgo.Record, group string) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, NewRecordCarrier(record))
+	tracer, _ := otelContext.Tracer(ctx)
+	return tracer.Start(ctx, record.Topic+" deliver",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationDeliver,
+			semconv.MessagingDestinationName(record.Topic),
+			semconv.MessagingKafkaDestinationPartition(int(record.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(record.Offset)),
+			semconv.MessagingKafkaConsumerGroup(group),
+			semconv.MessagingMessageBodySize(len(record.Value)),
+		),
+	)
+}
+
+// startBatchSpan starts a consumer span for the records of a batch. The records may come from different traces,
+// so the span links the trace context propagated in each record instead of continuing one of them.
+func startBatchSpan(ctx context.Context, records []*kgo.Record, group string) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(records))
+	for _, record := range records {
+		producer := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(ctx, NewRecordCarrier(record)))
+		if producer.IsValid() {
+			links = append(links, trace.Link{SpanContext: producer})
+		}
+	}
+	tracer, _ := otelContext.Tracer(ctx)
+	return tracer.Start(ctx, records[0].Topic+" deliver",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationDeliver,
+			semconv.MessagingDestinationName(records[0].Topic),
+			semconv.MessagingKafkaDestinationPartition(int(records[0].Partition)),
+			semconv.MessagingKafkaConsumerGroup(group),
+			semconv.MessagingBatchMessageCount(len(records)),
+		),
+	)
+}
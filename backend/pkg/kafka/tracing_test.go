@@ -0,0 +1,132 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRecordCarrier(t *testing.T) {
+	record := &kgo.Record{Headers: []kgo.RecordHeader{{Key: "a", Value: []byte("1")}}}
+	carrier := NewRecordCarrier(record)
+
+	carrier.Set("b", "2")
+	carrier.Set("a", "3")
+
+	assert.Equal(t, "3", carrier.Get("a"))
+	assert.Equal(t, "2", carrier.Get("b"))
+	assert.Equal(t, "", carrier.Get("c"))
+	assert.ElementsMatch(t, []string{"a", "b"}, carrier.Keys())
+
+	// of headers with the same key, the first one is read
+	record.Headers = append(record.Headers, kgo.RecordHeader{Key: "b", Value: []byte("4")})
+	assert.Equal(t, "2", carrier.Get("b"))
+}
+
+func TestMessageCarrier(t *testing.T) {
+	headers := []Header{{Key: "a", Value: []byte("1")}}
+	message := &Message{Headers: headers}
+	carrier := NewMessageCarrier(message)
+
+	carrier.Set("b", "2")
+	carrier.Set("a", "3")
+
+	assert.Equal(t, "3", carrier.Get("a"))
+	assert.Equal(t, "2", carrier.Get("b"))
+	assert.Equal(t, "", carrier.Get("c"))
+	assert.ElementsMatch(t, []string{"a", "b"}, carrier.Keys())
+	// the headers of copies of the message are left alone
+	assert.Equal(t, "1", string(headers[0].Value))
+
+	// of headers with the same key, the first one is read like from the record of the message
+	message.Headers = append(message.Headers, Header{Key: "b", Value: []byte("4")})
+	assert.Equal(t, "2", carrier.Get("b"))
+	assert.Equal(t, "2", NewRecordCarrier(message.toRecord("topic-a")).Get("b"))
+}
+
+func TestTracePropagation(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx := otelContext.WithTracer(context.Background(), tp.Tracer("test"))
+
+	member, _ := baggage.NewMember("user_id", "usr-1")
+	bag, _ := baggage.New(member)
+	producerCtx := baggage.ContextWithBaggage(ctx, bag)
+
+	// Produce
+	record := &kgo.Record{Topic: "topic-a", Value: []byte("value")}
+	_, producerSpan := startProducerSpan(producerCtx, record)
+	record.Partition, record.Offset = 2, 42
+	endProducerSpan(producerSpan, record, nil)
+
+	// Consume, with a fresh context as on the other side of the broker
+	consumerCtx, consumerSpan := startConsumerSpan(ctx, record, "group-a")
+	consumerSpan.End()
+
+	// Assert
+	assert.Equal(t, producerSpan.SpanContext().TraceID(), consumerSpan.SpanContext().TraceID())
+	assert.Equal(t, "usr-1", baggage.FromContext(consumerCtx).Member("user_id").Value())
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, trace.SpanKindProducer, spans[0].SpanKind())
+		assert.Contains(t, spans[0].Attributes(), semconv.MessagingDestinationName("topic-a"))
+		assert.Contains(t, spans[0].Attributes(), semconv.MessagingKafkaMessageOffset(42))
+
+		assert.Equal(t, trace.SpanKindConsumer, spans[1].SpanKind())
+		assert.Equal(t, spans[0].SpanContext().SpanID(), spans[1].Parent().SpanID())
+		assert.Contains(t, spans[1].Attributes(), semconv.MessagingKafkaConsumerGroup("group-a"))
+		assert.Contains(t, spans[1].Attributes(), semconv.MessagingKafkaDestinationPartition(2))
+	}
+}
+
+func TestProducerSpanContinuesTheTraceOfTheMessage(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx := otelContext.WithTracer(context.Background(), tp.Tracer("test"))
+
+	ctx, batch := tp.Tracer("test").Start(ctx, "batch")
+	request := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	message := Message{Topic: "topic-a"}
+	message.InjectTraceContext(trace.ContextWithSpanContext(context.Background(), request))
+	traced := message.toRecord("topic-a")
+	untraced := &kgo.Record{Topic: "topic-a"}
+
+	// Act
+	_, tracedSpan := startProducerSpan(ctx, traced)
+	endProducerSpan(tracedSpan, traced, nil)
+	_, untracedSpan := startProducerSpan(ctx, untraced)
+	endProducerSpan(untracedSpan, untraced, nil)
+	batch.End()
+
+	// Assert
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 3) {
+		assert.Equal(t, request.TraceID(), spans[0].SpanContext().TraceID())
+		assert.Equal(t, request.SpanID(), spans[0].Parent().SpanID())
+		if assert.Len(t, spans[0].Links(), 1) {
+			assert.Equal(t, batch.SpanContext(), spans[0].Links()[0].SpanContext)
+		}
+		assert.Equal(t, batch.SpanContext().SpanID(), spans[1].Parent().SpanID())
+		assert.Empty(t, spans[1].Links())
+	}
+	// the headers carry the producer span, so the consumer continues the trace of the message
+	assert.Equal(t, spans[0].SpanContext().SpanID(), trace.SpanContextFromContext(
+		otel.GetTextMapPropagator().Extract(context.Background(), NewRecordCarrier(traced))).SpanID())
+}
@@ -0,0 +1,153 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var ErrInvalidTuningConfig = errors.New("invalid kafka tuning config")
+
+// producerOpts returns the client options for the producer tuning of the config
+func producerOpts(config *Config) ([]kgo.Opt, error) {
+	var opts []kgo.Opt
+
+	allAcks := true
+	switch strings.ToLower(config.Acks) {
+	case "", "all", "-1":
+	case "leader", "1":
+		allAcks = false
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()))
+	case "none", "0":
+		allAcks = false
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()))
+	default:
+		return nil, invalidTuningConfig("unknown acks %q", config.Acks)
+	}
+
+	idempotent := allAcks
+	if config.EnableIdempotence != nil {
+		if *config.EnableIdempotence && !allAcks {
+			return nil, invalidTuningConfig("idempotent producing requires acks all, got %q", config.Acks)
+		}
+		idempotent = *config.EnableIdempotence
+	}
+	if !idempotent {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+
+	if config.CompressionCodec != "" {
+		codec, err := compressionCodec(config.CompressionCodec)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.ProducerBatchCompression(codec))
+	}
+	if config.LingerMs > 0 {
+		opts = append(opts, kgo.ProducerLinger(time.Duration(config.LingerMs)*time.Millisecond))
+	}
+	if config.BatchSize > 0 {
+		opts = append(opts, kgo.ProducerBatchMaxBytes(int32(config.BatchSize)))
+	}
+	if config.MaxInFlight > 0 {
+		// the idempotent producer manages its requests in flight itself
+		if idempotent {
+			return nil, invalidTuningConfig("max in flight requires idempotent producing to be disabled")
+		}
+		opts = append(opts, kgo.MaxProduceRequestsInflightPerBroker(config.MaxInFlight))
+	}
+
+	return opts, nil
+}
+
+// consumerOpts returns the client options for the consumer tuning of the config, the group options
+// are only returned if the config has a group
+func consumerOpts(config *Config) ([]kgo.Opt, error) {
+	var opts []kgo.Opt
+
+	switch strings.ToLower(config.AutoOffsetReset) {
+	case "", "latest":
+		opts = append(opts, kgo.ConsumeResetOffset(kgo.NewOffset().AtEnd()))
+	case "earliest":
+		opts = append(opts, kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+	case "none":
+		opts = append(opts, kgo.ConsumeResetOffset(kgo.NoResetOffset()))
+	default:
+		return nil, invalidTuningConfig("unknown auto offset reset %q", config.AutoOffsetReset)
+	}
+
+	if config.FetchMinBytes > 0 {
+		opts = append(opts, kgo.FetchMinBytes(int32(config.FetchMinBytes)))
+	}
+	if config.FetchMaxBytes > 0 {
+		opts = append(opts, kgo.FetchMaxBytes(int32(config.FetchMaxBytes)))
+	}
+	if config.FetchMaxPartitionBytes > 0 {
+		opts = append(opts, kgo.FetchMaxPartitionBytes(int32(config.FetchMaxPartitionBytes)))
+	}
+	if config.FetchMaxWaitMs > 0 {
+		opts = append(opts, kgo.FetchMaxWait(time.Duration(config.FetchMaxWaitMs)*time.Millisecond))
+	}
+
+	if config.GroupId == "" {
+		return opts, nil
+	}
+	if config.SessionTimeoutMs > 0 {
+		opts = append(opts, kgo.SessionTimeout(time.Duration(config.SessionTimeoutMs)*time.Millisecond))
+	}
+	if config.RebalanceTimeoutMs > 0 {
+		opts = append(opts, kgo.RebalanceTimeout(time.Duration(config.RebalanceTimeoutMs)*time.Millisecond))
+	}
+	if len(config.Balancers) > 0 {
+		balancers := make([]kgo.GroupBalancer, 0, len(config.Balancers))
+		for _, name := range config.Balancers {
+			balancer, err := groupBalancer(name)
+			if err != nil {
+				return nil, err
+			}
+			balancers = append(balancers, balancer)
+		}
+		opts = append(opts, kgo.Balancers(balancers...))
+	}
+
+	return opts, nil
+}
+
+func compressionCodec(name string) (kgo.CompressionCodec, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return kgo.NoCompression(), nil
+	case "gzip":
+		return kgo.GzipCompression(), nil
+	case "snappy":
+		return kgo.SnappyCompression(), nil
+	case "lz4":
+		return kgo.Lz4Compression(), nil
+	case "zstd":
+		return kgo.ZstdCompression(), nil
+	default:
+		return kgo.CompressionCodec{}, invalidTuningConfig("unknown compression codec %q", name)
+	}
+}
+
+func groupBalancer(name string) (kgo.GroupBalancer, error) {
+	switch strings.ToLower(name) {
+	case "range":
+		return kgo.RangeBalancer(), nil
+	case "roundrobin":
+		return kgo.RoundRobinBalancer(), nil
+	case "sticky":
+		return kgo.StickyBalancer(), nil
+	case "cooperative-sticky":
+		return kgo.CooperativeStickyBalancer(), nil
+	default:
+		return nil, invalidTuningConfig("unknown balancer %q", name)
+	}
+}
+
+func invalidTuningConfig(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidTuningConfig, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestNewClientTuning(t *testing.T) {
+	idempotent := false
+	config := &Config{
+		BootstrapServers:   []string{"localhost:9092"},
+		GroupId:            "group-a",
+		AutoOffsetReset:    "earliest",
+		Acks:               "all",
+		EnableIdempotence:  &idempotent,
+		CompressionCodec:   "zstd",
+		LingerMs:           20,
+		BatchSize:          512 * 1024,
+		MaxInFlight:        3,
+		FetchMinBytes:      1024,
+		FetchMaxBytes:      8 << 20,
+		FetchMaxWaitMs:     250,
+		SessionTimeoutMs:   30000,
+		RebalanceTimeoutMs: 45000,
+		Balancers:          []string{"cooperative-sticky", "range"},
+		LogLevel:           "warn",
+	}
+
+	client, err := NewClient(context.Background(), config)
+	require.NoError(t, err)
+	defer client.Close(context.Background())
+	consumer, err := client.NewConsumer("topic-a")
+	require.NoError(t, err)
+
+	kc := client.producer
+	assert.Equal(t, kgo.AllISRAcks(), kc.OptValue(kgo.RequiredAcks))
+	assert.Equal(t, true, kc.OptValue(kgo.DisableIdempotentWrite))
+	assert.Equal(t, 20*time.Millisecond, kc.OptValue(kgo.ProducerLinger))
+	assert.Equal(t, int32(512*1024), kc.OptValue(kgo.ProducerBatchMaxBytes))
+	assert.Equal(t, 3, kc.OptValue(kgo.MaxProduceRequestsInflightPerBroker))
+	assert.Equal(t, kgo.LogLevelWarn, kc.OptValue(kgo.WithLogger).(kgo.Logger).Level())
+
+	kc = consumer.client
+	assert.Equal(t, kgo.NewOffset().AtStart(), kc.OptValue(kgo.ConsumeResetOffset))
+	assert.Equal(t, int32(1024), kc.OptValue(kgo.FetchMinBytes))
+	assert.Equal(t, int32(8<<20), kc.OptValue(kgo.FetchMaxBytes))
+	assert.Equal(t, 250*time.Millisecond, kc.OptValue(kgo.FetchMaxWait))
+	assert.Equal(t, 30*time.Second, kc.OptValue(kgo.SessionTimeout))
+	assert.Equal(t, 45*time.Second, kc.OptValue(kgo.RebalanceTimeout))
+	assert.Len(t, kc.OptValue(kgo.Balancers), 2)
+	assert.Equal(t, kgo.LogLevelWarn, kc.OptValue(kgo.WithLogger).(kgo.Logger).Level())
+}
+
+func TestNewClientAcks(t *testing.T) {
+	enabled, disabled := true, false
+
+	tests := []struct {
+		name               string
+		config             Config
+		expectedIdempotent bool
+		expectedErr        bool
+	}{
+		{name: "idempotent by default", config: Config{}, expectedIdempotent: true},
+		{name: "leader acks disable idempotence", config: Config{Acks: "leader"}, expectedIdempotent: false},
+		{name: "idempotence disabled explicitly", config: Config{Acks: "all", EnableIdempotence: &disabled}, expectedIdempotent: false},
+		{name: "idempotence requires all acks", config: Config{Acks: "1", EnableIdempotence: &enabled}, expectedErr: true},
+		{name: "max in flight requires idempotence disabled", config: Config{MaxInFlight: 5}, expectedErr: true},
+		{name: "unknown acks", config: Config{Acks: "some"}, expectedErr: true},
+		{name: "unknown compression codec", config: Config{CompressionCodec: "brotli"}, expectedErr: true},
+		{name: "unknown auto offset reset", config: Config{AutoOffsetReset: "beginning"}, expectedErr: true},
+		{name: "unknown balancer", config: Config{GroupId: "g", Balancers: []string{"fair"}}, expectedErr: true},
+		{name: "unknown log level", config: Config{LogLevel: "trace"}, expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.BootstrapServers = []string{"localhost:9092"}
+			client, err := NewClient(context.Background(), &config)
+			if tt.expectedErr {
+				assert.ErrorIs(t, err, ErrInvalidTuningConfig)
+				return
+			}
+			require.NoError(t, err)
+			defer client.Close(context.Background())
+			assert.Equal(t, !tt.expectedIdempotent, client.producer.OptValue(kgo.DisableIdempotentWrite))
+		})
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(defaultLogger)
+
+	logger, err := newSlogLogger("INFO")
+	require.NoError(t, err)
+	assert.Equal(t, kgo.LogLevelInfo, logger.Level())
+
+	logger.Log(kgo.LogLevelWarn, "metadata update failed", "broker", 1)
+	assert.Contains(t, buf.String(), "level=WARN")
+	assert.Contains(t, buf.String(), `msg="metadata update failed"`)
+	assert.Contains(t, buf.String(), "broker=1")
+	assert.Contains(t, buf.String(), "component=kafka")
+
+	logger, err = newSlogLogger("none")
+	assert.NoError(t, err)
+	assert.Nil(t, logger)
+}
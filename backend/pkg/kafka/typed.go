@@ -0,0 +1,148 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/exp/slog"
+)
+
+// TypedHandler handles the decoded messages of a TypedConsumer
+type TypedHandler[T any] interface {
+	// Handle is called with the decoded value and the message it was decoded from, for its key,
+	// headers, partition and offset
+	Handle(ctx context.Context, value T, message Message) error
+}
+
+// TypedHandlerFunc adapts a function to a TypedHandler
+type TypedHandlerFunc[T any] func(ctx context.Context, value T, message Message) error
+
+func (f TypedHandlerFunc[T]) Handle(ctx context.Context, value T, message Message) error {
+	return f(ctx, value, message)
+}
+
+// DecodeError is the error of a message whose value could not be decoded
+type DecodeError struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Err       error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode message %s/%d at offset %d: %v", e.Topic, e.Partition, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeErrorHandler is called instead of the handler with messages that could not be decoded, the error it
+// returns is the result of handling the message
+type DecodeErrorHandler func(ctx context.Context, message Message, err *DecodeError) error
+
+// TypedOption configures a TypedConsumer or TypedProducer
+type TypedOption[T any] func(*typedOptions[T])
+
+type typedOptions[T any] struct {
+	codec         Codec[T]
+	onDecodeError DecodeErrorHandler
+}
+
+// WithCodec encodes and decodes the values with the codec instead of JSON
+func WithCodec[T any](codec Codec[T]) TypedOption[T] {
+	return func(o *typedOptions[T]) {
+		o.codec = codec
+	}
+}
+
+// WithDecodeErrorHandler calls the handler with messages that could not be decoded. By default they are
+// logged and fail with a non-retriable error, so they go to the dead letter topic if the consumer has one.
+func WithDecodeErrorHandler[T any](handler DecodeErrorHandler) TypedOption[T] {
+	return func(o *typedOptions[T]) {
+		o.onDecodeError = handler
+	}
+}
+
+func newTypedOptions[T any](opts []TypedOption[T]) typedOptions[T] {
+	o := typedOptions[T]{codec: JSONCodec[T]{}, onDecodeError: dropDecodeError}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func dropDecodeError(ctx context.Context, _ Message, err *DecodeError) error {
+	slog.ErrorContext(ctx, err.Error())
+	return NonRetriable(err)
+}
+
+// TypedConsumer is a Consumer whose handler receives values decoded with a codec
+type TypedConsumer[T any] struct {
+	*Consumer
+	opts typedOptions[T]
+}
+
+// NewTypedConsumer wraps the consumer to decode the values of its messages, JSON by default
+func NewTypedConsumer[T any](consumer *Consumer, opts ...TypedOption[T]) *TypedConsumer[T] {
+	return &TypedConsumer[T]{Consumer: consumer, opts: newTypedOptions(opts)}
+}
+
+// Start starts the consumer, see Consumer.Start, and calls the handler with the decoded value of each message.
+// Messages that cannot be decoded are passed to the decode error handler instead.
+func (c *TypedConsumer[T]) Start(ctx context.Context, handler TypedHandler[T]) {
+	c.Consumer.Start(ctx, &typedHandler[T]{handler: handler, opts: c.opts})
+}
+
+// NewTypedHandler adapts the typed handler to a ConsumerHandler that decodes the messages, JSON by default,
+// e.g. to register it on a Router
+func NewTypedHandler[T any](handler TypedHandler[T], opts ...TypedOption[T]) ConsumerHandler {
+	return &typedHandler[T]{handler: handler, opts: newTypedOptions(opts)}
+}
+
+type typedHandler[T any] struct {
+	handler TypedHandler[T]
+	opts    typedOptions[T]
+}
+
+func (h *typedHandler[T]) Handle(ctx context.Context, message Message) error {
+	value, err := h.opts.codec.Decode(message.Value)
+	if err != nil {
+		return h.opts.onDecodeError(ctx, message, &DecodeError{
+			Topic:     message.Topic,
+			Partition: message.Partition,
+			Offset:    message.Offset,
+			Err:       err,
+		})
+	}
+	return h.handler.Handle(ctx, value, message)
+}
+
+// TypedProducer is a Producer that sends values encoded with a codec
+type TypedProducer[T any] struct {
+	producer *Producer
+	opts     typedOptions[T]
+}
+
+// NewTypedProducer wraps the producer to encode the values it sends, JSON by default
+func NewTypedProducer[T any](producer *Producer, opts ...TypedOption[T]) *TypedProducer[T] {
+	return &TypedProducer[T]{producer: producer, opts: newTypedOptions(opts)}
+}
+
+// Encode encodes the value into a message with the key, to send it in a batch with Producer.Send or later
+func (p *TypedProducer[T]) Encode(key []byte, value T) (Message, error) {
+	data, err := p.opts.codec.Encode(value)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to encode message for %s: %w", p.producer.topic, err)
+	}
+	return Message{Key: key, Value: data}, nil
+}
+
+// Send encodes the value and sends it with the key, see Producer.Send
+func (p *TypedProducer[T]) Send(ctx context.Context, key []byte, value T) error {
+	message, err := p.Encode(key, value)
+	if err != nil {
+		return err
+	}
+	return p.producer.Send(ctx, message).FirstErr()
+}
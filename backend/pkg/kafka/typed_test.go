@@ -0,0 +1,127 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testValue struct {
+	ID    string `json:"id"`
+	Count int    `json:"count"`
+}
+
+func TestCodecs(t *testing.T) {
+	jsonCodec := JSONCodec[testValue]{}
+	data, err := jsonCodec.Encode(testValue{ID: "a", Count: 2})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"a","count":2}`, string(data))
+	value, err := jsonCodec.Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, testValue{ID: "a", Count: 2}, value)
+	_, err = jsonCodec.Decode([]byte("{"))
+	assert.Error(t, err)
+
+	protoCodec := ProtoCodec[*wrapperspb.StringValue]{}
+	data, err = protoCodec.Encode(wrapperspb.String("hello"))
+	require.NoError(t, err)
+	decoded, err := protoCodec.Decode(data)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(wrapperspb.String("hello"), decoded))
+	_, err = protoCodec.Decode([]byte{0xff})
+	assert.Error(t, err)
+}
+
+func TestTypedHandler(t *testing.T) {
+	handlerErr := errors.New("handler failed")
+
+	tests := []struct {
+		name          string
+		value         string
+		opts          []TypedOption[testValue]
+		handlerErr    error
+		expectedValue *testValue
+		expectedErr   func(t *testing.T, err error)
+	}{
+		{
+			name:          "decoded value is handled",
+			value:         `{"id":"a","count":1}`,
+			expectedValue: &testValue{ID: "a", Count: 1},
+			expectedErr:   func(t *testing.T, err error) { assert.NoError(t, err) },
+		},
+		{
+			name:          "handler error is returned",
+			value:         `{"id":"a"}`,
+			handlerErr:    handlerErr,
+			expectedValue: &testValue{ID: "a"},
+			expectedErr:   func(t *testing.T, err error) { assert.ErrorIs(t, err, handlerErr) },
+		},
+		{
+			name:  "decode error is non-retriable by default",
+			value: `not json`,
+			expectedErr: func(t *testing.T, err error) {
+				var decodeErr *DecodeError
+				require.ErrorAs(t, err, &decodeErr)
+				assert.Equal(t, int64(7), decodeErr.Offset)
+				assert.True(t, IsNonRetriable(err))
+			},
+		},
+		{
+			name:  "decode error handler",
+			value: `not json`,
+			opts: []TypedOption[testValue]{WithDecodeErrorHandler[testValue](func(context.Context, Message, *DecodeError) error {
+				return nil
+			})},
+			expectedErr: func(t *testing.T, err error) { assert.NoError(t, err) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handled *testValue
+			handler := &typedHandler[testValue]{
+				handler: TypedHandlerFunc[testValue](func(_ context.Context, value testValue, _ Message) error {
+					handled = &value
+					return tt.handlerErr
+				}),
+				opts: newTypedOptions(tt.opts),
+			}
+
+			err := handler.Handle(context.Background(), Message{Topic: "topic-a", Offset: 7, Value: []byte(tt.value)})
+			tt.expectedErr(t, err)
+			assert.Equal(t, tt.expectedValue, handled)
+		})
+	}
+}
+
+func TestTypedRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, _ := newTestCluster(t, 1, "topic-a")
+	defer client.Close(ctx)
+
+	producer := NewTypedProducer[testValue](client.NewProducer("topic-a"))
+	require.NoError(t, producer.Send(ctx, []byte("a"), testValue{ID: "a", Count: 1}))
+
+	consumer, err := client.NewConsumer("topic-a")
+	require.NoError(t, err)
+	received := make(chan testValue, 1)
+	NewTypedConsumer[testValue](consumer).Start(ctx, TypedHandlerFunc[testValue](func(_ context.Context, value testValue, msg Message) error {
+		assert.Equal(t, "a", string(msg.Key))
+		received <- value
+		return nil
+	}))
+
+	select {
+	case value := <-received:
+		assert.Equal(t, testValue{ID: "a", Count: 1}, value)
+	case <-ctx.Done():
+		t.Fatal("message not consumed")
+	}
+}
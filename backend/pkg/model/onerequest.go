@@ -1,13 +1,14 @@
 package model
 
-// OneRequest is a model for the request to /one
+// OneRequest is a model for the request to /one, its validate tags are the rules checked by
+// validation.Validate
 type OneRequest struct {
-	UserID string `json:"user_id"`
-	Prompt string `json:"prompt"`
-	Data   []Item `json:"data"`
+	UserID string `json:"user_id" validate:"required,max=64"`
+	Prompt string `json:"prompt" validate:"required,max=4096"`
+	Data   []Item `json:"data" validate:"max=100,unique=key"`
 }
 
 type Item struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key   string `json:"key" validate:"required,max=128"`
+	Value string `json:"value" validate:"max=1024"`
 }
@@ -10,6 +10,7 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
@@ -45,6 +46,8 @@ func InitTracer(ctx context.Context, name string, opts ...trace.TracerOption) (n
 	)
 
 	otel.SetTracerProvider(tp)
+	// propagate the trace context and baggage across service boundaries (http and kafka headers)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
 
 	ctx = otelContext.WithTracer(ctx, otel.Tracer(name, opts...))
 
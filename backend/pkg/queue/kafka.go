@@ -0,0 +1,29 @@
+package queue
+
+import (
+	"slices"
+
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+)
+
+// KafkaBroker creates the producers and consumers of a kafka client
+type KafkaBroker struct {
+	client *kafkaUtil.Client
+	opts   []kafkaUtil.ConsumerOption
+}
+
+var _ Broker = (*KafkaBroker)(nil)
+
+// NewKafkaBroker creates a broker on the client. The options apply to every consumer the broker creates, e.g.
+// kafka.WithRetryTopics or kafka.WithConcurrency.
+func NewKafkaBroker(client *kafkaUtil.Client, opts ...kafkaUtil.ConsumerOption) *KafkaBroker {
+	return &KafkaBroker{client: client, opts: opts}
+}
+
+func (b *KafkaBroker) Publisher(topic string) Publisher {
+	return b.client.NewProducer(topic)
+}
+
+func (b *KafkaBroker) Subscriber(topic string, middlewares ...Middleware) (Subscriber, error) {
+	return b.client.NewConsumer(topic, append(slices.Clone(b.opts), kafkaUtil.WithMiddleware(middlewares...))...)
+}
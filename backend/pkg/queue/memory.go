@@ -0,0 +1,142 @@
+package queue
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryBroker keeps the messages in the memory of the process, they are lost when it exits and are not shared
+// with other processes. Subscribers of a topic are woken up as soon as a message is published to it.
+type MemoryBroker struct {
+	cfg Config
+
+	mu     sync.Mutex
+	nextID int64
+	topics map[string]*memoryTopic
+}
+
+type memoryTopic struct {
+	messages []*memoryMessage
+	// notify is closed and replaced on every publish
+	notify chan struct{}
+}
+
+type memoryMessage struct {
+	id          int64
+	message     Message
+	attempts    int
+	availableAt time.Time
+	claimed     bool
+}
+
+var _ Broker = (*MemoryBroker)(nil)
+
+// NewMemoryBroker creates an empty in-memory broker
+func NewMemoryBroker(cfg Config) *MemoryBroker {
+	return &MemoryBroker{cfg: cfg, topics: make(map[string]*memoryTopic)}
+}
+
+func (b *MemoryBroker) Publisher(topic string) Publisher {
+	return &memoryPublisher{broker: b, topic: topic}
+}
+
+func (b *MemoryBroker) Subscriber(topic string, middlewares ...Middleware) (Subscriber, error) {
+	return newPollingSubscriber(b, BackendMemory, topic, b.cfg, middlewares), nil
+}
+
+// Pending returns the number of messages of the topic that were not acked yet
+func (b *MemoryBroker) Pending(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.topic(topic).messages)
+}
+
+// topic returns the topic, created on first use. The caller must hold mu.
+func (b *MemoryBroker) topic(name string) *memoryTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memoryTopic{notify: make(chan struct{})}
+		b.topics[name] = t
+	}
+	return t
+}
+
+func (b *MemoryBroker) claim(_ context.Context, topic string) (*delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for _, m := range b.topic(topic).messages {
+		if m.claimed || m.availableAt.After(now) {
+			continue
+		}
+		m.claimed = true
+		m.attempts++
+		return &delivery{id: m.id, message: m.message, attempt: m.attempts}, nil
+	}
+	return nil, nil
+}
+
+func (b *MemoryBroker) ack(_ context.Context, d *delivery) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(d.message.Topic)
+	t.messages = slices.DeleteFunc(t.messages, func(m *memoryMessage) bool { return m.id == d.id })
+	return nil
+}
+
+func (b *MemoryBroker) retry(_ context.Context, d *delivery, delay time.Duration, _ error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.topic(d.message.Topic).messages {
+		if m.id == d.id {
+			m.claimed = false
+			m.availableAt = time.Now().Add(delay)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) published(topic string) <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.topic(topic).notify
+}
+
+type memoryPublisher struct {
+	broker *MemoryBroker
+	topic  string
+}
+
+func (p *memoryPublisher) Send(ctx context.Context, messages ...Message) SendResults {
+	results := make(SendResults, len(messages))
+	b := p.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	topics := make(map[string]*memoryTopic)
+	for i, message := range messages {
+		if message.Topic == "" {
+			message.Topic = p.topic
+		}
+		if message.Timestamp.IsZero() {
+			message.Timestamp = time.Now()
+		}
+		message.Headers = slices.Clone(message.Headers)
+		_, span := startProducerSpan(ctx, BackendMemory, message.Topic, &message)
+		b.nextID++
+		message.Offset = b.nextID
+		span.End()
+
+		t := b.topic(message.Topic)
+		t.messages = append(t.messages, &memoryMessage{id: message.Offset, message: message})
+		topics[message.Topic] = t
+		results[i].Message = message
+	}
+	for _, t := range topics {
+		close(t.notify)
+		t.notify = make(chan struct{})
+	}
+	return results
+}
@@ -0,0 +1,178 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	otelUtil "github.com/kartpop/cruncan/backend/pkg/otel"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// PostgresBroker keeps the messages in a table of a database that the publishing and subscribing services share.
+// Subscribers claim one message at a time with SELECT ... FOR UPDATE SKIP LOCKED, so replicas never claim the
+// same message, and hide it for the lease of the config. The table is created by the migrations of the
+// publishing service:
+//
+//	CREATE TABLE queue_messages (
+//	    id bigserial NOT NULL,
+//	    topic text NOT NULL,
+//	    key bytea,
+//	    value bytea,
+//	    headers jsonb NOT NULL,
+//	    created_at timestamp with time zone NOT NULL,
+//	    available_at timestamp with time zone NOT NULL DEFAULT now(),
+//	    attempts integer NOT NULL DEFAULT 0,
+//	    last_error text,
+//	    PRIMARY KEY (id)
+//	);
+type PostgresBroker struct {
+	db  *gorm.DB
+	cfg Config
+}
+
+var _ Broker = (*PostgresBroker)(nil)
+
+// NewPostgresBroker creates a broker on the queue table of the database
+func NewPostgresBroker(db *gorm.DB, cfg Config) *PostgresBroker {
+	return &PostgresBroker{db: db, cfg: cfg.withDefaults()}
+}
+
+type queueRow struct {
+	ID        int64  `gorm:"primaryKey;autoIncrement"`
+	Topic     string `gorm:"type:text not null"`
+	Key       []byte `gorm:"type:bytea"`
+	Value     []byte `gorm:"type:bytea"`
+	Headers   string `gorm:"type:jsonb not null"`
+	CreatedAt time.Time
+	// AvailableAt is left to the database clock, so the clocks of the services do not matter
+	AvailableAt time.Time `gorm:"type:timestamptz not null;default:now()"`
+	Attempts    int       `gorm:"type:integer not null;default:0"`
+	LastError   *string   `gorm:"type:text"`
+}
+
+func (queueRow) TableName() string {
+	return "queue_messages"
+}
+
+// queueHeader is a header as stored in the headers column
+type queueHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+func (b *PostgresBroker) Publisher(topic string) Publisher {
+	return &postgresPublisher{db: b.db, topic: topic}
+}
+
+func (b *PostgresBroker) Subscriber(topic string, middlewares ...Middleware) (Subscriber, error) {
+	return newPollingSubscriber(b, BackendPostgres, topic, b.cfg, middlewares), nil
+}
+
+func (b *PostgresBroker) claim(ctx context.Context, topic string) (*delivery, error) {
+	var row queueRow
+	res := b.db.WithContext(ctx).Raw(`UPDATE queue_messages
+		SET attempts = attempts + 1, available_at = now() + ?::double precision * interval '1 second'
+		WHERE id = (
+			SELECT id FROM queue_messages WHERE topic = ? AND available_at <= now()
+			ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, topic, key, value, headers, created_at, attempts`,
+		b.cfg.Lease.Seconds(), topic).Scan(&row)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	var headers []queueHeader
+	if err := json.Unmarshal([]byte(row.Headers), &headers); err != nil {
+		return nil, fmt.Errorf("failed to decode headers of message %d: %w", row.ID, err)
+	}
+	message := Message{Topic: row.Topic, Key: row.Key, Value: row.Value, Timestamp: row.CreatedAt, Offset: row.ID}
+	for _, h := range headers {
+		message.Headers = append(message.Headers, Header{Key: h.Key, Value: h.Value})
+	}
+	return &delivery{id: row.ID, message: message, attempt: row.Attempts}, nil
+}
+
+func (b *PostgresBroker) ack(ctx context.Context, d *delivery) error {
+	return b.db.WithContext(ctx).Where("id = ?", d.id).Delete(&queueRow{}).Error
+}
+
+func (b *PostgresBroker) retry(ctx context.Context, d *delivery, delay time.Duration, cause error) error {
+	return b.db.WithContext(ctx).Model(&queueRow{}).
+		Where("id = ?", d.id).
+		Updates(map[string]any{
+			"available_at": gorm.Expr("now() + ?::double precision * interval '1 second'", delay.Seconds()),
+			"last_error":   cause.Error(),
+		}).Error
+}
+
+// published returns nil, postgres subscribers poll for new messages
+func (b *PostgresBroker) published(string) <-chan struct{} {
+	return nil
+}
+
+type postgresPublisher struct {
+	db    *gorm.DB
+	topic string
+}
+
+// Send inserts the messages in a single statement, so either all or none of them are published
+func (p *postgresPublisher) Send(ctx context.Context, messages ...Message) SendResults {
+	results := make(SendResults, len(messages))
+	rows := make([]*queueRow, len(messages))
+	spans := make([]trace.Span, len(messages))
+	defer func() {
+		for i, span := range spans {
+			otelUtil.SetAutoSpanStatus(span, results[i].Err)
+			span.End()
+		}
+	}()
+
+	for i, message := range messages {
+		if message.Topic == "" {
+			message.Topic = p.topic
+		}
+		if message.Timestamp.IsZero() {
+			message.Timestamp = time.Now().UTC()
+		}
+		message.Headers = slices.Clone(message.Headers)
+		_, spans[i] = startProducerSpan(ctx, BackendPostgres, message.Topic, &message)
+
+		headers := make([]queueHeader, 0, len(message.Headers))
+		for _, h := range message.Headers {
+			headers = append(headers, queueHeader{Key: h.Key, Value: h.Value})
+		}
+		encoded, err := json.Marshal(headers)
+		if err != nil {
+			results[i].Err = fmt.Errorf("failed to encode headers: %w", err)
+		}
+		rows[i] = &queueRow{Topic: message.Topic, Key: message.Key, Value: message.Value, Headers: string(encoded), CreatedAt: message.Timestamp}
+		results[i].Message = message
+	}
+	if err := results.FirstErr(); err != nil {
+		return failAll(results, err)
+	}
+
+	if err := p.db.WithContext(ctx).Create(rows).Error; err != nil {
+		return failAll(results, fmt.Errorf("failed to insert messages: %w", err))
+	}
+	for i, row := range rows {
+		results[i].Message.Offset = row.ID
+	}
+	return results
+}
+
+// failAll sets the error on every result
+func failAll(results SendResults, err error) SendResults {
+	for i := range results {
+		results[i].Err = err
+	}
+	return results
+}
@@ -0,0 +1,92 @@
+// Package queue publishes messages to topics and subscribes handlers to them independently of the broker. The
+// messages, handlers and middlewares are the ones of the kafka package, so a handler written for a kafka consumer
+// runs unchanged on every backend:
+//
+//   - kafka, see NewKafkaBroker, for production
+//   - postgres, see NewPostgresBroker, a queue table polled with SELECT ... FOR UPDATE SKIP LOCKED, for small
+//     deployments that only run Postgres
+//   - memory, see NewMemoryBroker, for tests and local development within a single process
+//
+// All backends deliver at least once. A message is acked, and never delivered again, once its handler returns
+// nil or a kafka.NonRetriable error. Any other error redelivers the message after a backoff.
+package queue
+
+import (
+	"context"
+	"time"
+
+	gormUtil "github.com/kartpop/cruncan/backend/pkg/database/gorm"
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+)
+
+type (
+	// Message is the message sent by a Publisher and received by a Handler, see kafka.Message
+	Message = kafkaUtil.Message
+	// Header is a message header, see kafka.Header
+	Header = kafkaUtil.Header
+	// Handler handles the messages of a Subscriber, see kafka.ConsumerHandler
+	Handler = kafkaUtil.ConsumerHandler
+	// Middleware wraps the Handler of a Subscriber, see kafka.Middleware
+	Middleware = kafkaUtil.Middleware
+	// SendResults are the outcomes of Publisher.Send, see kafka.SendResults
+	SendResults = kafkaUtil.SendResults
+)
+
+// Publisher sends messages to its topic. kafka.Producer is a Publisher.
+type Publisher interface {
+	// Send sends the messages and waits until the backend stored them
+	Send(ctx context.Context, messages ...Message) SendResults
+}
+
+// Subscriber calls a handler for the messages of its topic. kafka.Consumer is a Subscriber.
+type Subscriber interface {
+	// Start calls the handler for each message until the context is cancelled or the subscriber is stopped,
+	// without blocking the caller
+	Start(ctx context.Context, handler Handler)
+	// Stop stops delivering messages and waits for the handlers in flight until the context is done
+	Stop(ctx context.Context) error
+}
+
+// Broker creates the publishers and subscribers of a backend
+type Broker interface {
+	Publisher(topic string) Publisher
+	// Subscriber creates a subscriber for the topic whose handler is wrapped with the middlewares, see kafka.Chain
+	Subscriber(topic string, middlewares ...Middleware) (Subscriber, error)
+}
+
+const (
+	BackendKafka    = "kafka"
+	BackendPostgres = "postgres"
+	BackendMemory   = "memory"
+)
+
+type Config struct {
+	// Backend is kafka (default), postgres or memory
+	Backend string `mapstructure:"BACKEND"`
+	// Workers is the number of messages a postgres or memory subscriber handles at the same time, defaults to 1
+	Workers int `mapstructure:"WORKERS"`
+	// PollInterval is how often an idle postgres subscriber looks for due messages, defaults to 500ms
+	PollInterval time.Duration `mapstructure:"POLL_INTERVAL"`
+	// Lease is how long a claimed postgres message is hidden from other subscribers, defaults to 5m. A message
+	// whose handler runs longer, or whose subscriber crashed, is delivered again.
+	Lease time.Duration `mapstructure:"LEASE"`
+	// Database is the database of the queue table of the postgres backend, the publishing and the subscribing
+	// services must share it
+	Database *gormUtil.Config `mapstructure:"DATABASE"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.Backend == "" {
+		c.Backend = BackendKafka
+	}
+	if c.Workers <= 0 {
+		c.Workers = 1
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = 500 * time.Millisecond
+	}
+	if c.Lease <= 0 {
+		c.Lease = 5 * time.Minute
+	}
+	return c
+}
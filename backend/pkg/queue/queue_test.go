@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	"github.com/kartpop/cruncan/backend/pkg/kafka/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrokers(t *testing.T) {
+	tests := []struct {
+		name   string
+		broker func(t *testing.T) Broker
+	}{
+		{
+			name: "memory",
+			broker: func(*testing.T) Broker {
+				return NewMemoryBroker(Config{Workers: 2, PollInterval: 10 * time.Millisecond})
+			},
+		},
+		{
+			name: "kafka",
+			broker: func(t *testing.T) Broker {
+				cluster := kafkatest.NewCluster(t, kafkaUtil.Topic{Name: "topic-a", PartitionCount: 1})
+				return NewKafkaBroker(cluster.Client)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			broker := tt.broker(t)
+
+			results := broker.Publisher("topic-a").Send(ctx,
+				Message{Key: []byte("a"), Value: []byte("flaky")},
+				Message{Key: []byte("a"), Value: []byte("malformed")},
+				Message{Key: []byte("a"), Value: []byte("ok")},
+			)
+			require.NoError(t, results.FirstErr())
+
+			var mu sync.Mutex
+			attempts := map[string]int{}
+			done := make(chan struct{})
+			var seen []string
+			handler := kafkaUtil.ConsumerHandlerFunc(func(ctx context.Context, msg Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				value := string(msg.Value)
+				attempts[value]++
+				switch {
+				case value == "flaky" && attempts[value] < 3:
+					return errors.New("unavailable")
+				case value == "malformed":
+					return kafkaUtil.NonRetriable(errors.New("malformed"))
+				}
+				seen = append(seen, value)
+				if len(seen) == 2 {
+					close(done)
+				}
+				return nil
+			})
+			var wrapped []string
+			middleware := func(next Handler) Handler {
+				return kafkaUtil.ConsumerHandlerFunc(func(ctx context.Context, msg Message) error {
+					mu.Lock()
+					wrapped = append(wrapped, string(msg.Value))
+					mu.Unlock()
+					return next.Handle(ctx, msg)
+				})
+			}
+
+			subscriber, err := broker.Subscriber("topic-a", middleware)
+			require.NoError(t, err)
+			subscriber.Start(ctx, handler)
+			select {
+			case <-done:
+			case <-ctx.Done():
+				t.Fatal("messages not handled")
+			}
+			require.NoError(t, subscriber.Stop(ctx))
+
+			// failed messages are delivered again until they succeed or fail as non-retriable
+			assert.Equal(t, map[string]int{"flaky": 3, "malformed": 1, "ok": 1}, attempts)
+			assert.ElementsMatch(t, []string{"flaky", "ok"}, seen)
+			assert.Len(t, wrapped, 5)
+		})
+	}
+}
+
+func TestMemoryBroker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	broker := NewMemoryBroker(Config{PollInterval: time.Hour})
+
+	results := broker.Publisher("topic-a").Send(ctx,
+		Message{Value: []byte("first")},
+		Message{Topic: "topic-b", Value: []byte("other")},
+	)
+	require.NoError(t, results.FirstErr())
+	assert.Equal(t, "topic-a", results[0].Message.Topic)
+	assert.Equal(t, int64(1), results[0].Message.Offset)
+	assert.False(t, results[0].Message.Timestamp.IsZero())
+	assert.Equal(t, 1, broker.Pending("topic-a"))
+	assert.Equal(t, 1, broker.Pending("topic-b"))
+
+	started := make(chan Message, 1)
+	handler := kafkaUtil.ConsumerHandlerFunc(func(ctx context.Context, msg Message) error {
+		started <- msg
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	subscriber, err := broker.Subscriber("topic-a")
+	require.NoError(t, err)
+	subscriber.Start(ctx, handler)
+	msg := <-started
+	assert.Equal(t, "first", string(msg.Value))
+
+	// the handler is cancelled after the stop deadline and its message stays in the queue
+	stopCtx, stopCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer stopCancel()
+	require.NoError(t, subscriber.Stop(stopCtx))
+	assert.Equal(t, 1, broker.Pending("topic-a"))
+
+	// a subscriber started later is woken up by the publish, despite its poll interval
+	handled := make(chan Message, 2)
+	subscriber, err = broker.Subscriber("topic-a")
+	require.NoError(t, err)
+	subscriber.Start(ctx, kafkaUtil.ConsumerHandlerFunc(func(ctx context.Context, msg Message) error {
+		handled <- msg
+		return nil
+	}))
+	assert.Equal(t, "first", string((<-handled).Value))
+	require.NoError(t, broker.Publisher("topic-a").Send(ctx, Message{Value: []byte("second")}).FirstErr())
+	assert.Equal(t, "second", string((<-handled).Value))
+	require.NoError(t, subscriber.Stop(ctx))
+	assert.Equal(t, 0, broker.Pending("topic-a"))
+}
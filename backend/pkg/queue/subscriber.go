@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	otelUtil "github.com/kartpop/cruncan/backend/pkg/otel"
+)
+
+// store keeps the messages of the postgres and memory backends
+type store interface {
+	// claim hides the next due message of the topic from other subscribers and returns it, or nil if no message
+	// is due
+	claim(ctx context.Context, topic string) (*delivery, error)
+	// ack deletes the message, it is not delivered again
+	ack(ctx context.Context, d *delivery) error
+	// retry delivers the message again once the delay has passed
+	retry(ctx context.Context, d *delivery, delay time.Duration, cause error) error
+	// published is closed once a message was published to the topic, it is nil if the store can only be polled
+	published(topic string) <-chan struct{}
+}
+
+// delivery is a claimed message
+type delivery struct {
+	id      int64
+	message Message
+	// attempt counts the deliveries of the message, starting with 1
+	attempt int
+}
+
+// pollingSubscriber claims the messages of its topic from a store and handles them on a number of workers
+type pollingSubscriber struct {
+	store        store
+	system       string
+	topic        string
+	workers      int
+	pollInterval time.Duration
+	middlewares  []Middleware
+
+	mu           sync.Mutex
+	stopPolling  context.CancelFunc
+	stopHandling context.CancelFunc
+	done         chan struct{}
+}
+
+func newPollingSubscriber(store store, system, topic string, cfg Config, middlewares []Middleware) *pollingSubscriber {
+	cfg = cfg.withDefaults()
+	return &pollingSubscriber{
+		store:        store,
+		system:       system,
+		topic:        topic,
+		workers:      cfg.Workers,
+		pollInterval: cfg.PollInterval,
+		middlewares:  middlewares,
+	}
+}
+
+// Start starts the workers, each handles one message at a time. Messages are claimed oldest first, but a message
+// that is retried does not hold back the messages after it, and messages handled by different workers may
+// finish in any order.
+func (s *pollingSubscriber) Start(ctx context.Context, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done != nil {
+		slog.WarnContext(ctx, fmt.Sprintf("trying to start a subscriber of %s that is already running", s.topic))
+		return
+	}
+
+	handler = kafkaUtil.Chain(handler, s.middlewares...)
+	// claiming stops first on Stop, handling is only cancelled once the stop deadline passed
+	pollCtx, stopPolling := context.WithCancel(ctx)
+	handleCtx, stopHandling := context.WithCancel(ctx)
+	done := make(chan struct{})
+	s.stopPolling, s.stopHandling, s.done = stopPolling, stopHandling, done
+
+	var wg sync.WaitGroup
+	wg.Add(s.workers)
+	for range s.workers {
+		go func() {
+			defer wg.Done()
+			s.work(pollCtx, handleCtx, handler)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		stopPolling()
+		stopHandling()
+		close(done)
+	}()
+}
+
+// Stop stops claiming messages and lets the handlers in flight finish until the context is done. Handlers that
+// did not finish in time are cancelled and their messages are delivered again. Stop can be used as a
+// util.TerminatorFunc.
+func (s *pollingSubscriber) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	stopPolling, stopHandling, done := s.stopPolling, s.stopHandling, s.done
+	s.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+
+	stopPolling()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.WarnContext(ctx, fmt.Sprintf("cancelling in-flight messages of %s after the stop deadline", s.topic))
+		stopHandling()
+		<-done
+	}
+	return nil
+}
+
+func (s *pollingSubscriber) work(pollCtx, handleCtx context.Context, handler Handler) {
+	for pollCtx.Err() == nil {
+		// taken before claiming, so a message published in between is not missed
+		published := s.store.published(s.topic)
+		d, err := s.store.claim(pollCtx, s.topic)
+		if err != nil && pollCtx.Err() == nil {
+			slog.ErrorContext(pollCtx, fmt.Sprintf("failed to claim message from %s: %v", s.topic, err))
+		}
+		if d == nil {
+			s.wait(pollCtx, published)
+			continue
+		}
+		s.handle(handleCtx, handler, d)
+	}
+}
+
+// wait blocks until the poll interval passed, a message was published or the context is done
+func (s *pollingSubscriber) wait(ctx context.Context, published <-chan struct{}) {
+	timer := time.NewTimer(s.pollInterval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-published:
+	case <-ctx.Done():
+	}
+}
+
+// handle calls the handler inside a consumer span and acks or retries the message according to its error, like
+// a kafka consumer does
+func (s *pollingSubscriber) handle(ctx context.Context, handler Handler, d *delivery) {
+	spanCtx, span := startConsumerSpan(ctx, s.system, s.topic, d)
+	err := handler.Handle(spanCtx, d.message)
+	otelUtil.SetAutoSpanStatus(span, err)
+	span.End()
+
+	// the message is settled even if handling was cancelled by Stop
+	storeCtx := context.WithoutCancel(spanCtx)
+	var storeErr error
+	switch {
+	case err == nil:
+		storeErr = s.store.ack(storeCtx, d)
+	case ctx.Err() != nil:
+		// cancelled by Stop, the message is delivered again right away instead of once its lease expired
+		storeErr = s.store.retry(storeCtx, d, 0, err)
+	case kafkaUtil.IsNonRetriable(err):
+		slog.ErrorContext(spanCtx, fmt.Sprintf("dropping message from %s after failed handling: %v", s.topic, err))
+		storeErr = s.store.ack(storeCtx, d)
+	default:
+		backoff := kafkaUtil.RetryBackoff(d.attempt)
+		slog.WarnContext(spanCtx, fmt.Sprintf("retrying message from %s in %s after failed handling: %v", s.topic, backoff, err))
+		storeErr = s.store.retry(storeCtx, d, backoff, err)
+	}
+	if storeErr != nil {
+		slog.ErrorContext(spanCtx, fmt.Sprintf("failed to settle message %d from %s: %v", d.id, s.topic, storeErr))
+	}
+}
@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	otelContext "github.com/kartpop/cruncan/backend/pkg/otel/context"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startProducerSpan starts a producer span for the message and injects the span context into its headers
+func startProducerSpan(ctx context.Context, system, topic string, message *Message) (context.Context, trace.Span) {
+	ctx, links := kafkaUtil.PublishContext(ctx, kafkaUtil.NewMessageCarrier(message))
+	tracer, _ := otelContext.Tracer(ctx)
+	ctx, span := tracer.Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		links,
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String(system),
+			semconv.MessagingOperationPublish,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingMessageBodySize(len(message.Value)),
+		),
+	)
+	otel.GetTextMapPropagator().Inject(ctx, kafkaUtil.NewMessageCarrier(message))
+	return ctx, span
+}
+
+// startConsumerSpan starts a consumer span as the child of the trace context propagated in the message headers
+func startConsumerSpan(ctx context.Context, system, topic string, d *delivery) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, kafkaUtil.NewMessageCarrier(&d.message))
+	tracer, _ := otelContext.Tracer(ctx)
+	return tracer.Start(ctx, topic+" deliver",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String(system),
+			semconv.MessagingOperationDeliver,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingMessageBodySize(len(d.message.Value)),
+			semconv.MessagingMessageID(strconv.FormatInt(d.id, 10)),
+		),
+	)
+}
@@ -0,0 +1,175 @@
+// Package validation checks structs against the rules declared in their validate tags, e.g.
+//
+//	type Item struct {
+//		Key string `json:"key" validate:"required,max=64"`
+//	}
+//
+// The rules are:
+//
+//   - required: a string must not be empty or only whitespace, a slice must have elements
+//   - min=n, max=n: bound the length of a string, in characters, or the number of elements of a slice
+//   - unique=field: the elements of a slice of structs must differ in the field, named by its json name
+//
+// Nested structs and the struct elements of slices are validated with their own rules. Violations name the
+// field by its json path, e.g. data[1].key.
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Codes of the violations, they are stable and meant to be matched by clients
+const (
+	CodeRequired  = "required"
+	CodeTooShort  = "too_short"
+	CodeTooLong   = "too_long"
+	CodeTooFew    = "too_few"
+	CodeTooMany   = "too_many"
+	CodeDuplicate = "duplicate"
+)
+
+// Violation is a rule a field does not satisfy
+type Violation struct {
+	// Field is the json path of the field, e.g. data[1].key
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Field, v.Message)
+}
+
+// Validate returns the violations of the rules declared on v, a struct or a pointer to one, in field order. It
+// panics on a malformed tag, since that is a bug of the declaration rather than of the value.
+func Validate(v any) []Violation {
+	var violations []Violation
+	validateStruct(reflect.Indirect(reflect.ValueOf(v)), "", &violations)
+	return violations
+}
+
+func validateStruct(v reflect.Value, path string, violations *[]Violation) {
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldPath := joinPath(path, jsonName(field))
+		value := v.Field(i)
+		if tag, ok := field.Tag.Lookup("validate"); ok {
+			for _, rule := range strings.Split(tag, ",") {
+				if violation, ok := check(rule, value, fieldPath); !ok {
+					*violations = append(*violations, violation)
+				}
+			}
+		}
+
+		switch value.Kind() {
+		case reflect.Struct:
+			validateStruct(value, fieldPath, violations)
+		case reflect.Slice:
+			for j := range value.Len() {
+				validateStruct(reflect.Indirect(value.Index(j)), fmt.Sprintf("%s[%d]", fieldPath, j), violations)
+			}
+		}
+	}
+}
+
+// check checks the value against a single rule and returns the violation if it fails
+func check(rule string, value reflect.Value, path string) (Violation, bool) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+	violation := func(code, format string, args ...any) (Violation, bool) {
+		return Violation{Field: path, Code: code, Message: fmt.Sprintf(format, args...)}, false
+	}
+
+	switch name {
+	case "required":
+		if value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "" || size(value, name) == 0 {
+			return violation(CodeRequired, "is required")
+		}
+	case "min":
+		if n, limit := size(value, name), intArg(rule, arg); n < limit {
+			if value.Kind() == reflect.Slice {
+				return violation(CodeTooFew, "must have at least %d elements", limit)
+			}
+			return violation(CodeTooShort, "must be at least %d characters long", limit)
+		}
+	case "max":
+		if n, limit := size(value, name), intArg(rule, arg); n > limit {
+			if value.Kind() == reflect.Slice {
+				return violation(CodeTooMany, "must have at most %d elements", limit)
+			}
+			return violation(CodeTooLong, "must be at most %d characters long", limit)
+		}
+	case "unique":
+		if value.Kind() != reflect.Slice {
+			panic(fmt.Sprintf("validation: rule %q on %s needs a slice", rule, path))
+		}
+		seen := make(map[any]int, value.Len())
+		for j := range value.Len() {
+			key := fieldByJSONName(reflect.Indirect(value.Index(j)), arg, path).Interface()
+			if first, ok := seen[key]; ok {
+				return violation(CodeDuplicate, "elements %d and %d have the same %s %v", first, j, arg, key)
+			}
+			seen[key] = j
+		}
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q on %s", rule, path))
+	}
+	return Violation{}, true
+}
+
+// size is the length of a string in characters or the number of elements of a slice
+func size(value reflect.Value, rule string) int {
+	switch value.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(value.String())
+	case reflect.Slice:
+		return value.Len()
+	default:
+		panic(fmt.Sprintf("validation: rule %q needs a string or a slice, not %s", rule, value.Type()))
+	}
+}
+
+func intArg(rule, arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		panic(fmt.Sprintf("validation: rule %q needs a number", rule))
+	}
+	return n
+}
+
+func fieldByJSONName(v reflect.Value, name, path string) reflect.Value {
+	if v.Kind() == reflect.Struct {
+		for i := range v.NumField() {
+			if jsonName(v.Type().Field(i)) == name {
+				return v.Field(i)
+			}
+		}
+	}
+	panic(fmt.Sprintf("validation: the elements of %s have no field %q", path, name))
+}
+
+// jsonName is the name of the field in json, the Go name if the json tag does not rename it
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,134 @@
+package validation_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kartpop/cruncan/backend/pkg/model"
+	"github.com/kartpop/cruncan/backend/pkg/validation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateOneRequest(t *testing.T) {
+	valid := func() model.OneRequest {
+		return model.OneRequest{
+			UserID: "usr-1",
+			Prompt: "a prompt",
+			Data:   []model.Item{{Key: "k1", Value: "v1"}, {Key: "k2"}},
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		modify     func(*model.OneRequest)
+		violations []validation.Violation
+	}{
+		{
+			name:   "valid request",
+			modify: func(*model.OneRequest) {},
+		},
+		{
+			name:   "request without data",
+			modify: func(r *model.OneRequest) { r.Data = nil },
+		},
+		{
+			name: "missing user id and prompt",
+			modify: func(r *model.OneRequest) {
+				r.UserID = ""
+				r.Prompt = ""
+			},
+			violations: []validation.Violation{
+				{Field: "user_id", Code: validation.CodeRequired, Message: "is required"},
+				{Field: "prompt", Code: validation.CodeRequired, Message: "is required"},
+			},
+		},
+		{
+			name: "whitespace user id and prompt",
+			modify: func(r *model.OneRequest) {
+				r.UserID = " "
+				r.Prompt = "\n\t "
+			},
+			violations: []validation.Violation{
+				{Field: "user_id", Code: validation.CodeRequired, Message: "is required"},
+				{Field: "prompt", Code: validation.CodeRequired, Message: "is required"},
+			},
+		},
+		{
+			name:   "user id counted in characters",
+			modify: func(r *model.OneRequest) { r.UserID = strings.Repeat("ü", 64) },
+		},
+		{
+			name:   "user id too long",
+			modify: func(r *model.OneRequest) { r.UserID = strings.Repeat("u", 65) },
+			violations: []validation.Violation{
+				{Field: "user_id", Code: validation.CodeTooLong, Message: "must be at most 64 characters long"},
+			},
+		},
+		{
+			name: "too many items",
+			modify: func(r *model.OneRequest) {
+				r.Data = make([]model.Item, 101)
+				for i := range r.Data {
+					r.Data[i].Key = strings.Repeat("k", i+1)
+				}
+			},
+			violations: []validation.Violation{
+				{Field: "data", Code: validation.CodeTooMany, Message: "must have at most 100 elements"},
+			},
+		},
+		{
+			name: "item without key",
+			modify: func(r *model.OneRequest) {
+				r.Data[1].Key = ""
+			},
+			violations: []validation.Violation{
+				{Field: "data[1].key", Code: validation.CodeRequired, Message: "is required"},
+			},
+		},
+		{
+			name: "duplicate item keys",
+			modify: func(r *model.OneRequest) {
+				r.Data = append(r.Data, model.Item{Key: "k1", Value: "v3"})
+			},
+			violations: []validation.Violation{
+				{Field: "data", Code: validation.CodeDuplicate, Message: "elements 0 and 2 have the same key k1"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := valid()
+			tc.modify(&req)
+			assert.Equal(t, tc.violations, validation.Validate(req))
+			assert.Equal(t, tc.violations, validation.Validate(&req))
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	type nested struct {
+		Name string `validate:"min=2"`
+	}
+	type value struct {
+		Tags   []string `json:"tags" validate:"min=1"`
+		Nested nested   `json:"nested"`
+		hidden string   `validate:"required"`
+	}
+
+	violations := validation.Validate(value{Nested: nested{Name: "a"}})
+	assert.Equal(t, []validation.Violation{
+		{Field: "tags", Code: validation.CodeTooFew, Message: "must have at least 1 elements"},
+		{Field: "nested.Name", Code: validation.CodeTooShort, Message: "must be at least 2 characters long"},
+	}, violations)
+	assert.Equal(t, "tags: must have at least 1 elements", violations[0].String())
+
+	type malformed struct {
+		Count int `validate:"max=1"`
+	}
+	assert.Panics(t, func() { validation.Validate(malformed{}) })
+	type unknown struct {
+		Name string `validate:"email"`
+	}
+	assert.Panics(t, func() { validation.Validate(unknown{}) })
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -11,14 +12,19 @@ import (
 
 	"github.com/kartpop/cruncan/backend/pkg/accesstoken"
 	cfgUtil "github.com/kartpop/cruncan/backend/pkg/config"
+	gormUtil "github.com/kartpop/cruncan/backend/pkg/database/gorm"
 	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	"github.com/kartpop/cruncan/backend/pkg/kafka/dedup"
+	"github.com/kartpop/cruncan/backend/pkg/model"
 	"github.com/kartpop/cruncan/backend/pkg/otel"
+	"github.com/kartpop/cruncan/backend/pkg/queue"
 	"github.com/kartpop/cruncan/backend/pkg/util"
 	"github.com/kartpop/cruncan/backend/two/config"
 	httpInternal "github.com/kartpop/cruncan/backend/two/http"
 	"github.com/kartpop/cruncan/backend/two/onerequest"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"gorm.io/gorm"
 )
 
 var tracerName = "github.com/kartpop/cruncan/backend/two/cmd/consumer-tracer"
@@ -29,14 +35,49 @@ type Application struct {
 	name                   string
 	cfg                    *config.Model
 	kafkaClient            *kafkaUtil.Client
-	oneRequestConsumer     *kafkaUtil.Consumer
+	gormClient             *gorm.DB
+	queueGorm              *gorm.DB
+	oneRequestSubscriber   queue.Subscriber
 	oneRequestKafkaHandler *onerequest.KafkaHandler
+	// dedupStore is set when the dedup state is in postgres, its expired rows are purged while the app runs
+	dedupStore  *dedup.PostgresStore
+	stopPurging context.CancelFunc
 }
 
 func NewApplication(ctx context.Context, name string, cfg *config.Model) *Application {
-	kafkaClient, err := kafkaUtil.NewClient(cfg.Kafka.Common)
-	if err != nil {
-		util.Fatal("failed to create kafka client: %v", err)
+	var kafkaClient *kafkaUtil.Client
+	var queueGorm *gorm.DB
+	var broker queue.Broker
+	var err error
+	switch cfg.Queue.Backend {
+	case "", queue.BackendKafka:
+		kafkaClient, err = kafkaUtil.NewClient(ctx, cfg.Kafka.Common)
+		if err != nil {
+			util.Fatal("failed to create kafka client: %v", err)
+		}
+		if cfg.Kafka.ProvisionTopics {
+			provisionTopics(ctx, kafkaClient, cfg.Kafka.OneRequestTopic)
+		}
+		broker = queue.NewKafkaBroker(kafkaClient,
+			kafkaUtil.WithRetryTopics(cfg.Kafka.OneRequestTopic),
+			kafkaUtil.WithConcurrency(cfg.Kafka.OneRequestConcurrency),
+			kafkaUtil.WithCommitMode(cfg.Kafka.OneRequestCommitMode),
+		)
+	case queue.BackendPostgres:
+		// the queue table belongs to one, two's own database does not have it
+		if cfg.Queue.Database == nil {
+			util.Fatal("queue backend %q requires QUEUE.DATABASE, the database of one", cfg.Queue.Backend)
+		}
+		queueGorm, err = gormUtil.NewGormClient(cfg.Queue.Database)
+		if err != nil {
+			util.Fatal("queue database not available on startup: %v", err)
+		}
+		broker = queue.NewPostgresBroker(queueGorm, cfg.Queue)
+	case queue.BackendMemory:
+		// the messages are published by one, which runs in another process
+		util.Fatal("queue backend %q only works within one process, use %q or %q", cfg.Queue.Backend, queue.BackendKafka, queue.BackendPostgres)
+	default:
+		util.Fatal("unknown queue backend %q", cfg.Queue.Backend)
 	}
 
 	httpClient := &http.Client{
@@ -55,7 +96,45 @@ func NewApplication(ctx context.Context, name string, cfg *config.Model) *Applic
 	tokenCacheClient := accesstoken.NewClientCache(tokenClient, time.Now().UTC)
 	threeClient := httpInternal.NewClient(httpClient, cfg.Three.Url, slog.Default(), tokenCacheClient)
 
-	oneRequestConsumer := kafkaClient.NewConsumer(cfg.Kafka.OneRequestTopic.Name)
+	metrics, err := kafkaUtil.Metrics(ctx)
+	if err != nil {
+		util.Fatal("failed to create kafka consumer metrics: %v", err)
+	}
+	middlewares := []kafkaUtil.Middleware{
+		kafkaUtil.Recover(),
+		kafkaUtil.Tracing(),
+		metrics,
+		kafkaUtil.Logging(),
+		kafkaUtil.Timeout(cfg.Kafka.OneRequestHandlerTimeout),
+	}
+	var gormClient *gorm.DB
+	var postgresDedupStore *dedup.PostgresStore
+	if store := cfg.Kafka.OneRequestDedup.Store; store != "" {
+		var dedupStore dedup.Store
+		switch store {
+		case dedup.StoreMemory:
+			dedupStore = dedup.NewMemoryStore(cfg.Kafka.OneRequestDedup.Retention)
+		case dedup.StorePostgres:
+			gormClient, err = gormUtil.NewGormClient(cfg.Database)
+			if err != nil {
+				util.Fatal("failed to create database client: %v", err)
+			}
+			postgresDedupStore = dedup.NewPostgresStore(gormClient, cfg.Kafka.OneRequestDedup.Retention)
+			dedupStore = postgresDedupStore
+		default:
+			util.Fatal("unknown dedup store %q", store)
+		}
+		// inside Timeout, so a message that times out is released and handled again on retry
+		dedupMiddleware, err := dedup.Middleware(ctx, dedupStore, cfg.Kafka.OneRequestDedup)
+		if err != nil {
+			util.Fatal("failed to create kafka dedup middleware: %v", err)
+		}
+		middlewares = append(middlewares, dedupMiddleware)
+	}
+	oneRequestSubscriber, err := broker.Subscriber(cfg.Kafka.OneRequestTopic.Name, middlewares...)
+	if err != nil {
+		util.Fatal("failed to create one request subscriber: %v", err)
+	}
 	oneRequestKafkaHandler := onerequest.NewKafkaHandler(ctx, threeClient)
 
 	return &Application{
@@ -63,22 +142,71 @@ func NewApplication(ctx context.Context, name string, cfg *config.Model) *Applic
 		name:                   name,
 		cfg:                    cfg,
 		kafkaClient:            kafkaClient,
-		oneRequestConsumer:     oneRequestConsumer,
+		gormClient:             gormClient,
+		queueGorm:              queueGorm,
+		oneRequestSubscriber:   oneRequestSubscriber,
 		oneRequestKafkaHandler: oneRequestKafkaHandler,
+		dedupStore:             postgresDedupStore,
 	}
 }
 
 func (app *Application) Run() []util.TerminatorFunc {
-	app.oneRequestConsumer.Start(app.ctx, app.oneRequestKafkaHandler)
+	app.oneRequestSubscriber.Start(app.ctx, kafkaUtil.NewTypedHandler[model.OneRequest](app.oneRequestKafkaHandler))
+	if app.dedupStore != nil {
+		// rows expire after the configured retention, purging keeps the table from growing with every message
+		purgeCtx, cancel := context.WithCancel(app.ctx)
+		app.stopPurging = cancel
+		go app.dedupStore.PurgeEvery(purgeCtx, app.cfg.Kafka.OneRequestDedup.PurgeInterval)
+	}
 
 	return []util.TerminatorFunc{
 		func(ctx context.Context) error {
-			app.kafkaClient.Close()
-			return nil
+			if app.stopPurging != nil {
+				app.stopPurging()
+			}
+			// drain the subscriber first so its in-flight requests are acked, and marked done by dedup, before
+			// the clients close
+			err := app.oneRequestSubscriber.Stop(ctx)
+			if app.kafkaClient != nil {
+				err = errors.Join(err, app.kafkaClient.Close(ctx))
+			}
+			return errors.Join(err, closeGorm(app.queueGorm), closeGorm(app.gormClient))
 		},
 	}
 }
 
+// closeGorm closes the connections of the gorm client, if there is one
+func closeGorm(gormClient *gorm.DB) error {
+	if gormClient == nil {
+		return nil
+	}
+	gormDB, err := gormClient.DB()
+	if err != nil {
+		return err
+	}
+	return gormDB.Close()
+}
+
+// provisionTopics ensures the topics exist before the application uses them. Drift that cannot be
+// fixed automatically is logged but does not stop the application.
+func provisionTopics(ctx context.Context, kafkaClient *kafkaUtil.Client, topics ...kafkaUtil.Topic) {
+	report, err := kafkaClient.NewAdmin().EnsureTopics(ctx, topics...)
+	if err != nil {
+		util.Fatal("failed to provision kafka topics: %v", err)
+	}
+	for _, topic := range report.Created {
+		slog.InfoContext(ctx, fmt.Sprintf("created kafka topic %s", topic))
+	}
+	for _, drift := range report.Drift {
+		msg := fmt.Sprintf("kafka topic %s %s is %s, configured %s", drift.Topic, drift.Setting, drift.Actual, drift.Expected)
+		if drift.Fixed {
+			slog.InfoContext(ctx, msg+", updated")
+		} else {
+			slog.WarnContext(ctx, msg)
+		}
+	}
+}
+
 func main() {
 	ctx, cancel := otel.Setup(tracerName, meterName)
 	defer cancel()
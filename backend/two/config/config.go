@@ -1,8 +1,12 @@
 package config
 
 import (
+	"time"
+
 	gormUtil "github.com/kartpop/cruncan/backend/pkg/database/gorm"
 	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	"github.com/kartpop/cruncan/backend/pkg/kafka/dedup"
+	"github.com/kartpop/cruncan/backend/pkg/queue"
 )
 
 type Model struct {
@@ -14,11 +18,23 @@ type Model struct {
 	Kafka    KafkaConfig      `mapstructure:"KAFKA_CONFIG"`
 	Auth     AuthConfig       `mapstructure:"AUTH_CONFIG"`
 	Three    ThreeConfig      `mapstructure:"THREE_CONFIG"`
+	// Queue selects the broker one requests are consumed from, the postgres backend reads the queue table of
+	// one through its required Database
+	Queue queue.Config `mapstructure:"QUEUE"`
 }
 
 type KafkaConfig struct {
-	Common          *kafkaUtil.Config `mapstructure:"COMMON"`
-	OneRequestTopic kafkaUtil.Topic   `mapstructure:"ONE_REQUEST_TOPIC"`
+	Common                *kafkaUtil.Config     `mapstructure:"COMMON"`
+	OneRequestTopic       kafkaUtil.Topic       `mapstructure:"ONE_REQUEST_TOPIC"`
+	OneRequestConcurrency kafkaUtil.Concurrency `mapstructure:"ONE_REQUEST_CONCURRENCY"`
+	// OneRequestCommitMode is marked (default), record or auto, see kafkaUtil.CommitMode
+	OneRequestCommitMode kafkaUtil.CommitMode `mapstructure:"ONE_REQUEST_COMMIT_MODE"`
+	// OneRequestHandlerTimeout is the deadline for handling a single one request message
+	OneRequestHandlerTimeout time.Duration `mapstructure:"ONE_REQUEST_HANDLER_TIMEOUT"`
+	// OneRequestDedup skips one request messages that were handled before, the postgres store uses Database
+	OneRequestDedup dedup.Config `mapstructure:"ONE_REQUEST_DEDUP"`
+	// ProvisionTopics creates the topics and grows their partitions on startup
+	ProvisionTopics bool `mapstructure:"PROVISION_TOPICS"`
 }
 
 type AuthConfig struct {
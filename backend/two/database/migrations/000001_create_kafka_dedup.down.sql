@@ -0,0 +1 @@
+DROP TABLE IF EXISTS kafka_dedup;
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS kafka_dedup (
+    id text NOT NULL,
+    status text NOT NULL,
+    token text NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS kafka_dedup_expires_at_idx ON kafka_dedup (expires_at);
@@ -10,3 +10,9 @@ Feature: Onerequest kafka handler
       | status_code | threerequest_body         | token_body                | onerequest_body       |
       | 200         | "data/3r_body.json"       | "data/token_body.json"    | "data/1r_body.json"   |
 
+  Scenario: Should post a redelivered onerequest kafka message to threerequest api once
+    Given the threerequest API responds with 200 on matching body from file "data/3r_body.json"
+    And the token API response with 200 on matching body from file "data/token_body.json"
+    When a onerequest from file "data/1r_body.json" is ingested twice with message id "redelivered-1"
+    Then a request is made to the threerequest API
+    And no further request is made to the threerequest API
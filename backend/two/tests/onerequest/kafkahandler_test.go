@@ -5,29 +5,30 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/cucumber/godog"
+	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
 	"github.com/kartpop/cruncan/backend/two/tests/utils"
-	"github.com/wiremock/go-wiremock"
 )
 
 type testFixtureKey struct{}
 
 type testFixture struct {
-	stubsToDelete []*wiremock.StubRule
+	stubs []*utils.Stub
+	// threeStub is the stub of the threerequest API, the token API is only called until the token is cached
+	threeStub *utils.Stub
 }
 
-func (tf *testFixture) lastStubRule() (stubRule *wiremock.StubRule, ok bool) {
-	if len(tf.stubsToDelete) == 0 {
-		return nil, false
-	}
-	return tf.stubsToDelete[len(tf.stubsToDelete)-1], true
-}
+// service runs in-process for the whole suite, see utils.StartService
+var service *utils.Service
 
 func TestFeatures(t *testing.T) {
+	service = utils.StartService(t)
+
 	suite := godog.TestSuite{
 		TestSuiteInitializer: InitializeSuite,
 		ScenarioInitializer:  InitializeScenario,
@@ -52,12 +53,7 @@ func InitializeSuite(ctx *godog.TestSuiteContext) {
 	})
 
 	ctx.ScenarioContext().After(func(ctx context.Context, sc *godog.Scenario, err error) (context.Context, error) {
-		ctxKeyValue := ctx.Value(key)
-		ctxData := ctxKeyValue.(*testFixture)
-
-		for _, stubRule := range ctxData.stubsToDelete {
-			_ = utils.WiremockClient.DeleteStub(stubRule)
-		}
+		service.Stubs.Reset()
 
 		return ctx, nil
 	})
@@ -68,7 +64,9 @@ func InitializeScenario(ctx *godog.ScenarioContext) {
 	ctx.Step(`^the threerequest API responds with (\d+) on matching body from file "([^"]*)"$`, threeReqApiMatchesBodyAndResponds)
 	ctx.Step(`^the token API response with (\d+) on matching body from file "([^"]*)"$`, tokenApiMatchesBodyAndResponds)
 	ctx.Step(`^a onerequest from file "([^"]*)" is ingested$`, onerequestIsIngested)
+	ctx.Step(`^a onerequest from file "([^"]*)" is ingested twice with message id "([^"]*)"$`, onerequestIsIngestedTwice)
 	ctx.Step(`^a request is made to the threerequest API$`, requestIsMadeToThreeRequestApi)
+	ctx.Step(`^no further request is made to the threerequest API$`, noFurtherRequestIsMadeToThreeRequestApi)
 }
 
 func threeReqApiMatchesBodyAndResponds(ctx context.Context, expectedStatusCode int, filePath string) (context.Context, error) {
@@ -79,18 +77,15 @@ func threeReqApiMatchesBodyAndResponds(ctx context.Context, expectedStatusCode i
 		return ctx, fmt.Errorf("could not read file %s: %v", filePath, err)
 	}
 
-	rule := wiremock.Post(wiremock.URLEqualTo("/three")).
-		WillReturnResponse(
-			wiremock.NewResponse().
-				WithStatus(int64(expectedStatusCode)).
-				WithHeader("Content-Type", "application/json"),
-		).
-		WithBodyPattern(wiremock.EqualToJson(string(b), wiremock.IgnoreArrayOrder, wiremock.IgnoreExtraElements))
-	err = utils.WiremockClient.StubFor(rule)
+	stub := utils.NewStub(http.MethodPost, "/three").
+		WithJSONBody(b).
+		WillReturn(expectedStatusCode, "application/json", nil)
+	service.Stubs.Add(stub)
 
-	ctxData.stubsToDelete = append(ctxData.stubsToDelete, rule)
+	ctxData.stubs = append(ctxData.stubs, stub)
+	ctxData.threeStub = stub
 
-	return ctx, err
+	return ctx, nil
 }
 
 func tokenApiMatchesBodyAndResponds(ctx context.Context, expectedStatusCode int, filePath string) (context.Context, error) {
@@ -104,20 +99,15 @@ func tokenApiMatchesBodyAndResponds(ctx context.Context, expectedStatusCode int,
 	credentials := utils.EnvConfig.Auth.ClientID + ":" + utils.EnvConfig.Auth.ClientSecret
 	authHeader := "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
 
-	rule := wiremock.Post(wiremock.URLEqualTo("/v2/oauth/token")).
-		WithHeader("Content-Type", wiremock.EqualTo("application/x-www-form-urlencoded")).
-		WithHeader("Authorization", wiremock.EqualTo(authHeader)).
-		WillReturnResponse(
-			wiremock.NewResponse().
-				WithStatus(int64(expectedStatusCode)).
-				WithHeader("Content-Type", "application/x-www-form-urlencoded").
-				WithBody(string(b)),
-		)
-	err = utils.WiremockClient.StubFor(rule)
+	stub := utils.NewStub(http.MethodPost, "/v2/oauth/token").
+		WithHeader("Content-Type", "application/x-www-form-urlencoded").
+		WithHeader("Authorization", authHeader).
+		WillReturn(expectedStatusCode, "application/x-www-form-urlencoded", b)
+	service.Stubs.Add(stub)
 
-	ctxData.stubsToDelete = append(ctxData.stubsToDelete, rule)
+	ctxData.stubs = append(ctxData.stubs, stub)
 
-	return ctx, err
+	return ctx, nil
 }
 
 func onerequestIsIngested(ctx context.Context, filePath string) (context.Context, error) {
@@ -126,7 +116,22 @@ func onerequestIsIngested(ctx context.Context, filePath string) (context.Context
 		return ctx, fmt.Errorf("could not read file %s: %v", filePath, err)
 	}
 
-	err = utils.OneRequestProducer.SendMessage(ctx, b)
+	err = service.OneRequestProducer.SendMessage(ctx, b)
+
+	return ctx, err
+}
+
+func onerequestIsIngestedTwice(ctx context.Context, filePath, messageID string) (context.Context, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return ctx, fmt.Errorf("could not read file %s: %v", filePath, err)
+	}
+
+	message := kafkaUtil.Message{
+		Value:   b,
+		Headers: []kafkaUtil.Header{{Key: kafkaUtil.HeaderMessageID, Value: []byte(messageID)}},
+	}
+	err = service.OneRequestProducer.Send(ctx, message, message).FirstErr()
 
 	return ctx, err
 }
@@ -152,18 +157,29 @@ func requestIsMadeToThreeRequestApi(ctx context.Context) (context.Context, error
 	}
 }
 
+func noFurtherRequestIsMadeToThreeRequestApi(ctx context.Context) (context.Context, error) {
+	// give the consumer time to handle the redelivered message before counting
+	time.Sleep(2 * time.Second)
+
+	ctxData := ctx.Value(testFixtureKey{}).(*testFixture)
+	if ctxData.threeStub == nil {
+		return ctx, fmt.Errorf("no threerequest stub found")
+	}
+	if count := ctxData.threeStub.Count(); count != 1 {
+		return ctx, fmt.Errorf("expected 1 request to the threerequest API, but got %d", count)
+	}
+
+	return ctx, nil
+}
+
 func verifyStub(ctx context.Context, expectedCount int64) error {
 	ctxData := ctx.Value(testFixtureKey{}).(*testFixture)
 
-	lastStub, ok := ctxData.lastStubRule()
-	if !ok {
-		return fmt.Errorf("no stub rule found")
+	if ctxData.threeStub == nil {
+		return fmt.Errorf("no threerequest stub found")
 	}
 
-	count, err := utils.WiremockClient.GetCountRequests(lastStub.Request())
-	if err != nil {
-		return fmt.Errorf("could not verify stub rule: %v", err)
-	}
+	count := int64(ctxData.threeStub.Count())
 
 	// TODO: count sometimes is greater than expectedCount, investigate why
 	if count < expectedCount {
@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// StubServer is an in-process stand-in for the external APIs of the service. Requests that match no stub are
+// answered with 404.
+type StubServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	stubs []*Stub
+}
+
+// Stub answers the requests it matches and counts them
+type Stub struct {
+	method  string
+	path    string
+	headers map[string]string
+	// body is the expected JSON body, the request may have extra fields
+	body any
+
+	status      int
+	contentType string
+	response    []byte
+
+	mu    sync.Mutex
+	count int
+}
+
+// NewStubServer starts a stub server that is closed when the test ends
+func NewStubServer(t testing.TB) *StubServer {
+	s := &StubServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// NewStub creates a stub for requests with the method and path
+func NewStub(method, path string) *Stub {
+	return &Stub{method: method, path: path, headers: map[string]string{}, status: http.StatusOK}
+}
+
+// WithHeader only matches requests with the header value
+func (s *Stub) WithHeader(key, value string) *Stub {
+	s.headers[key] = value
+	return s
+}
+
+// WithJSONBody only matches requests whose JSON body contains the fields of the JSON document
+func (s *Stub) WithJSONBody(body []byte) *Stub {
+	if err := json.Unmarshal(body, &s.body); err != nil {
+		panic("invalid stub body: " + err.Error())
+	}
+	return s
+}
+
+// WillReturn answers matched requests with the status and body
+func (s *Stub) WillReturn(status int, contentType string, body []byte) *Stub {
+	s.status, s.contentType, s.response = status, contentType, body
+	return s
+}
+
+// Count returns the number of requests the stub answered
+func (s *Stub) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Add adds the stub, stubs added later take precedence
+func (s *StubServer) Add(stub *Stub) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stubs = append(s.stubs, stub)
+}
+
+// Reset removes all stubs
+func (s *StubServer) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stubs = nil
+}
+
+func (s *StubServer) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	stubs := s.stubs
+	s.mu.Unlock()
+
+	for i := len(stubs) - 1; i >= 0; i-- {
+		stub := stubs[i]
+		if !stub.matches(r, body) {
+			continue
+		}
+		stub.mu.Lock()
+		stub.count++
+		stub.mu.Unlock()
+
+		if stub.contentType != "" {
+			w.Header().Set("Content-Type", stub.contentType)
+		}
+		w.WriteHeader(stub.status)
+		_, _ = w.Write(stub.response)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (s *Stub) matches(r *http.Request, body []byte) bool {
+	if r.Method != s.method || r.URL.Path != s.path {
+		return false
+	}
+	for key, value := range s.headers {
+		if r.Header.Get(key) != value {
+			return false
+		}
+	}
+	if s.body == nil {
+		return true
+	}
+	var actual any
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&actual); err != nil {
+		return false
+	}
+	return containsJSON(actual, s.body)
+}
+
+// containsJSON reports whether the decoded JSON value contains the expected one, objects may have extra
+// fields and arrays may be in any order
+func containsJSON(actual, expected any) bool {
+	switch expected := expected.(type) {
+	case map[string]any:
+		actual, ok := actual.(map[string]any)
+		if !ok {
+			return false
+		}
+		for key, value := range expected {
+			if !containsJSON(actual[key], value) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		actual, ok := actual.([]any)
+		if !ok || len(actual) != len(expected) {
+			return false
+		}
+		used := make([]bool, len(actual))
+		for _, value := range expected {
+			found := false
+			for i, candidate := range actual {
+				if !used[i] && containsJSON(candidate, value) {
+					used[i], found = true, true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(actual, expected)
+	}
+}
@@ -1,24 +1,70 @@
 package utils
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
 
+	"github.com/kartpop/cruncan/backend/pkg/accesstoken"
 	cfgUtil "github.com/kartpop/cruncan/backend/pkg/config"
 	kafkaUtil "github.com/kartpop/cruncan/backend/pkg/kafka"
+	"github.com/kartpop/cruncan/backend/pkg/kafka/dedup"
+	"github.com/kartpop/cruncan/backend/pkg/kafka/kafkatest"
+	"github.com/kartpop/cruncan/backend/pkg/model"
 	"github.com/kartpop/cruncan/backend/two/config"
-	"github.com/wiremock/go-wiremock"
+	httpInternal "github.com/kartpop/cruncan/backend/two/http"
+	"github.com/kartpop/cruncan/backend/two/onerequest"
 )
 
 var EnvConfig = cfgUtil.LoadConfigOrPanic[config.Model]("../../config", "config")
-var WiremockClient = wiremock.NewClient("http://localhost:8099")
-var kafkaClient = initKafkaClient()
-var OneRequestProducer = kafkaClient.NewProducer(EnvConfig.Kafka.OneRequestTopic.Name)
 
-// TODO: client is not closed anywhere yet after initialization, refer one service tests
-func initKafkaClient() *kafkaUtil.Client {
-	kafkaClient, err := kafkaUtil.NewClient(EnvConfig.Kafka.Common)
+// Service runs the one request consumer of the service in-process, against a fake kafka cluster and stubs of
+// the token and three APIs
+type Service struct {
+	Kafka              *kafkatest.Cluster
+	Stubs              *StubServer
+	OneRequestProducer *kafkaUtil.Producer
+}
+
+// StartService starts the service, it is stopped when the test ends
+func StartService(t testing.TB) *Service {
+	cfg := EnvConfig.Kafka
+	cluster := kafkatest.NewCluster(t, cfg.OneRequestTopic)
+	stubs := NewStubServer(t)
+
+	tokenClient, err := accesstoken.NewClient(&http.Client{}, EnvConfig.Auth.ClientID, EnvConfig.Auth.ClientSecret, stubs.URL)
+	if err != nil {
+		t.Fatalf("failed to create access token client: %v", err)
+	}
+	threeClient := httpInternal.NewClient(&http.Client{}, stubs.URL, slog.Default(), accesstoken.NewClientCache(tokenClient, time.Now().UTC))
+
+	// the memory store stands in for the database, a single consumer deduplicates the same way
+	dedupMiddleware, err := dedup.Middleware(context.Background(), dedup.NewMemoryStore(cfg.OneRequestDedup.Retention), cfg.OneRequestDedup)
 	if err != nil {
-		panic(fmt.Sprintf("failed to create kafka client: %v", err))
+		t.Fatalf("failed to create kafka dedup middleware: %v", err)
+	}
+
+	kafkaClient := cluster.NewClient(t, cfg.Common)
+	consumer, err := kafkaClient.NewConsumer(
+		cfg.OneRequestTopic.Name,
+		kafkaUtil.WithRetryTopics(cfg.OneRequestTopic),
+		kafkaUtil.WithConcurrency(cfg.OneRequestConcurrency),
+		kafkaUtil.WithCommitMode(cfg.OneRequestCommitMode),
+		kafkaUtil.WithMiddleware(kafkaUtil.Recover(), kafkaUtil.Logging(), kafkaUtil.Timeout(cfg.OneRequestHandlerTimeout), dedupMiddleware),
+	)
+	if err != nil {
+		t.Fatalf("failed to create kafka consumer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	kafkaUtil.NewTypedConsumer[model.OneRequest](consumer).Start(ctx, onerequest.NewKafkaHandler(ctx, threeClient))
+
+	return &Service{
+		Kafka:              cluster,
+		Stubs:              stubs,
+		OneRequestProducer: cluster.Client.NewProducer(cfg.OneRequestTopic.Name),
 	}
-	return kafkaClient
 }